@@ -0,0 +1,69 @@
+package gozstd
+
+/*
+#include <stdint.h>
+#include <stddef.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// allocator holds Go callbacks passed to NewCCtxWithAllocator
+// and NewDCtxWithAllocator.
+type allocator struct {
+	alloc func(size int) unsafe.Pointer
+	free  func(p unsafe.Pointer)
+}
+
+// Allocators are referred from C by ids, since C code cannot hold
+// pointers to Go memory.
+var (
+	allocatorsLock  sync.Mutex
+	allocators      = make(map[uintptr]*allocator)
+	allocatorNextID uintptr
+)
+
+func registerAllocator(alloc func(size int) unsafe.Pointer, free func(p unsafe.Pointer)) uintptr {
+	if alloc == nil || free == nil {
+		panic(fmt.Errorf("BUG: alloc and free callbacks cannot be nil"))
+	}
+	allocatorsLock.Lock()
+	allocatorNextID++
+	id := allocatorNextID
+	allocators[id] = &allocator{
+		alloc: alloc,
+		free:  free,
+	}
+	allocatorsLock.Unlock()
+	return id
+}
+
+func unregisterAllocator(id uintptr) {
+	allocatorsLock.Lock()
+	delete(allocators, id)
+	allocatorsLock.Unlock()
+}
+
+func getAllocator(id uintptr) *allocator {
+	allocatorsLock.Lock()
+	a := allocators[id]
+	allocatorsLock.Unlock()
+	if a == nil {
+		panic(fmt.Errorf("BUG: missing allocator with id=%d", id))
+	}
+	return a
+}
+
+//export goZstdAlloc
+func goZstdAlloc(id C.uintptr_t, size C.size_t) unsafe.Pointer {
+	return getAllocator(uintptr(id)).alloc(int(size))
+}
+
+//export goZstdFree
+func goZstdFree(id C.uintptr_t, p unsafe.Pointer) {
+	getAllocator(uintptr(id)).free(p)
+}
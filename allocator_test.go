@@ -0,0 +1,124 @@
+//go:build cgo && (linux || darwin || freebsd)
+// +build cgo
+// +build linux darwin freebsd
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// countingArena is a bump allocator over mmap-ed memory, which counts
+// allocations. Memory obtained from Go heap cannot be passed to zstd.
+type countingArena struct {
+	mu     sync.Mutex
+	buf    []byte
+	offset int
+	live   map[uintptr]int
+
+	allocs    int
+	frees     int
+	allocated int
+}
+
+func newCountingArena(t *testing.T, size int) *countingArena {
+	t.Helper()
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Fatalf("cannot mmap arena: %s", err)
+	}
+	return &countingArena{
+		buf:  buf,
+		live: make(map[uintptr]int),
+	}
+}
+
+func (a *countingArena) release() error {
+	return syscall.Munmap(a.buf)
+}
+
+func (a *countingArena) alloc(size int) unsafe.Pointer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Align allocations to 64 bytes.
+	offset := (a.offset + 63) &^ 63
+	if offset+size > len(a.buf) {
+		return nil
+	}
+	a.offset = offset + size
+	p := unsafe.Pointer(&a.buf[offset])
+	a.live[uintptr(p)] = size
+	a.allocs++
+	a.allocated += size
+	return p
+}
+
+func (a *countingArena) free(p unsafe.Pointer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	size, ok := a.live[uintptr(p)]
+	if !ok {
+		panic(fmt.Errorf("BUG: unexpected free of %p", p))
+	}
+	delete(a.live, uintptr(p))
+	a.frees++
+	a.allocated -= size
+}
+
+func TestCCtxWithAllocator(t *testing.T) {
+	a := newCountingArena(t, 256<<20)
+	defer a.release()
+
+	c, err := NewCCtxWithAllocator(a.alloc, a.free)
+	if err != nil {
+		t.Fatalf("cannot create CCtx: %s", err)
+	}
+	d, err := NewDCtxWithAllocator(a.alloc, a.free)
+	if err != nil {
+		t.Fatalf("cannot create DCtx: %s", err)
+	}
+
+	src := []byte(newTestString(256*1024, 3))
+	for i := 0; i < 3; i++ {
+		cd := c.Compress(nil, src)
+		if !bytes.Equal(cd, Compress(nil, src)) {
+			t.Fatalf("unexpected compressed data")
+		}
+		plainData, err := d.Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+	}
+	if a.allocs == 0 {
+		t.Fatalf("expecting non-zero number of allocations")
+	}
+	if a.allocated == 0 {
+		t.Fatalf("expecting non-zero allocated memory")
+	}
+
+	c.Release()
+	d.Release()
+	if a.allocs != a.frees {
+		t.Fatalf("unexpected number of frees; got %d; want %d", a.frees, a.allocs)
+	}
+	if a.allocated != 0 {
+		t.Fatalf("unexpected allocated memory after Release; got %d bytes; want 0 bytes", a.allocated)
+	}
+
+	// Allocation failure must be reported.
+	failingAlloc := func(size int) unsafe.Pointer { return nil }
+	if _, err := NewCCtxWithAllocator(failingAlloc, a.free); err == nil {
+		t.Fatalf("expecting non-nil error when allocation fails")
+	}
+	if _, err := NewDCtxWithAllocator(failingAlloc, a.free); err == nil {
+		t.Fatalf("expecting non-nil error when allocation fails")
+	}
+}
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
@@ -28,3 +31,68 @@ var decOutBufPool = sync.Pool{
 		return bytes.NewBuffer(make([]byte, 0, dstreamOutBufSize))
 	},
 }
+
+// BufferPool is a pool of byte buffers for dst passed to Compress*
+// and Decompress* functions.
+//
+// The zero BufferPool is ready to use. BufferPool may be used
+// from concurrently running goroutines.
+type BufferPool struct {
+	// Size is the capacity of newly allocated buffers.
+	//
+	// Zero means DefaultBufferPoolSize.
+	Size int
+
+	p sync.Pool
+
+	// holders contains empty bufferHolder objects, so Put doesn't allocate.
+	holders sync.Pool
+}
+
+// DefaultBufferPoolSize is the capacity of buffers allocated by BufferPool
+// with zero Size.
+//
+// It fits the data compressed from a full zstd block of 128KB,
+// i.e. CompressBound(128KB).
+const DefaultBufferPoolSize = 128*1024 + (128*1024)>>8
+
+type bufferHolder struct {
+	b []byte
+}
+
+// Get returns an empty buffer from bp.
+//
+// Return the buffer to bp via Put when it is no longer needed.
+func (bp *BufferPool) Get() []byte {
+	v := bp.p.Get()
+	if v == nil {
+		size := bp.Size
+		if size <= 0 {
+			size = DefaultBufferPoolSize
+		}
+		return make([]byte, 0, size)
+	}
+	bh := v.(*bufferHolder)
+	b := bh.b
+	bh.b = nil
+	bp.holders.Put(bh)
+	return b[:0]
+}
+
+// Put returns b to bp.
+//
+// b cannot be used after returning to bp. Buffers grown by Compress*
+// and Decompress* functions may be returned, so the subsequent Get calls
+// return buffers with the grown capacity.
+func (bp *BufferPool) Put(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	v := bp.holders.Get()
+	if v == nil {
+		v = &bufferHolder{}
+	}
+	bh := v.(*bufferHolder)
+	bh.b = b[:0]
+	bp.p.Put(bh)
+}
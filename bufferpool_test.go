@@ -0,0 +1,76 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	if n := CompressBoundCached(128 * 1024); n != DefaultBufferPoolSize {
+		t.Fatalf("unexpected DefaultBufferPoolSize; got %d; want %d", DefaultBufferPoolSize, n)
+	}
+
+	var bp BufferPool
+	b := bp.Get()
+	if len(b) != 0 {
+		t.Fatalf("unexpected buffer length; got %d; want 0", len(b))
+	}
+	if cap(b) != DefaultBufferPoolSize {
+		t.Fatalf("unexpected buffer capacity; got %d; want %d", cap(b), DefaultBufferPoolSize)
+	}
+
+	// The returned buffer must be re-used. sync.Pool may drop buffers
+	// at random under race detector, so try multiple times.
+	reused := false
+	for i := 0; i < 100 && !reused; i++ {
+		b = append(b[:0], "foobar"...)
+		p := &b[:1][0]
+		bp.Put(b)
+		b = bp.Get()
+		if len(b) != 0 {
+			t.Fatalf("unexpected buffer length; got %d; want 0", len(b))
+		}
+		reused = cap(b) > 0 && &b[:1][0] == p
+	}
+	if !reused {
+		t.Fatalf("the buffer returned to the pool must be re-used")
+	}
+
+	// Buffers from the pool may be used as dst.
+	src := []byte(newTestString(300*1024, 3))
+	b = Compress(bp.Get(), src)
+	plainBuf := bp.Get()
+	plainData, err := Decompress(plainBuf, b)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+	bp.Put(b)
+	bp.Put(plainData)
+
+	// Custom size.
+	bp2 := &BufferPool{
+		Size: 1234,
+	}
+	if n := cap(bp2.Get()); n != 1234 {
+		t.Fatalf("unexpected buffer capacity; got %d; want 1234", n)
+	}
+}
+
+func TestBufferPoolNoAllocs(t *testing.T) {
+	var bp BufferPool
+	bp.Put(bp.Get())
+	allocs := testing.AllocsPerRun(100, func() {
+		b := bp.Get()
+		b = append(b, "foobar"...)
+		bp.Put(b)
+	})
+	if allocs > 0 {
+		t.Fatalf("unexpected memory allocations; got %v; want 0", allocs)
+	}
+}
@@ -0,0 +1,75 @@
+package gozstd
+
+import (
+	"math"
+)
+
+const (
+	// compressibleMinLen is the minimum src length for entropy estimation.
+	// Shorter src is reported as compressible.
+	compressibleMinLen = 1024
+
+	// src is sampled by compressibleSampleChunks chunks
+	// with compressibleChunkLen bytes each, evenly spread over src.
+	compressibleSampleChunks = 64
+	compressibleChunkLen     = 64
+
+	// compressibleMaxEntropy is the maximum estimated entropy in bits
+	// per byte for compressible data. Random data has 8 bits per byte.
+	compressibleMaxEntropy = 7.5
+)
+
+// IsLikelyCompressible returns true if src is likely to be compressible.
+//
+// It is a cheap check, which may be used for skipping the compression
+// of already compressed, encrypted or random data. The check doesn't
+// require CGO.
+//
+// Up to 4KB of src is sampled in 64-byte chunks evenly spread over src.
+// Then the order-0 byte entropy of the sample is estimated. src is reported
+// as compressible if the entropy is lower than 7.5 bits per byte.
+//
+// The heuristic doesn't detect long-range repetitions, so high-entropy
+// data repeated multiple times may be reported as incompressible,
+// though zstd compresses it well. Data with skewed byte distribution
+// such as text, hex or base64 is reported as compressible, even if its
+// actual compression ratio is low. src shorter than 1KB is always reported
+// as compressible, since its entropy cannot be estimated reliably.
+func IsLikelyCompressible(src []byte) bool {
+	if len(src) < compressibleMinLen {
+		return true
+	}
+
+	var hist [256]int
+	n := 0
+	if len(src) <= compressibleSampleChunks*compressibleChunkLen {
+		for _, b := range src {
+			hist[b]++
+		}
+		n = len(src)
+	} else {
+		step := (len(src) - compressibleChunkLen) / (compressibleSampleChunks - 1)
+		for i := 0; i < compressibleSampleChunks; i++ {
+			chunk := src[i*step : i*step+compressibleChunkLen]
+			for _, b := range chunk {
+				hist[b]++
+			}
+		}
+		n = compressibleSampleChunks * compressibleChunkLen
+	}
+
+	entropy := 0.0
+	symbols := 0
+	for _, c := range hist {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+		symbols++
+	}
+	// Apply Miller-Madow correction, since the entropy of a small sample
+	// is underestimated.
+	entropy += float64(symbols-1) / (2 * float64(n) * math.Ln2)
+	return entropy < compressibleMaxEntropy
+}
@@ -0,0 +1,57 @@
+package gozstd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestIsLikelyCompressible(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomData := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+
+	// Random data must be reported as incompressible.
+	for _, n := range []int{1024, 3000, 4096, 4097, 100 * 1024, 1024 * 1024} {
+		if IsLikelyCompressible(randomData(n)) {
+			t.Fatalf("random data with len=%d must be reported as incompressible", n)
+		}
+	}
+
+	// Text and encoded data must be reported as compressible.
+	var bb bytes.Buffer
+	for bb.Len() < 1e5 {
+		fmt.Fprintf(&bb, "line %d, value %d\n", bb.Len(), bb.Len()%123)
+	}
+	text := bb.Bytes()
+	for _, n := range []int{1024, 4096, len(text)} {
+		if !IsLikelyCompressible(text[:n]) {
+			t.Fatalf("text with len=%d must be reported as compressible", n)
+		}
+	}
+	b64 := []byte(base64.StdEncoding.EncodeToString(randomData(1e5)))
+	if !IsLikelyCompressible(b64) {
+		t.Fatalf("base64-encoded data must be reported as compressible")
+	}
+	if !IsLikelyCompressible(make([]byte, 1e5)) {
+		t.Fatalf("zero bytes must be reported as compressible")
+	}
+
+	// Incompressible data mixed into compressible data is detected by sampling.
+	mixed := append(append([]byte{}, text...), randomData(len(text))...)
+	if !IsLikelyCompressible(mixed) {
+		t.Fatalf("data, which is half text, must be reported as compressible")
+	}
+
+	// Short data is always reported as compressible.
+	for _, n := range []int{0, 1, 100, 1023} {
+		if !IsLikelyCompressible(randomData(n)) {
+			t.Fatalf("short data with len=%d must be reported as compressible", n)
+		}
+	}
+}
@@ -0,0 +1,856 @@
+package gozstd
+
+/*
+#cgo CFLAGS: -O3
+
+#define ZSTD_STATIC_LINKING_ONLY
+#include "zstd.h"
+#include "zstd_errors.h"
+
+#include <stdint.h>
+
+typedef struct {
+	size_t dstSize;
+	size_t srcSize;
+	size_t dstPos;
+	size_t srcPos;
+} ZSTD_EXT_BufferSizes;
+
+// The following *_wrapper functions allow avoiding memory allocations
+// durting calls from Go.
+// See https://github.com/golang/go/issues/24450 .
+
+static size_t ZSTD_CCtx_setParameter_ctx_wrapper(void *cctx, ZSTD_cParameter param, int value) {
+    return ZSTD_CCtx_setParameter((ZSTD_CCtx*)cctx, param, value);
+}
+
+static size_t ZSTD_CCtx_getParameter_wrapper(void *cctx, ZSTD_cParameter param, int *value) {
+    return ZSTD_CCtx_getParameter((const ZSTD_CCtx*)cctx, param, value);
+}
+
+static size_t ZSTD_CCtx_reset_wrapper(void *cctx, ZSTD_ResetDirective reset) {
+    return ZSTD_CCtx_reset((ZSTD_CCtx*)cctx, reset);
+}
+
+static size_t ZSTD_CCtx_setPledgedSrcSize_ctx_wrapper(void *cctx, unsigned long long pledgedSrcSize) {
+    return ZSTD_CCtx_setPledgedSrcSize((ZSTD_CCtx*)cctx, pledgedSrcSize);
+}
+
+static size_t ZSTD_CCtx_refCDict_wrapper(void *cctx, void *cdict) {
+    return ZSTD_CCtx_refCDict((ZSTD_CCtx*)cctx, (const ZSTD_CDict*)cdict);
+}
+
+static size_t ZSTD_compress2_wrapper(void *cctx, void *dst, size_t dstCapacity, void *src, size_t srcSize) {
+    return ZSTD_compress2((ZSTD_CCtx*)cctx, dst, dstCapacity, (const void*)src, srcSize);
+}
+
+static size_t ZSTD_compressStream2_ctx_wrapper(void *cctx, void* dst, const void* src, ZSTD_EXT_BufferSizes* sizes, ZSTD_EndDirective endOp) {
+    return ZSTD_compressStream2_simpleArgs((ZSTD_CCtx*)cctx, dst, sizes->dstSize, &sizes->dstPos, src, sizes->srcSize, &sizes->srcPos, endOp);
+}
+
+static size_t ZSTD_DCtx_setParameter_wrapper(void *dctx, ZSTD_dParameter param, int value) {
+    return ZSTD_DCtx_setParameter((ZSTD_DCtx*)dctx, param, value);
+}
+
+static size_t ZSTD_DCtx_loadDictionary_wrapper(void *dctx, void *dict, size_t dictSize) {
+    size_t rv = ZSTD_DCtx_reset((ZSTD_DCtx*)dctx, ZSTD_reset_session_only);
+    if (rv != 0) {
+        return rv;
+    }
+    return ZSTD_DCtx_loadDictionary((ZSTD_DCtx*)dctx, (const void*)dict, dictSize);
+}
+
+static size_t ZSTD_DCtx_refDDict_ctx_wrapper(void *dctx, void *ddict) {
+    size_t rv = ZSTD_DCtx_reset((ZSTD_DCtx*)dctx, ZSTD_reset_session_only);
+    if (rv != 0) {
+        return rv;
+    }
+    return ZSTD_DCtx_refDDict((ZSTD_DCtx*)dctx, (const ZSTD_DDict*)ddict);
+}
+
+static size_t ZSTD_DCtx_reset_wrapper(void *dctx, ZSTD_ResetDirective reset) {
+    return ZSTD_DCtx_reset((ZSTD_DCtx*)dctx, reset);
+}
+
+// goZstdAlloc and goZstdFree are exported from allocator.go.
+extern void* goZstdAlloc(uintptr_t id, size_t size);
+extern void goZstdFree(uintptr_t id, void *p);
+
+static void* ZSTD_customAlloc_trampoline(void *opaque, size_t size) {
+    return goZstdAlloc((uintptr_t)opaque, size);
+}
+
+static void ZSTD_customFree_trampoline(void *opaque, void *address) {
+    if (address != NULL) {
+        goZstdFree((uintptr_t)opaque, address);
+    }
+}
+
+static ZSTD_CCtx* ZSTD_createCCtx_allocator_wrapper(uintptr_t id) {
+    ZSTD_customMem mem = { ZSTD_customAlloc_trampoline, ZSTD_customFree_trampoline, (void*)id };
+    return ZSTD_createCCtx_advanced(mem);
+}
+
+static ZSTD_DCtx* ZSTD_createDCtx_allocator_wrapper(uintptr_t id) {
+    ZSTD_customMem mem = { ZSTD_customAlloc_trampoline, ZSTD_customFree_trampoline, (void*)id };
+    return ZSTD_createDCtx_advanced(mem);
+}
+
+static size_t ZSTD_decompressStream_ctx_wrapper(void *dctx, void* dst, const void* src, ZSTD_EXT_BufferSizes* sizes) {
+    return ZSTD_decompressStream_simpleArgs((ZSTD_DCtx*)dctx, dst, sizes->dstSize, &sizes->dstPos, src, sizes->srcSize, &sizes->srcPos);
+}
+
+static size_t ZSTD_decompressStream_reset_ctx_wrapper(void *dctx, void* dst, const void* src, ZSTD_EXT_BufferSizes* sizes) {
+    size_t rv = ZSTD_DCtx_reset((ZSTD_DCtx*)dctx, ZSTD_reset_session_only);
+    if (rv != 0) {
+        return rv;
+    }
+    return ZSTD_decompressStream_simpleArgs((ZSTD_DCtx*)dctx, dst, sizes->dstSize, &sizes->dstPos, src, sizes->srcSize, &sizes->srcPos);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// CParameter is a compression parameter, which may be set on CCtx.
+type CParameter int
+
+const (
+	// CParamCompressionLevel sets compression parameters according
+	// to the pre-defined compression level table.
+	CParamCompressionLevel CParameter = 100 // ZSTD_c_compressionLevel from zstd.h
+	// CParamWindowLog sets the maximum back-reference distance as a power of 2.
+	CParamWindowLog CParameter = 101 // ZSTD_c_windowLog from zstd.h
+	// CParamHashLog sets the size of the initial probe table as a power of 2.
+	//
+	// Zero means the default for the compression level.
+	CParamHashLog CParameter = 102 // ZSTD_c_hashLog from zstd.h
+	// CParamChainLog sets the size of the multi-probe search table as a power of 2.
+	//
+	// Zero means the default for the compression level.
+	CParamChainLog CParameter = 103 // ZSTD_c_chainLog from zstd.h
+	// CParamSearchLog sets the number of search attempts as a power of 2.
+	//
+	// Zero means the default for the compression level.
+	CParamSearchLog CParameter = 104 // ZSTD_c_searchLog from zstd.h
+	// CParamMinMatch sets the minimum match size.
+	//
+	// Zero means the default for the compression level.
+	CParamMinMatch CParameter = 105 // ZSTD_c_minMatch from zstd.h
+	// CParamTargetLength tunes match length search. Its meaning depends
+	// on the strategy: for btopt and stronger strategies it sets the length
+	// of a match considered good enough to stop the search.
+	CParamTargetLength CParameter = 106 // ZSTD_c_targetLength from zstd.h
+	// CParamStrategy sets the match finder strategy. See Strategy* constants.
+	CParamStrategy CParameter = 107 // ZSTD_c_strategy from zstd.h
+	// CParamContentSizeFlag enables writing the content size into frame header.
+	CParamContentSizeFlag CParameter = 200 // ZSTD_c_contentSizeFlag from zstd.h
+	// CParamChecksumFlag enables writing 32-bit content checksum at the end of frame.
+	CParamChecksumFlag CParameter = 201 // ZSTD_c_checksumFlag from zstd.h
+	// CParamDictIDFlag enables writing dictionary ID into frame header.
+	CParamDictIDFlag CParameter = 202 // ZSTD_c_dictIDFlag from zstd.h
+	// CParamForceMaxWindow forces back-references to remain within the window
+	// set via CParamWindowLog, even when compressing with dictionary.
+	// This is an experimental parameter.
+	CParamForceMaxWindow CParameter = 1000 // ZSTD_c_forceMaxWindow from zstd.h
+	// CParamLiteralCompressionMode controls Huffman compression of literals.
+	// See LiteralCompression* constants.
+	// This is an experimental parameter.
+	CParamLiteralCompressionMode CParameter = 1002 // ZSTD_c_literalCompressionMode from zstd.h
+)
+
+// LiteralCompression* values may be passed to CCtx.SetParameter
+// for CParamLiteralCompressionMode.
+const (
+	// LiteralCompressionAuto compresses literals for non-negative
+	// compression levels only.
+	LiteralCompressionAuto = 0 // ZSTD_ps_auto from zstd.h
+	// LiteralCompressionEnable always attempts compressing literals.
+	LiteralCompressionEnable = 1 // ZSTD_ps_enable from zstd.h
+	// LiteralCompressionDisable stores literals uncompressed. This saves CPU
+	// time on incompressible data such as encrypted or already compressed
+	// payloads, while matches are still found.
+	LiteralCompressionDisable = 2 // ZSTD_ps_disable from zstd.h
+)
+
+// Strategy* values may be passed to CCtx.SetParameter for CParamStrategy.
+//
+// Strategies are listed from the fastest to the strongest.
+const (
+	StrategyFast     = 1 // ZSTD_fast from zstd.h
+	StrategyDfast    = 2 // ZSTD_dfast from zstd.h
+	StrategyGreedy   = 3 // ZSTD_greedy from zstd.h
+	StrategyLazy     = 4 // ZSTD_lazy from zstd.h
+	StrategyLazy2    = 5 // ZSTD_lazy2 from zstd.h
+	StrategyBtlazy2  = 6 // ZSTD_btlazy2 from zstd.h
+	StrategyBtopt    = 7 // ZSTD_btopt from zstd.h
+	StrategyBtultra  = 8 // ZSTD_btultra from zstd.h
+	StrategyBtultra2 = 9 // ZSTD_btultra2 from zstd.h
+)
+
+var strategyNames = []string{
+	StrategyFast:     "fast",
+	StrategyDfast:    "dfast",
+	StrategyGreedy:   "greedy",
+	StrategyLazy:     "lazy",
+	StrategyLazy2:    "lazy2",
+	StrategyBtlazy2:  "btlazy2",
+	StrategyBtopt:    "btopt",
+	StrategyBtultra:  "btultra",
+	StrategyBtultra2: "btultra2",
+}
+
+// StrategyFromString returns Strategy* value for the given strategy name.
+//
+// Supported names are fast, dfast, greedy, lazy, lazy2, btlazy2, btopt,
+// btultra and btultra2.
+func StrategyFromString(s string) (int, error) {
+	for strategy, name := range strategyNames {
+		if name != "" && name == s {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown strategy %q; supported strategies: %s", s, strings.Join(strategyNames[1:], ", "))
+}
+
+// MaxWindowSizeForLevel returns the window size used by the given compression
+// level for inputs of unknown or large size.
+//
+// The decoder must be able to allocate the window of this size for
+// decompressing frames produced at the given level. Set CParamWindowLog
+// for capping the window size for decoders with limited memory.
+// Smaller inputs are compressed with smaller window.
+func MaxWindowSizeForLevel(level int) int {
+	cParams := C.ZSTD_getCParams(C.int(level), 0, 0)
+	return 1 << uint(cParams.windowLog)
+}
+
+// CCtx is a compression context.
+//
+// Unlike Compress* functions, it allows setting arbitrary compression
+// parameters, which persist across Compress calls.
+//
+// CCtx cannot be used from concurrently running goroutines.
+type CCtx struct {
+	cctx  *C.ZSTD_CCtx
+	sizes C.ZSTD_EXT_BufferSizes
+
+	// allocatorID is non-zero for CCtx created via NewCCtxWithAllocator.
+	allocatorID uintptr
+}
+
+// NewCCtx returns new compression context with default parameters.
+//
+// Call Release when the returned CCtx is no longer needed.
+func NewCCtx() *CCtx {
+	c := &CCtx{
+		cctx: C.ZSTD_createCCtx(),
+	}
+	runtime.SetFinalizer(c, freeCCtxExplicit)
+	return c
+}
+
+// NewCCtxWithAllocator returns new compression context with default parameters,
+// which obtains all its memory via the given alloc and free callbacks.
+//
+// alloc must return a pointer to at least size bytes of memory, which isn't
+// managed by Go garbage collector. free must release the memory returned
+// by alloc. Both callbacks are called from the goroutine using the CCtx,
+// while free is also called from Release.
+//
+// An error is returned if alloc returns nil during the context creation.
+// CCtx methods panic if alloc returns nil afterwards, so alloc mustn't fail
+// after the context creation.
+//
+// Call Release when the returned CCtx is no longer needed.
+func NewCCtxWithAllocator(alloc func(size int) unsafe.Pointer, free func(p unsafe.Pointer)) (*CCtx, error) {
+	id := registerAllocator(alloc, free)
+	cctx := C.ZSTD_createCCtx_allocator_wrapper(C.uintptr_t(id))
+	if cctx == nil {
+		unregisterAllocator(id)
+		return nil, fmt.Errorf("cannot allocate compression context")
+	}
+	c := &CCtx{
+		cctx:        cctx,
+		allocatorID: id,
+	}
+	runtime.SetFinalizer(c, freeCCtxExplicit)
+	return c, nil
+}
+
+func freeCCtxExplicit(c *CCtx) {
+	c.Release()
+}
+
+// Release releases resources occupied by c.
+//
+// c cannot be used after the release.
+func (c *CCtx) Release() {
+	if c.cctx == nil {
+		return
+	}
+	result := C.ZSTD_freeCCtx(c.cctx)
+	ensureNoError("ZSTD_freeCCtx", result)
+	c.cctx = nil
+	if c.allocatorID != 0 {
+		unregisterAllocator(c.allocatorID)
+		c.allocatorID = 0
+	}
+}
+
+// mustNotBeReleased panics if c is used after Release, since passing
+// nil context to CGO results in hard-to-debug crashes.
+func (c *CCtx) mustNotBeReleased() {
+	if c.cctx == nil {
+		panic(fmt.Errorf("BUG: CCtx is used after Release"))
+	}
+}
+
+// SizeOf returns the memory size currently occupied by c.
+//
+// It reports the actual allocation, which grows with the largest frame
+// compressed by c.
+func (c *CCtx) SizeOf() int {
+	c.mustNotBeReleased()
+	return int(C.ZSTD_sizeof_CCtx(c.cctx))
+}
+
+// SetParameter sets the given compression parameter to value.
+//
+// The parameter is applied to all the subsequent Compress calls.
+// An error is returned if value is outside the bounds supported
+// for param, except of CParamCompressionLevel, which is clamped.
+func (c *CCtx) SetParameter(param CParameter, value int) error {
+	c.mustNotBeReleased()
+	result := C.ZSTD_CCtx_setParameter_ctx_wrapper(
+		unsafe.Pointer(c.cctx),
+		C.ZSTD_cParameter(param),
+		C.int(value))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot set compression parameter %d to %d: %w", param, value, newError(result))
+	}
+	return nil
+}
+
+// GetParameter returns the current value of the given compression parameter.
+//
+// Zero is returned for parameters, which weren't set explicitly
+// and are derived from the compression level during the compression,
+// such as CParamWindowLog.
+func (c *CCtx) GetParameter(param CParameter) (int, error) {
+	c.mustNotBeReleased()
+	var value C.int
+	result := C.ZSTD_CCtx_getParameter_wrapper(
+		unsafe.Pointer(c.cctx),
+		C.ZSTD_cParameter(param),
+		&value)
+	if zstdIsError(result) {
+		return 0, fmt.Errorf("cannot get compression parameter %d: %w", param, newError(result))
+	}
+	return int(value), nil
+}
+
+// CParamBounds returns the valid range of values for the given compression
+// parameter.
+//
+// Zero bounds are returned for unknown parameters.
+func CParamBounds(param CParameter) (min, max int) {
+	bounds := C.ZSTD_cParam_getBounds(C.ZSTD_cParameter(param))
+	if zstdIsError(bounds.error) {
+		return 0, 0
+	}
+	return int(bounds.lowerBound), int(bounds.upperBound)
+}
+
+// ResetParameters resets all the compression parameters of c to defaults.
+func (c *CCtx) ResetParameters() {
+	c.mustNotBeReleased()
+	result := C.ZSTD_CCtx_reset_wrapper(unsafe.Pointer(c.cctx), C.ZSTD_reset_session_and_parameters)
+	ensureNoError("ZSTD_CCtx_reset", result)
+}
+
+// Compress appends compressed src to dst using parameters set on c
+// and returns the result.
+func (c *CCtx) Compress(dst, src []byte) []byte {
+	c.mustNotBeReleased()
+	if len(src) == 0 {
+		return dst
+	}
+
+	dstLen := len(dst)
+	compressBound := CompressBoundCached(len(src))
+	if n := dstLen + compressBound - cap(dst); n > 0 {
+		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+
+	result := c.compressInternal(dst[dstLen:cap(dst)], src)
+	ensureNoError("ZSTD_compress2", result)
+	return dst[:dstLen+int(result)]
+}
+
+// CompressReuse appends compressed src to dst using parameters already set
+// on c and returns the result.
+//
+// It performs exactly one ZSTD_compress2 call without pool interaction and
+// without re-applying parameters. dst is grown up front if its free capacity
+// is smaller than CompressBoundCached(len(src)), so the call never fails
+// because of too small dst. Superfluous capacity is never trimmed.
+//
+// c cannot be used from concurrently running goroutines.
+func (c *CCtx) CompressReuse(dst, src []byte) []byte {
+	return c.Compress(dst, src)
+}
+
+// compressInternal compresses src into dst[:cap(dst)] and returns
+// the compressed size or zstd error.
+func (c *CCtx) compressInternal(dst, src []byte) C.size_t {
+	dstHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&dst)))
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_compress2_wrapper(
+		unsafe.Pointer(c.cctx),
+		unsafe.Pointer(dstHdr.Data),
+		C.size_t(cap(dst)),
+		unsafe.Pointer(srcHdr.Data),
+		C.size_t(len(src)))
+	// Prevent from GC'ing of dst and src during CGO call above.
+	runtime.KeepAlive(dst)
+	runtime.KeepAlive(src)
+	return result
+}
+
+// refCDict makes c using the given dictionary cd for the subsequent
+// Compress calls. Compression parameters, which aren't set explicitly,
+// are taken from cd. ResetParameters clears the dictionary.
+func (c *CCtx) refCDict(cd *CDict) error {
+	c.mustNotBeReleased()
+	result := C.ZSTD_CCtx_refCDict_wrapper(unsafe.Pointer(c.cctx), unsafe.Pointer(cd.p))
+	runtime.KeepAlive(cd)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot reference dictionary: %w", newError(result))
+	}
+	return nil
+}
+
+// EndDirective controls CCtx.CompressStream behaviour.
+type EndDirective int
+
+const (
+	// EndContinue collects data for optimal compression. It may compress
+	// and output some data, but it isn't guaranteed to output anything,
+	// so it is the fastest mode.
+	EndContinue EndDirective = 0 // ZSTD_e_continue from zstd.h
+
+	// EndFlush flushes all the data passed so far, so it may be decompressed
+	// from the output immediately. The frame is continued after the flush.
+	// Frequent flushes reduce compression ratio.
+	EndFlush EndDirective = 1 // ZSTD_e_flush from zstd.h
+
+	// EndEnd flushes all the data passed so far and finishes the frame.
+	// The next CompressStream call starts a new frame.
+	EndEnd EndDirective = 2 // ZSTD_e_end from zstd.h
+)
+
+// CompressStream compresses src into dst with the given endOp
+// using parameters set on c.
+//
+// It directly drives ZSTD_compressStream2. consumed is the number of bytes
+// read from the start of src, while produced is the number of bytes written
+// to the start of dst. len(dst) is used as the output capacity.
+// done is true when the operation is complete:
+//
+//   - for EndContinue all the src is consumed;
+//   - for EndFlush all the src is consumed and flushed to dst;
+//   - for EndEnd all the src is consumed and the frame is finished.
+//
+// If done is false, then CompressStream must be called again with
+// the same endOp, the remaining src and a fresh dst.
+//
+// If an error is returned, then the frame in progress is aborted
+// and must be restarted from the beginning.
+//
+// Compress and ResetParameters calls abort the frame in progress.
+func (c *CCtx) CompressStream(dst, src []byte, endOp EndDirective) (consumed, produced int, done bool, err error) {
+	c.mustNotBeReleased()
+	c.sizes.dstSize = C.size_t(len(dst))
+	c.sizes.dstPos = 0
+	c.sizes.srcSize = C.size_t(len(src))
+	c.sizes.srcPos = 0
+
+	dstHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&dst)))
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_compressStream2_ctx_wrapper(
+		unsafe.Pointer(c.cctx), unsafe.Pointer(dstHdr.Data), unsafe.Pointer(srcHdr.Data), &c.sizes, C.ZSTD_EndDirective(endOp))
+	// Prevent from GC'ing of dst and src during CGO call above.
+	runtime.KeepAlive(dst)
+	runtime.KeepAlive(src)
+	if zstdIsError(result) {
+		// zstd requires resetting the session after a failed call.
+		resetResult := C.ZSTD_CCtx_reset_wrapper(unsafe.Pointer(c.cctx), C.ZSTD_reset_session_only)
+		ensureNoError("ZSTD_CCtx_reset", resetResult)
+		return 0, 0, false, fmt.Errorf("cannot compress stream: %w", newError(result))
+	}
+
+	consumed = int(c.sizes.srcPos)
+	produced = int(c.sizes.dstPos)
+	done = consumed == len(src)
+	if endOp != EndContinue {
+		// result is the number of bytes left to flush.
+		done = done && result == 0
+	}
+	return consumed, produced, done, nil
+}
+
+// setPledgedSrcSize sets the size of the data for the next frame compressed
+// via CompressStream, so it is stored in the frame header.
+func (c *CCtx) setPledgedSrcSize(n uint64) {
+	c.mustNotBeReleased()
+	result := C.ZSTD_CCtx_setPledgedSrcSize_ctx_wrapper(unsafe.Pointer(c.cctx), C.ulonglong(n))
+	ensureNoError("ZSTD_CCtx_setPledgedSrcSize", result)
+}
+
+// DParameter is a decompression parameter, which may be set on DCtx.
+type DParameter int
+
+const (
+	// DParamWindowLogMax limits the window size accepted by the decompressor
+	// as a power of 2. Frames requiring bigger window are rejected.
+	DParamWindowLogMax DParameter = 100 // ZSTD_d_windowLogMax from zstd.h
+)
+
+// DCtx is a decompression context.
+//
+// Unlike Decompress* functions, it allows setting arbitrary decompression
+// parameters, which persist across Decompress calls.
+//
+// DCtx cannot be used from concurrently running goroutines.
+type DCtx struct {
+	dctx  *C.ZSTD_DCtx
+	sizes C.ZSTD_EXT_BufferSizes
+
+	// dd is the dictionary referenced via LoadDDict.
+	// It is held here in order to prevent it from GC'ing.
+	dd *DDict
+
+	// dictID is the ID of the dictionary loaded into d.
+	dictID uint32
+
+	// allocatorID is non-zero for DCtx created via NewDCtxWithAllocator.
+	allocatorID uintptr
+}
+
+// NewDCtx returns new decompression context with default parameters.
+//
+// Call Release when the returned DCtx is no longer needed.
+func NewDCtx() *DCtx {
+	d := &DCtx{
+		dctx: C.ZSTD_createDCtx(),
+	}
+	runtime.SetFinalizer(d, freeDCtxExplicit)
+	return d
+}
+
+// NewDCtxWithAllocator returns new decompression context with default parameters,
+// which obtains all its memory via the given alloc and free callbacks.
+//
+// See NewCCtxWithAllocator for the requirements to alloc and free.
+//
+// Call Release when the returned DCtx is no longer needed.
+func NewDCtxWithAllocator(alloc func(size int) unsafe.Pointer, free func(p unsafe.Pointer)) (*DCtx, error) {
+	id := registerAllocator(alloc, free)
+	dctx := C.ZSTD_createDCtx_allocator_wrapper(C.uintptr_t(id))
+	if dctx == nil {
+		unregisterAllocator(id)
+		return nil, fmt.Errorf("cannot allocate decompression context")
+	}
+	d := &DCtx{
+		dctx:        dctx,
+		allocatorID: id,
+	}
+	runtime.SetFinalizer(d, freeDCtxExplicit)
+	return d, nil
+}
+
+func freeDCtxExplicit(d *DCtx) {
+	d.Release()
+}
+
+// Release releases resources occupied by d.
+//
+// d cannot be used after the release.
+func (d *DCtx) Release() {
+	if d.dctx == nil {
+		return
+	}
+	result := C.ZSTD_freeDCtx(d.dctx)
+	ensureNoError("ZSTD_freeDCtx", result)
+	d.dctx = nil
+	if d.allocatorID != 0 {
+		unregisterAllocator(d.allocatorID)
+		d.allocatorID = 0
+	}
+}
+
+// mustNotBeReleased panics if d is used after Release, since passing
+// nil context to CGO results in hard-to-debug crashes.
+func (d *DCtx) mustNotBeReleased() {
+	if d.dctx == nil {
+		panic(fmt.Errorf("BUG: DCtx is used after Release"))
+	}
+}
+
+// SizeOf returns the memory size currently occupied by d.
+//
+// It reports the actual allocation, which grows with the largest window
+// of frames decompressed by d.
+func (d *DCtx) SizeOf() int {
+	d.mustNotBeReleased()
+	return int(C.ZSTD_sizeof_DCtx(d.dctx))
+}
+
+// DParamBounds returns the valid range of values for the given decompression
+// parameter.
+//
+// Zero bounds are returned for unknown parameters.
+func DParamBounds(param DParameter) (min, max int) {
+	bounds := C.ZSTD_dParam_getBounds(C.ZSTD_dParameter(param))
+	if zstdIsError(bounds.error) {
+		return 0, 0
+	}
+	return int(bounds.lowerBound), int(bounds.upperBound)
+}
+
+// SetParameter sets the given decompression parameter to value.
+//
+// The parameter is applied to all the subsequent Decompress calls.
+func (d *DCtx) SetParameter(param DParameter, value int) error {
+	d.mustNotBeReleased()
+	result := C.ZSTD_DCtx_setParameter_wrapper(
+		unsafe.Pointer(d.dctx),
+		C.ZSTD_dParameter(param),
+		C.int(value))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot set decompression parameter %d to %d: %w", param, value, newError(result))
+	}
+	return nil
+}
+
+// LoadDictionary loads the given dictionary into d for the subsequent
+// Decompress and DecompressStream calls.
+//
+// The dictionary is copied into d, so dict may be modified after the call.
+//
+// The dictionary remains loaded until the next LoadDictionary
+// or ResetParameters call. Pass nil dict for unloading the dictionary.
+// The frame in progress started via DecompressStream is aborted.
+func (d *DCtx) LoadDictionary(dict []byte) error {
+	d.mustNotBeReleased()
+	var dictPtr unsafe.Pointer
+	if len(dict) > 0 {
+		dictPtr = unsafe.Pointer(&dict[0])
+	}
+	result := C.ZSTD_DCtx_loadDictionary_wrapper(unsafe.Pointer(d.dctx), dictPtr, C.size_t(len(dict)))
+	// Prevent from GC'ing of dict during CGO call above.
+	runtime.KeepAlive(dict)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot load dictionary: %w", newError(result))
+	}
+	d.dd = nil
+	d.dictID = 0
+	if len(dict) > 0 {
+		d.dictID = uint32(C.ZSTD_getDictID_fromDict(dictPtr, C.size_t(len(dict))))
+		runtime.KeepAlive(dict)
+	}
+	return nil
+}
+
+// LoadDDict makes d using the given dd for the subsequent Decompress
+// and DecompressStream calls.
+//
+// dd is referenced without copying. zstd initializes the decompression state
+// from dd at the start of every frame, so Decompress calls don't re-bind dd.
+//
+// dd must not be released while it is loaded into d. It remains loaded
+// until the next LoadDDict, LoadDictionary or ResetParameters call.
+// Pass nil dd for unloading the dictionary.
+// The frame in progress started via DecompressStream is aborted.
+func (d *DCtx) LoadDDict(dd *DDict) error {
+	d.mustNotBeReleased()
+	var ddict *C.ZSTD_DDict
+	if dd != nil {
+		// Passing nil DDict to CGO silently unloads the dictionary.
+		if dd.p == nil {
+			panic(fmt.Errorf("BUG: DDict is used after Release"))
+		}
+		ddict = dd.p
+	}
+	result := C.ZSTD_DCtx_refDDict_ctx_wrapper(unsafe.Pointer(d.dctx), unsafe.Pointer(ddict))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot reference dictionary: %w", newError(result))
+	}
+	d.dd = dd
+	d.dictID = ddictID(dd)
+	return nil
+}
+
+// ResetParameters resets all the decompression parameters of d to defaults.
+//
+// The dictionary loaded via LoadDictionary or LoadDDict is unloaded.
+func (d *DCtx) ResetParameters() {
+	d.mustNotBeReleased()
+	result := C.ZSTD_DCtx_reset_wrapper(unsafe.Pointer(d.dctx), C.ZSTD_reset_session_and_parameters)
+	ensureNoError("ZSTD_DCtx_reset", result)
+	d.dd = nil
+	d.dictID = 0
+}
+
+// DecompressStream decompresses src into dst using parameters set on d.
+//
+// It directly drives ZSTD_decompressStream. The decompression state persists
+// across calls, so frames may be passed in arbitrary chunks.
+// consumed is the number of bytes read from the start of src, while produced
+// is the number of bytes written to the start of dst. len(dst) is used
+// as the output capacity.
+//
+// hint is zero when the call completes decoding and flushing a frame to dst.
+// Otherwise it is the suggested size of the next src chunk. If produced
+// equals len(dst), then call DecompressStream again with fresh dst, since
+// there may be buffered data left to flush.
+//
+// The session is reset on error, so the next call starts a new frame.
+// Decompress and ResetParameters calls abort the frame in progress.
+func (d *DCtx) DecompressStream(dst, src []byte) (consumed, produced, hint int, err error) {
+	d.mustNotBeReleased()
+	d.sizes.dstSize = C.size_t(len(dst))
+	d.sizes.dstPos = 0
+	d.sizes.srcSize = C.size_t(len(src))
+	d.sizes.srcPos = 0
+
+	dstHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&dst)))
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_decompressStream_ctx_wrapper(
+		unsafe.Pointer(d.dctx), unsafe.Pointer(dstHdr.Data), unsafe.Pointer(srcHdr.Data), &d.sizes)
+	// Prevent from GC'ing of dst and src during CGO call above.
+	runtime.KeepAlive(dst)
+	runtime.KeepAlive(src)
+	if zstdIsError(result) {
+		resetResult := C.ZSTD_DCtx_reset_wrapper(unsafe.Pointer(d.dctx), C.ZSTD_reset_session_only)
+		ensureNoError("ZSTD_DCtx_reset", resetResult)
+		e := newError(result)
+		e.setDictIDs(src, d.dictID)
+		return 0, 0, 0, fmt.Errorf("decompression error: %w", e)
+	}
+	return int(d.sizes.srcPos), int(d.sizes.dstPos), int(result), nil
+}
+
+// Decompress appends decompressed src to dst using parameters set on d
+// and returns the result.
+func (d *DCtx) Decompress(dst, src []byte) ([]byte, error) {
+	d.mustNotBeReleased()
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	// Use streaming decompression, since it respects all the parameters
+	// and doesn't require known content size.
+	dstLen := len(dst)
+	d.sizes = C.ZSTD_EXT_BufferSizes{}
+	d.sizes.srcSize = C.size_t(len(src))
+	firstCall := true
+	for {
+		if cap(dst)-len(dst) < int(dstreamOutBufSize)/2 {
+			n := len(dst)
+			if n < int(dstreamOutBufSize) {
+				n = int(dstreamOutBufSize)
+			}
+			dst = append(dst[:cap(dst)], make([]byte, n)...)[:len(dst)]
+		}
+		dstBuf := dst[len(dst):cap(dst)]
+		d.sizes.dstSize = C.size_t(len(dstBuf))
+		d.sizes.dstPos = 0
+		prevSrcPos := d.sizes.srcPos
+
+		dstHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&dstBuf)))
+		srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+		var result C.size_t
+		if firstCall {
+			// Start from clean session, since the previous call could fail
+			// in the middle of a frame. The reset is performed in the same
+			// CGO call in order to reduce the overhead for small frames.
+			result = C.ZSTD_decompressStream_reset_ctx_wrapper(
+				unsafe.Pointer(d.dctx), unsafe.Pointer(dstHdr.Data), unsafe.Pointer(srcHdr.Data), &d.sizes)
+			firstCall = false
+		} else {
+			result = C.ZSTD_decompressStream_ctx_wrapper(
+				unsafe.Pointer(d.dctx), unsafe.Pointer(dstHdr.Data), unsafe.Pointer(srcHdr.Data), &d.sizes)
+		}
+		// Prevent from GC'ing of dst and src during CGO call above.
+		runtime.KeepAlive(dstBuf)
+		runtime.KeepAlive(src)
+		if zstdIsError(result) {
+			return dst[:dstLen], newDecompressError(src, result, d.dictID)
+		}
+		dst = dst[:len(dst)+int(d.sizes.dstPos)]
+
+		if int(d.sizes.srcPos) == len(src) {
+			if result == 0 {
+				// All the frames have been decompressed.
+				return dst, nil
+			}
+			if d.sizes.dstPos == 0 && d.sizes.srcPos == prevSrcPos {
+				// No progress is possible without more input.
+				return dst[:dstLen], fmt.Errorf("decompression error: %w", ErrTruncated)
+			}
+		}
+	}
+}
+
+// StickyDecompressor decompresses frames with the dictionary loaded once
+// via LoadDict.
+//
+// Unlike DecompressDict, it doesn't bind the dictionary to the decompression
+// context on every call, so the binding cost is amortized over all the frames
+// decompressed after LoadDict.
+//
+// StickyDecompressor cannot be used from concurrently running goroutines.
+type StickyDecompressor struct {
+	d *DCtx
+}
+
+// NewStickyDecompressor returns new StickyDecompressor without dictionary.
+//
+// Call Release when the returned StickyDecompressor is no longer needed.
+func NewStickyDecompressor() *StickyDecompressor {
+	return &StickyDecompressor{
+		d: NewDCtx(),
+	}
+}
+
+// LoadDict makes sd using dd for all the subsequent Decompress calls.
+//
+// dd must not be released while it is loaded into sd. Pass nil dd
+// for unloading the dictionary.
+func (sd *StickyDecompressor) LoadDict(dd *DDict) {
+	if err := sd.d.LoadDDict(dd); err != nil {
+		panic(fmt.Errorf("BUG: unexpected error in LoadDDict: %w", err))
+	}
+}
+
+// Decompress appends decompressed src to dst using the dictionary loaded
+// via LoadDict and returns the result.
+func (sd *StickyDecompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return sd.d.Decompress(dst, src)
+}
+
+// Release releases all the resources occupied by sd.
+//
+// sd cannot be used after the release.
+func (sd *StickyDecompressor) Release() {
+	sd.d.Release()
+}
@@ -0,0 +1,817 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCCtxResetParameters(t *testing.T) {
+	src := []byte(newTestString(128*1024, 3))
+
+	c := NewCCtx()
+	defer c.Release()
+	defaultData := c.Compress(nil, src)
+
+	if err := c.SetParameter(CParamCompressionLevel, 19); err != nil {
+		t.Fatalf("cannot set compression level: %s", err)
+	}
+	if err := c.SetParameter(CParamChecksumFlag, 1); err != nil {
+		t.Fatalf("cannot set checksum flag: %s", err)
+	}
+	customData := c.Compress(nil, src)
+	if bytes.Equal(customData, defaultData) {
+		t.Fatalf("compressed data mustn't match after changing parameters")
+	}
+
+	c.ResetParameters()
+	resetData := c.Compress(nil, src)
+	if !bytes.Equal(resetData, defaultData) {
+		t.Fatalf("unexpected compressed data after ResetParameters; got %d bytes; want %d bytes", len(resetData), len(defaultData))
+	}
+
+	plainData, err := Decompress(nil, resetData)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	if err := c.SetParameter(CParameter(12345), 1); err == nil {
+		t.Fatalf("expecting non-nil error for unknown parameter")
+	}
+}
+
+func TestCCtxGetParameter(t *testing.T) {
+	c := NewCCtx()
+	defer c.Release()
+
+	f := func(param CParameter, valueExpected int) {
+		t.Helper()
+		value, err := c.GetParameter(param)
+		if err != nil {
+			t.Fatalf("cannot get parameter %d: %s", param, err)
+		}
+		if value != valueExpected {
+			t.Fatalf("unexpected value for parameter %d; got %d; want %d", param, value, valueExpected)
+		}
+	}
+
+	// Default values.
+	f(CParamCompressionLevel, DefaultCompressionLevel)
+	f(CParamWindowLog, 0)
+
+	if err := c.SetParameter(CParamCompressionLevel, 19); err != nil {
+		t.Fatalf("cannot set compression level: %s", err)
+	}
+	if err := c.SetParameter(CParamWindowLog, 20); err != nil {
+		t.Fatalf("cannot set window log: %s", err)
+	}
+	f(CParamCompressionLevel, 19)
+	f(CParamWindowLog, 20)
+
+	c.ResetParameters()
+	f(CParamCompressionLevel, DefaultCompressionLevel)
+	f(CParamWindowLog, 0)
+
+	if _, err := c.GetParameter(CParameter(12345)); err == nil {
+		t.Fatalf("expecting non-nil error for unknown parameter")
+	}
+}
+
+func TestDCtxResetParameters(t *testing.T) {
+	src := []byte(newTestString(256*1024, 3))
+
+	// Compress data with unknown content size and big window.
+	var bb bytes.Buffer
+	zw := NewWriterParams(&bb, &WriterParams{
+		CompressionLevel: 5,
+		WindowLog:        20,
+	})
+	if _, err := zw.Write(src); err != nil {
+		t.Fatalf("cannot write data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close writer: %s", err)
+	}
+	zw.Release()
+	compressedData := bb.Bytes()
+
+	d := NewDCtx()
+	defer d.Release()
+
+	if err := d.SetParameter(DParamWindowLogMax, 10); err != nil {
+		t.Fatalf("cannot set windowLogMax: %s", err)
+	}
+	if _, err := d.Decompress(nil, compressedData); err == nil {
+		t.Fatalf("expecting non-nil error when window exceeds windowLogMax")
+	}
+
+	d.ResetParameters()
+	plainData, err := d.Decompress(nil, compressedData)
+	if err != nil {
+		t.Fatalf("unexpected error after ResetParameters: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data; got %d bytes; want %d bytes", len(plainData), len(src))
+	}
+
+	// Truncated src must result in error.
+	if _, err := d.Decompress(nil, compressedData[:len(compressedData)-1]); err == nil {
+		t.Fatalf("expecting non-nil error for truncated src")
+	}
+}
+
+func TestCCtxCompressStream(t *testing.T) {
+	src := []byte(newTestString(512*1024, 3))
+
+	c := NewCCtx()
+	defer c.Release()
+
+	// Use small output buffer in order to exercise repeated calls.
+	buf := make([]byte, 1000)
+	var cd []byte
+	compressChunk := func(src []byte, endOp EndDirective) {
+		t.Helper()
+		for {
+			consumed, produced, done, err := c.CompressStream(buf, src, endOp)
+			if err != nil {
+				t.Fatalf("unexpected error in CompressStream: %s", err)
+			}
+			cd = append(cd, buf[:produced]...)
+			src = src[consumed:]
+			if done {
+				if len(src) > 0 {
+					t.Fatalf("unexpected unconsumed src with len=%d for endOp=%d", len(src), endOp)
+				}
+				return
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		cd = cd[:0]
+		for n := 0; n < len(src); n += 10000 {
+			end := n + 10000
+			if end > len(src) {
+				end = len(src)
+			}
+			compressChunk(src[n:end], EndContinue)
+		}
+		compressChunk(nil, EndEnd)
+
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+	}
+
+	// The frame may be finished in a single call.
+	cd = cd[:0]
+	compressChunk(src, EndEnd)
+	if !bytes.Equal(cd, c.Compress(nil, src)) {
+		t.Fatalf("unexpected data compressed with a single EndEnd call")
+	}
+
+	// Src bigger than the pledged size must result in error.
+	c.setPledgedSrcSize(10)
+	if _, _, _, err := c.CompressStream(buf, src, EndFlush); err == nil {
+		t.Fatalf("expecting non-nil error for src bigger than the pledged size")
+	}
+
+	// The failed frame must be aborted.
+	cd = cd[:0]
+	compressChunk(src, EndEnd)
+	if !bytes.Equal(cd, c.Compress(nil, src)) {
+		t.Fatalf("unexpected data compressed after the error")
+	}
+}
+
+func TestDCtxDecompressStream(t *testing.T) {
+	src := []byte(newTestString(512*1024, 3))
+	cd := Compress(nil, src)
+
+	d := NewDCtx()
+	defer d.Release()
+
+	buf := make([]byte, 4096)
+	// decompressChunk returns the hint from the last call, which made progress.
+	decompressChunk := func(dst, chunk []byte) ([]byte, int) {
+		t.Helper()
+		lastHint := -1
+		for {
+			consumed, produced, hint, err := d.DecompressStream(buf, chunk)
+			if err != nil {
+				t.Fatalf("cannot decompress chunk: %s", err)
+			}
+			if consumed == 0 && produced == 0 {
+				// No more data can be decompressed without the next chunk.
+				return dst, lastHint
+			}
+			lastHint = hint
+			dst = append(dst, buf[:produced]...)
+			chunk = chunk[consumed:]
+		}
+	}
+	for i := 0; i < 2; i++ {
+		half := len(cd) / 2
+		plainData, hint := decompressChunk(nil, cd[:half])
+		if hint == 0 {
+			t.Fatalf("expecting non-zero hint after the first half of the frame")
+		}
+		if len(plainData) >= len(src) {
+			t.Fatalf("too much data decompressed from the first half; got %d bytes; want less than %d bytes", len(plainData), len(src))
+		}
+		plainData, hint = decompressChunk(plainData, cd[half:])
+		if hint != 0 {
+			t.Fatalf("unexpected hint after the end of frame; got %d; want 0", hint)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+	}
+
+	// Errors must reset the session.
+	if _, _, _, err := d.DecompressStream(buf, []byte("invalid compressed data")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid data")
+	}
+	plainData, hint := decompressChunk(nil, cd)
+	if hint != 0 {
+		t.Fatalf("unexpected hint after the end of frame; got %d; want 0", hint)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data after error")
+	}
+}
+
+func TestDCtxLoadDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is dictionary sample number %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+
+	d := NewDCtx()
+	defer d.Release()
+
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatalf("unexpected error in LoadDictionary: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		src := []byte(fmt.Sprintf("this is dictionary sample number %d", i*123))
+		plainData, err := d.Decompress(nil, CompressDict(nil, src, cd))
+		if err != nil {
+			t.Fatalf("cannot decompress data with loaded dictionary: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, src)
+		}
+	}
+
+	// The dictionary must be unloaded.
+	src := []byte("this is dictionary sample number 42")
+	cdData := CompressDict(nil, src, cd)
+	if err := d.LoadDictionary(nil); err != nil {
+		t.Fatalf("unexpected error when unloading dictionary: %s", err)
+	}
+	if _, err := d.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing without dictionary")
+	}
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatalf("unexpected error in LoadDictionary: %s", err)
+	}
+	d.ResetParameters()
+	if _, err := d.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing after ResetParameters")
+	}
+}
+
+func TestDCtxLoadDDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is dictionary sample number %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	d := NewDCtx()
+	defer d.Release()
+
+	if err := d.LoadDDict(dd); err != nil {
+		t.Fatalf("unexpected error in LoadDDict: %s", err)
+	}
+	var plainData []byte
+	for i := 0; i < 1000; i++ {
+		src := []byte(fmt.Sprintf("this is dictionary sample number %d", i*123))
+		plainData, err = d.Decompress(plainData[:0], CompressDict(nil, src, cd))
+		if err != nil {
+			t.Fatalf("cannot decompress data with loaded DDict: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, src)
+		}
+	}
+
+	// The DDict must remain loaded after decompression error.
+	if _, err := d.Decompress(nil, []byte("invalid compressed data")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid data")
+	}
+	src := []byte("this is dictionary sample number 42")
+	cdData := CompressDict(nil, src, cd)
+	plainData, err = d.Decompress(nil, cdData)
+	if err != nil {
+		t.Fatalf("cannot decompress data after error: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data after error; got %q; want %q", plainData, src)
+	}
+
+	// The DDict must be unloaded.
+	if err := d.LoadDDict(nil); err != nil {
+		t.Fatalf("unexpected error when unloading DDict: %s", err)
+	}
+	if _, err := d.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing without dictionary")
+	}
+	if err := d.LoadDDict(dd); err != nil {
+		t.Fatalf("unexpected error in LoadDDict: %s", err)
+	}
+	d.ResetParameters()
+	if _, err := d.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing after ResetParameters")
+	}
+}
+
+func TestStickyDecompressor(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is dictionary sample number %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	sd := NewStickyDecompressor()
+	defer sd.Release()
+
+	src := []byte("this is dictionary sample number 42")
+	cdData := CompressDict(nil, src, cd)
+	if _, err := sd.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing without dictionary")
+	}
+
+	// The dictionary must remain loaded across Decompress calls.
+	sd.LoadDict(dd)
+	var plainData []byte
+	for i := 0; i < 1000; i++ {
+		src := []byte(fmt.Sprintf("this is dictionary sample number %d", i*123))
+		plainData, err = sd.Decompress(plainData[:0], CompressDict(nil, src, cd))
+		if err != nil {
+			t.Fatalf("cannot decompress data with loaded dict: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, src)
+		}
+	}
+
+	sd.LoadDict(nil)
+	if _, err := sd.Decompress(nil, cdData); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing after unloading the dictionary")
+	}
+}
+
+func TestCCtxCompressReuse(t *testing.T) {
+	c := NewCCtx()
+	defer c.Release()
+	if err := c.SetParameter(CParamCompressionLevel, 5); err != nil {
+		t.Fatalf("cannot set compression level: %s", err)
+	}
+
+	var dst []byte
+	for _, size := range []int{0, 1, 1000, 100000, 10, 100000} {
+		src := []byte(newTestString(size, 3))
+		dst = c.CompressReuse(append(dst[:0], "prefix"...), src)
+		want := CompressLevel([]byte("prefix"), src, 5)
+		if !bytes.Equal(dst, want) {
+			t.Fatalf("unexpected compressed data for size=%d; got %d bytes; want %d bytes", size, len(dst), len(want))
+		}
+	}
+
+	// Too small dst must be grown.
+	src := []byte(newTestString(100000, 3))
+	dst = c.CompressReuse(make([]byte, 0, 10), src)
+	if !bytes.Equal(dst, CompressLevel(nil, src, 5)) {
+		t.Fatalf("unexpected compressed data for too small dst")
+	}
+}
+
+func TestCtxSizeOf(t *testing.T) {
+	c := NewCCtx()
+	defer c.Release()
+	d := NewDCtx()
+	defer d.Release()
+
+	// Compress and decompress small data first.
+	small := []byte(newTestString(100, 3))
+	if _, err := d.Decompress(nil, c.Compress(nil, small)); err != nil {
+		t.Fatalf("cannot decompress small data: %s", err)
+	}
+	cSize := c.SizeOf()
+	dSize := d.SizeOf()
+	if cSize <= 0 {
+		t.Fatalf("unexpected CCtx size; got %d; want positive value", cSize)
+	}
+	if dSize <= 0 {
+		t.Fatalf("unexpected DCtx size; got %d; want positive value", dSize)
+	}
+
+	// The sizes must grow after processing a large frame.
+	large := []byte(newTestString(4*1024*1024, 3))
+	if _, err := d.Decompress(nil, c.Compress(nil, large)); err != nil {
+		t.Fatalf("cannot decompress large data: %s", err)
+	}
+	if n := c.SizeOf(); n <= cSize {
+		t.Fatalf("CCtx size must grow after compressing large data; got %d; want bigger than %d", n, cSize)
+	}
+	if n := d.SizeOf(); n <= dSize {
+		t.Fatalf("DCtx size must grow after decompressing large data; got %d; want bigger than %d", n, dSize)
+	}
+}
+
+func TestCCtxWindowCap(t *testing.T) {
+	for _, level := range []int{1, 3, 19} {
+		windowSize := MaxWindowSizeForLevel(level)
+		if windowSize < 1<<WindowLogMin || windowSize > 1<<WindowLogMax32 {
+			t.Fatalf("unexpected window size for level %d; got %d", level, windowSize)
+		}
+	}
+	if n := MaxWindowSizeForLevel(19); n <= MaxWindowSizeForLevel(1) {
+		t.Fatalf("window size for level 19 must exceed window size for level 1; got %d vs %d", n, MaxWindowSizeForLevel(1))
+	}
+
+	// Compress large input at high level with the window capped to 1MB.
+	const windowLog = 20
+	src := []byte(newTestString(8*1024*1024, 3))
+	if MaxWindowSizeForLevel(19) <= 1<<windowLog {
+		t.Fatalf("the default window for level 19 must exceed the cap")
+	}
+	c := NewCCtx()
+	defer c.Release()
+	for _, p := range []struct {
+		param CParameter
+		value int
+	}{
+		{CParamCompressionLevel, 19},
+		{CParamWindowLog, windowLog},
+		{CParamForceMaxWindow, 1},
+	} {
+		if err := c.SetParameter(p.param, p.value); err != nil {
+			t.Fatalf("cannot set parameter %d to %d: %s", p.param, p.value, err)
+		}
+	}
+	cd := c.Compress(nil, src[:2*1024*1024])
+	windowSize, err := GetFrameWindowSize(cd)
+	if err != nil {
+		t.Fatalf("cannot obtain window size: %s", err)
+	}
+	if windowSize > 1<<windowLog {
+		t.Fatalf("too big window size; got %d; want no more than %d", windowSize, 1<<windowLog)
+	}
+	plainData, err := DecompressWith(nil, cd, DecompressOpts{
+		WindowLogMax: windowLog,
+	})
+	if err != nil {
+		t.Fatalf("cannot decompress data with capped window: %s", err)
+	}
+	if !bytes.Equal(plainData, src[:2*1024*1024]) {
+		t.Fatalf("unexpected decompressed data")
+	}
+}
+
+func TestCCtxTargetLength(t *testing.T) {
+	src := []byte(newTestString(256*1024, 3))
+	for _, targetLength := range []int{0, 1, 64, 999} {
+		c := NewCCtx()
+		if err := c.SetParameter(CParamStrategy, StrategyBtopt); err != nil {
+			t.Fatalf("cannot set btopt strategy: %s", err)
+		}
+		if err := c.SetParameter(CParamTargetLength, targetLength); err != nil {
+			t.Fatalf("cannot set targetLength=%d: %s", targetLength, err)
+		}
+		cd := c.Compress(nil, src)
+		c.Release()
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data compressed with targetLength=%d: %s", targetLength, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected data decompressed for targetLength=%d", targetLength)
+		}
+	}
+
+	c := NewCCtx()
+	defer c.Release()
+	if err := c.SetParameter(CParamStrategy, 100); err == nil {
+		t.Fatalf("expecting non-nil error for invalid strategy")
+	}
+}
+
+func TestCCtxMatchFinderParameters(t *testing.T) {
+	src := []byte(newTestString(128*1024, 3))
+	for _, param := range []CParameter{CParamHashLog, CParamChainLog, CParamSearchLog, CParamMinMatch} {
+		minValue, maxValue := CParamBounds(param)
+		if minValue <= 0 || minValue > maxValue {
+			t.Fatalf("unexpected bounds for parameter %d: [%d, %d]", param, minValue, maxValue)
+		}
+
+		below := minValue - 1
+		if below == 0 {
+			// Zero means the default value, so it is always accepted.
+			below = -1
+		}
+		c := NewCCtx()
+		if err := c.SetParameter(param, below); err == nil {
+			t.Fatalf("expecting non-nil error for parameter %d below the minimum %d", param, minValue)
+		}
+		if err := c.SetParameter(param, maxValue+1); err == nil {
+			t.Fatalf("expecting non-nil error for parameter %d above the maximum %d", param, maxValue)
+		}
+		c.Release()
+
+		for _, value := range []int{minValue, maxValue} {
+			if param == CParamHashLog || param == CParamChainLog {
+				// Huge tables take too much memory and time to initialize.
+				if value > 22 {
+					value = 22
+				}
+			}
+			c := NewCCtx()
+			if err := c.SetParameter(param, value); err != nil {
+				t.Fatalf("cannot set parameter %d to %d: %s", param, value, err)
+			}
+			cd := c.Compress(nil, src)
+			c.Release()
+			plainData, err := Decompress(nil, cd)
+			if err != nil {
+				t.Fatalf("cannot decompress data compressed with parameter %d=%d: %s", param, value, err)
+			}
+			if !bytes.Equal(plainData, src) {
+				t.Fatalf("unexpected data decompressed for parameter %d=%d", param, value)
+			}
+		}
+	}
+}
+
+func TestParamBounds(t *testing.T) {
+	minValue, maxValue := CParamBounds(CParamWindowLog)
+	t.Logf("windowLog bounds: [%d, %d]", minValue, maxValue)
+	if minValue != WindowLogMin {
+		t.Fatalf("unexpected minimum windowLog; got %d; want %d", minValue, WindowLogMin)
+	}
+	if maxValue != WindowLogMax32 && maxValue != WindowLogMax64 {
+		t.Fatalf("unexpected maximum windowLog; got %d; want %d or %d", maxValue, WindowLogMax32, WindowLogMax64)
+	}
+
+	minValue, maxValue = CParamBounds(CParamChecksumFlag)
+	t.Logf("checksumFlag bounds: [%d, %d]", minValue, maxValue)
+	if minValue != 0 || maxValue != 1 {
+		t.Fatalf("unexpected checksumFlag bounds; got [%d, %d]; want [0, 1]", minValue, maxValue)
+	}
+
+	minValue, maxValue = DParamBounds(DParamWindowLogMax)
+	t.Logf("windowLogMax bounds: [%d, %d]", minValue, maxValue)
+	if minValue != WindowLogMin {
+		t.Fatalf("unexpected minimum windowLogMax; got %d; want %d", minValue, WindowLogMin)
+	}
+	if maxValue != WindowLogMax32 && maxValue != WindowLogMax64 {
+		t.Fatalf("unexpected maximum windowLogMax; got %d; want %d or %d", maxValue, WindowLogMax32, WindowLogMax64)
+	}
+
+	// Unknown parameters.
+	if minValue, maxValue := CParamBounds(12345); minValue != 0 || maxValue != 0 {
+		t.Fatalf("unexpected bounds for unknown compression parameter; got [%d, %d]; want [0, 0]", minValue, maxValue)
+	}
+	if minValue, maxValue := DParamBounds(12345); minValue != 0 || maxValue != 0 {
+		t.Fatalf("unexpected bounds for unknown decompression parameter; got [%d, %d]; want [0, 0]", minValue, maxValue)
+	}
+
+	// The bounds must be accepted by SetParameter.
+	c := NewCCtx()
+	defer c.Release()
+	minValue, maxValue = CParamBounds(CParamWindowLog)
+	for _, value := range []int{minValue, maxValue} {
+		if err := c.SetParameter(CParamWindowLog, value); err != nil {
+			t.Fatalf("cannot set windowLog=%d: %s", value, err)
+		}
+	}
+	if err := c.SetParameter(CParamWindowLog, maxValue+1); err == nil {
+		t.Fatalf("expecting non-nil error for windowLog=%d", maxValue+1)
+	}
+	d := NewDCtx()
+	defer d.Release()
+	minValue, maxValue = DParamBounds(DParamWindowLogMax)
+	for _, value := range []int{minValue, maxValue} {
+		if err := d.SetParameter(DParamWindowLogMax, value); err != nil {
+			t.Fatalf("cannot set windowLogMax=%d: %s", value, err)
+		}
+	}
+}
+
+func TestStrategyFromString(t *testing.T) {
+	f := func(name string, strategyExpected int) {
+		t.Helper()
+		strategy, err := StrategyFromString(name)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", name, err)
+		}
+		if strategy != strategyExpected {
+			t.Fatalf("unexpected strategy for %q; got %d; want %d", name, strategy, strategyExpected)
+		}
+		minValue, maxValue := CParamBounds(CParamStrategy)
+		if strategy < minValue || strategy > maxValue {
+			t.Fatalf("strategy %d for %q is outside the bounds [%d, %d]", strategy, name, minValue, maxValue)
+		}
+	}
+	f("fast", StrategyFast)
+	f("dfast", StrategyDfast)
+	f("greedy", StrategyGreedy)
+	f("lazy", StrategyLazy)
+	f("lazy2", StrategyLazy2)
+	f("btlazy2", StrategyBtlazy2)
+	f("btopt", StrategyBtopt)
+	f("btultra", StrategyBtultra)
+	f("btultra2", StrategyBtultra2)
+
+	for _, name := range []string{"", "foo", "FAST", "ZSTD_fast", " fast"} {
+		if _, err := StrategyFromString(name); err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+
+	// Compress with non-default strategy.
+	strategy, err := StrategyFromString("btultra2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	src := []byte(newTestString(128*1024, 3))
+	opts := CompressOpts{
+		Level: 1,
+		Params: &CompressParams{
+			Strategy: strategy,
+		},
+	}
+	cd, err := CompressWith(nil, src, opts)
+	if err != nil {
+		t.Fatalf("cannot compress data with strategy %d: %s", strategy, err)
+	}
+	if len(cd) >= len(CompressLevel(nil, src, 1)) {
+		t.Fatalf("btultra2 strategy must compress better than the default strategy for level 1")
+	}
+	plainData, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	opts.Params.Strategy = 100
+	if _, err := CompressWith(nil, src, opts); err == nil {
+		t.Fatalf("expecting non-nil error for invalid strategy")
+	}
+}
+
+func TestCompressParamsLiteralCompressionMode(t *testing.T) {
+	src := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(src)
+	for _, mode := range []int{LiteralCompressionAuto, LiteralCompressionEnable, LiteralCompressionDisable} {
+		opts := CompressOpts{
+			Level: 3,
+			Params: &CompressParams{
+				Checksum:               true,
+				LiteralCompressionMode: mode,
+			},
+		}
+		cd, err := CompressWith(nil, src, opts)
+		if err != nil {
+			t.Fatalf("cannot compress data with literal compression mode %d: %s", mode, err)
+		}
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data compressed with literal compression mode %d: %s", mode, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data for literal compression mode %d", mode)
+		}
+	}
+
+	// Literals must be stored uncompressed on compressible data.
+	src = []byte(newTestString(256*1024, 3))
+	c := NewCCtx()
+	defer c.Release()
+	cdAuto := c.Compress(nil, src)
+	if err := c.SetParameter(CParamLiteralCompressionMode, LiteralCompressionDisable); err != nil {
+		t.Fatalf("cannot disable literal compression: %s", err)
+	}
+	cdDisabled := c.Compress(nil, src)
+	if len(cdDisabled) <= len(cdAuto) {
+		t.Fatalf("disabled literal compression must result in bigger output; got %d bytes; want more than %d bytes", len(cdDisabled), len(cdAuto))
+	}
+	plainData, err := Decompress(nil, cdDisabled)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	if err := c.SetParameter(CParamLiteralCompressionMode, 100); err == nil {
+		t.Fatalf("expecting non-nil error for invalid literal compression mode")
+	}
+	if _, err := CompressWith(nil, src, CompressOpts{
+		Params: &CompressParams{
+			LiteralCompressionMode: 100,
+		},
+	}); err == nil {
+		t.Fatalf("expecting non-nil error for invalid literal compression mode")
+	}
+}
+
+func TestCtxUseAfterRelease(t *testing.T) {
+	src := []byte(newTestString(1000, 3))
+	cd := Compress(nil, src)
+
+	expectPanic := func(name, substr string, f func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			if r == nil {
+				t.Fatalf("expecting panic in %s", name)
+			}
+			if s := fmt.Sprint(r); !strings.Contains(s, substr) {
+				t.Fatalf("unexpected panic message in %s; got %q; want it containing %q", name, s, substr)
+			}
+		}()
+		f()
+	}
+
+	c := NewCCtx()
+	c.Release()
+	const cMsg = "CCtx is used after Release"
+	expectPanic("CCtx.Compress", cMsg, func() { c.Compress(nil, src) })
+	expectPanic("CCtx.CompressReuse", cMsg, func() { c.CompressReuse(nil, src) })
+	expectPanic("CCtx.CompressStream", cMsg, func() { c.CompressStream(make([]byte, 100), src, EndEnd) })
+	expectPanic("CCtx.SizeOf", cMsg, func() { c.SizeOf() })
+	expectPanic("CCtx.SetParameter", cMsg, func() { _ = c.SetParameter(CParamCompressionLevel, 3) })
+	expectPanic("CCtx.ResetParameters", cMsg, func() { c.ResetParameters() })
+
+	d := NewDCtx()
+	d.Release()
+	const dMsg = "DCtx is used after Release"
+	expectPanic("DCtx.Decompress", dMsg, func() { _, _ = d.Decompress(nil, cd) })
+	expectPanic("DCtx.DecompressStream", dMsg, func() { _, _, _, _ = d.DecompressStream(make([]byte, 100), cd) })
+	expectPanic("DCtx.SizeOf", dMsg, func() { d.SizeOf() })
+	expectPanic("DCtx.SetParameter", dMsg, func() { _ = d.SetParameter(DParamWindowLogMax, 20) })
+	expectPanic("DCtx.LoadDDict", dMsg, func() { _ = d.LoadDDict(nil) })
+	expectPanic("DCtx.ResetParameters", dMsg, func() { d.ResetParameters() })
+
+	dd, err := NewDDict([]byte(newTestString(1000, 3)))
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	dd.Release()
+	d = NewDCtx()
+	defer d.Release()
+	expectPanic("DCtx.LoadDDict", "DDict is used after Release", func() { _ = d.LoadDDict(dd) })
+}
@@ -23,11 +23,21 @@ static ZSTD_DDict* ZSTD_createDDict_wrapper(uintptr_t dictBuffer, size_t dictSiz
 	return ZSTD_createDDict((const void *)dictBuffer, dictSize);
 }
 
+static unsigned ZSTD_getDictID_fromFrame_wrapper(uintptr_t src, size_t srcSize) {
+	return ZSTD_getDictID_fromFrame((const void *)src, srcSize);
+}
+
+static size_t ZSTD_findFrameCompressedSize_dict_wrapper(uintptr_t src, size_t srcSize) {
+	return ZSTD_findFrameCompressedSize((const void *)src, srcSize);
+}
+
 */
 import "C"
 
 import (
+	"encoding/binary"
 	"fmt"
+	"os"
 	"runtime"
 	"sync"
 	"unsafe"
@@ -35,16 +45,287 @@ import (
 
 const minDictLen = C.ZDICT_DICTSIZE_MIN
 
+// maxSuggestedDictLen is the maximum dictionary size returned
+// from RecommendedDictSize. It equals to the default maximum dictionary size
+// in zstd command-line tool, since dictionaries bigger than 100KB are rarely
+// effective.
+const maxSuggestedDictLen = 112640
+
+// SuggestDictSize returns the suggested desiredDictLen for BuildDict
+// with the given samples.
+//
+// It returns RecommendedDictSize for the total size of samples.
+func SuggestDictSize(samples [][]byte) int {
+	samplesLen := 0
+	for _, sample := range samples {
+		samplesLen += len(sample)
+	}
+	return RecommendedDictSize(samplesLen)
+}
+
+// RecommendedDictSize returns the recommended dictionary size for training
+// on samples with the given total size in bytes.
+//
+// zstd recommends the total size of samples to be about 100 times bigger
+// than the dictionary size, so the recommended size is 1/100 of the total
+// samples size. It is clamped to the range [256 .. 110KB], since smaller
+// dictionaries cannot be trained, while bigger dictionaries are rarely
+// more effective.
+func RecommendedDictSize(totalSampleBytes int) int {
+	dictLen := totalSampleBytes / 100
+	if dictLen < minDictLen {
+		return minDictLen
+	}
+	if dictLen > maxSuggestedDictLen {
+		return maxSuggestedDictLen
+	}
+	return dictLen
+}
+
+// EvaluateDict compresses each of the given samples with and without dict
+// using the given compression level and returns the average compression
+// ratios for both cases.
+//
+// The compression ratio of a sample is its size divided by its compressed
+// size, so higher values are better. Empty samples are skipped, and zero
+// ratios are returned if there are no non-empty samples.
+// An error is returned if dict cannot be loaded.
+func EvaluateDict(dict []byte, samples [][]byte, level int) (avgRatioWithDict, avgRatioWithout float64, err error) {
+	cd, err := NewCDictLevel(dict, level)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot load dict: %w", err)
+	}
+	defer cd.Release()
+
+	var buf []byte
+	n := 0
+	for _, sample := range samples {
+		if len(sample) == 0 {
+			continue
+		}
+		buf = CompressDict(buf[:0], sample, cd)
+		avgRatioWithDict += float64(len(sample)) / float64(len(buf))
+		buf = CompressLevel(buf[:0], sample, level)
+		avgRatioWithout += float64(len(sample)) / float64(len(buf))
+		n++
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	return avgRatioWithDict / float64(n), avgRatioWithout / float64(n), nil
+}
+
+// DictStats compresses each of the given samples with and without dict
+// using the given compression level and returns the total compressed sizes
+// for both cases.
+//
+// Empty samples are skipped. The dict is worth deploying only if withDict
+// is noticeably smaller than withoutDict. An error is returned if dict
+// cannot be loaded.
+func DictStats(dict []byte, samples [][]byte, level int) (withDict, withoutDict int, err error) {
+	cd, err := NewCDictLevel(dict, level)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot load dict: %w", err)
+	}
+	defer cd.Release()
+
+	var buf []byte
+	for _, sample := range samples {
+		if len(sample) == 0 {
+			continue
+		}
+		buf = CompressDict(buf[:0], sample, cd)
+		withDict += len(buf)
+		buf = CompressLevel(buf[:0], sample, level)
+		withoutDict += len(buf)
+	}
+	return withDict, withoutDict, nil
+}
+
+// CompressBestDict compresses src with each of the given cds and without
+// dictionary, appends the smallest result to dst and returns it.
+//
+// chosen is the index of the dictionary in cds used for the returned result,
+// or -1 if the compression without dictionary gives the smallest result.
+// The given compressionLevel is used for the compression without dictionary,
+// while each cd uses the compression level it was created with.
+//
+// The frame compressed with cds[chosen] must be decompressed with the DDict
+// created from the same dictionary. The chosen dictionary may be detected
+// during the decompression via DDict.Matches.
+func CompressBestDict(dst, src []byte, cds []*CDict, compressionLevel int) (out []byte, chosen int) {
+	best := CompressLevel(nil, src, compressionLevel)
+	chosen = -1
+	var buf []byte
+	for i, cd := range cds {
+		buf = CompressDict(buf[:0], src, cd)
+		if len(buf) < len(best) {
+			best, buf = buf, best
+			chosen = i
+		}
+	}
+	return append(dst, best...), chosen
+}
+
+// selfContainedDictMagic is the magic number of the skippable frame
+// holding the dictionary in the output of CompressSelfContained.
+const selfContainedDictMagic = 0x184D2A5D
+
+// emptyFrame is a zstd frame with empty content.
+var emptyFrame = []byte{
+	0x28, 0xb5, 0x2f, 0xfd, // magic number
+	0x20,             // frame header descriptor: single segment with 1-byte content size
+	0x00,             // content size
+	0x01, 0x00, 0x00, // the last raw block with zero size
+}
+
+// CompressSelfContained compresses src with the given dict using
+// the given compressionLevel, appends the result to dst and returns it.
+//
+// The result consists of a skippable frame holding dict followed by a frame
+// compressed with dict, so it may be decompressed via DecompressSelfContained
+// without passing the dictionary out of band. This trades the compressed size
+// for portability, so it is worth using only if src is much bigger than dict.
+// The result isn't decompressible with plain Decompress, since zstd skips
+// the dictionary frame.
+//
+// The dictionary is loaded on every call. Use CompressDict with CDict
+// for compressing many inputs with the same dict.
+func CompressSelfContained(dst, src, dict []byte, compressionLevel int) ([]byte, error) {
+	if len(dict) == 0 {
+		return dst, fmt.Errorf("dict cannot be empty")
+	}
+	if uint64(len(dict)) > 1<<32-1 {
+		return dst, fmt.Errorf("too big dict size: %d bytes; it cannot exceed 4GB", len(dict))
+	}
+	cd, err := NewCDictLevel(dict, compressionLevel)
+	if err != nil {
+		return dst, err
+	}
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:4], selfContainedDictMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(dict)))
+	dst = append(dst, header[:]...)
+	dst = append(dst, dict...)
+	if len(src) == 0 {
+		// CompressDict returns nothing for empty src, while DecompressSelfContained
+		// expects a frame after the dictionary.
+		dst = append(dst, emptyFrame...)
+	} else {
+		dst = CompressDict(dst, src, cd)
+	}
+	cd.Release()
+	return dst, nil
+}
+
+// DecompressSelfContained decompresses src produced by CompressSelfContained,
+// appends the result to dst and returns it.
+func DecompressSelfContained(dst, src []byte) ([]byte, error) {
+	if len(src) < 8 || binary.LittleEndian.Uint32(src) != selfContainedDictMagic {
+		return dst, fmt.Errorf("missing dictionary frame at the start of src")
+	}
+	dictLen := binary.LittleEndian.Uint32(src[4:])
+	if uint64(dictLen) > uint64(len(src)-8) {
+		return dst, fmt.Errorf("dictionary frame is truncated; its size is %d bytes; src has %d bytes after the frame header: %w", dictLen, len(src)-8, ErrTruncated)
+	}
+	dict := src[8 : 8+dictLen]
+	src = src[8+dictLen:]
+	if len(src) == 0 {
+		return dst, fmt.Errorf("missing compressed frame after the dictionary frame: %w", ErrTruncated)
+	}
+	dd, err := NewDDict(dict)
+	if err != nil {
+		return dst, err
+	}
+	dst, err = DecompressDict(dst, src, dd)
+	dd.Release()
+	return dst, err
+}
+
 // BuildDict returns dictionary built from the given samples.
 //
 // The resulting dictionary size will be close to desiredDictLen.
+// SuggestDictSize for the given samples is used if desiredDictLen is 0.
 //
 // The returned dictionary may be passed to NewCDict* and NewDDict.
+// Use DictTrainer for building many dictionaries.
 func BuildDict(samples [][]byte, desiredDictLen int) []byte {
+	if desiredDictLen == 0 {
+		desiredDictLen = SuggestDictSize(samples)
+	}
 	if desiredDictLen < minDictLen {
 		desiredDictLen = minDictLen
 	}
-	dict := make([]byte, desiredDictLen)
+	var dt DictTrainer
+	dictLen, err := dt.train(samples, desiredDictLen)
+	if err != nil {
+		// Return empty dictionary, since the original samples are too small.
+		return nil
+	}
+	return dt.dict[:dictLen]
+}
+
+// DictTrainer builds dictionaries from samples.
+//
+// Unlike BuildDict, it re-uses internal buffers across Train calls.
+//
+// DictTrainer cannot be used from concurrently running goroutines.
+type DictTrainer struct {
+	samplesBuf   []byte
+	samplesSizes []C.size_t
+	dict         []byte
+}
+
+// Train returns dictionary built from the given samples.
+//
+// The resulting dictionary size doesn't exceed maxDictSize, which must be
+// at least 256 bytes. An error is returned if the dictionary cannot be built
+// from the given samples.
+//
+// The returned dictionary doesn't refer to dt buffers, so it remains valid
+// after the next Train call.
+func (dt *DictTrainer) Train(samples [][]byte, maxDictSize int) ([]byte, error) {
+	if maxDictSize < minDictLen {
+		return nil, fmt.Errorf("too small maxDictSize: %d bytes; it must be at least %d bytes", maxDictSize, minDictLen)
+	}
+	dictLen, err := dt.train(samples, maxDictSize)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, dt.dict[:dictLen]...), nil
+}
+
+func (dt *DictTrainer) train(samples [][]byte, dictLen int) (int, error) {
+	dict := dt.prepare(samples, dictLen)
+	samplesBuf := dt.samplesBuf
+	samplesSizes := dt.samplesSizes
+
+	// Run ZDICT_trainFromBuffer under lock, since it looks like it
+	// is unsafe for concurrent usage (it just randomly crashes).
+	// TODO: remove this restriction.
+
+	buildDictLock.Lock()
+	result := C.ZDICT_trainFromBuffer(
+		unsafe.Pointer(&dict[0]),
+		C.size_t(len(dict)),
+		unsafe.Pointer(&samplesBuf[0]),
+		&samplesSizes[0],
+		C.unsigned(len(samplesSizes)))
+	buildDictLock.Unlock()
+	if C.ZDICT_isError(result) != 0 {
+		return 0, fmt.Errorf("cannot train dictionary: %s", C.GoString(C.ZDICT_getErrorName(result)))
+	}
+	return int(result), nil
+}
+
+// prepare returns dt.dict buffer with dictLen bytes and puts samples
+// into dt.samplesBuf and dt.samplesSizes in the form expected by ZDICT_*.
+func (dt *DictTrainer) prepare(samples [][]byte, dictLen int) []byte {
+	if cap(dt.dict) < dictLen {
+		dt.dict = make([]byte, dictLen)
+	}
+	dict := dt.dict[:dictLen]
 
 	// Calculate the total samples size.
 	samplesBufLen := 0
@@ -57,8 +338,8 @@ func BuildDict(samples [][]byte, desiredDictLen int) []byte {
 	}
 
 	// Construct flat samplesBuf and samplesSizes.
-	samplesBuf := make([]byte, 0, samplesBufLen)
-	samplesSizes := make([]C.size_t, 0, len(samples))
+	samplesBuf := dt.samplesBuf[:0]
+	samplesSizes := dt.samplesSizes[:0]
 	for _, sample := range samples {
 		samplesBuf = append(samplesBuf, sample...)
 		samplesSizes = append(samplesSizes, C.size_t(len(sample)))
@@ -75,29 +356,127 @@ func BuildDict(samples [][]byte, desiredDictLen int) []byte {
 		samplesSizes = append(samplesSizes, C.size_t(len(fakeSample)))
 		samplesBufLen += len(fakeSample)
 	}
+	dt.samplesBuf = samplesBuf
+	dt.samplesSizes = samplesSizes
+	return dict
+}
 
-	// Run ZDICT_trainFromBuffer under lock, since it looks like it
-	// is unsafe for concurrent usage (it just randomly crashes).
-	// TODO: remove this restriction.
+var buildDictLock sync.Mutex
+
+// FastCoverParams contains parameters for the fastCover dictionary
+// training algorithm.
+//
+// Zero values mean defaults, except of K and D for BuildDictFastCover.
+type FastCoverParams struct {
+	// K is the segment size. Reasonable range is [16..2048].
+	K int
+
+	// D is the dmer size. It must be in the range (0..K].
+	// Reasonable range is [6..16].
+	D int
 
+	// F is the log of the frequency array size. It must be in the range
+	// (0..31]. The training requires 6 * 2^F bytes of memory.
+	//
+	// The default is 20.
+	F int
+
+	// Accel is the acceleration level in the range (0..10].
+	// Higher levels speed up the training at the cost of dictionary quality.
+	//
+	// The default is 1.
+	Accel int
+}
+
+// BuildDictFastCover returns dictionary built from the given samples
+// with the fastCover algorithm and the given params.
+//
+// The resulting dictionary size doesn't exceed maxDictSize, which must be
+// at least 256 bytes. params.K and params.D must be set.
+// Use BuildDictOptimizeFastCover for finding good params.
+func BuildDictFastCover(samples [][]byte, maxDictSize int, params FastCoverParams) ([]byte, error) {
+	if maxDictSize < minDictLen {
+		return nil, fmt.Errorf("too small maxDictSize: %d bytes; it must be at least %d bytes", maxDictSize, minDictLen)
+	}
+	if params.K <= 0 || params.D <= 0 {
+		return nil, fmt.Errorf("params.K and params.D must be positive; got K=%d, D=%d", params.K, params.D)
+	}
+	var dt DictTrainer
+	dict := dt.prepare(samples, maxDictSize)
+	fcp := newFastCoverParams(params, 0)
+
+	// See the comment in DictTrainer.train for the lock.
 	buildDictLock.Lock()
-	result := C.ZDICT_trainFromBuffer(
+	result := C.ZDICT_trainFromBuffer_fastCover(
 		unsafe.Pointer(&dict[0]),
 		C.size_t(len(dict)),
-		unsafe.Pointer(&samplesBuf[0]),
-		&samplesSizes[0],
-		C.unsigned(len(samplesSizes)))
+		unsafe.Pointer(&dt.samplesBuf[0]),
+		&dt.samplesSizes[0],
+		C.unsigned(len(dt.samplesSizes)),
+		fcp)
 	buildDictLock.Unlock()
 	if C.ZDICT_isError(result) != 0 {
-		// Return empty dictionary, since the original samples are too small.
-		return nil
+		return nil, fmt.Errorf("cannot train dictionary: %s", C.GoString(C.ZDICT_getErrorName(result)))
+	}
+	return dict[:result], nil
+}
+
+// BuildDictOptimizeFastCover returns dictionary built from the given samples
+// with the fastCover algorithm together with the best params found for it.
+//
+// It trains dictionaries for various K and D combinations and picks
+// the one compressing the samples best, so it is much slower than
+// BuildDictFastCover. steps is the number of K values to try;
+// zero means the default of 40. The returned params may be passed
+// to BuildDictFastCover for reproducing the dictionary.
+//
+// All the samples are used for both training and evaluation.
+// The resulting dictionary size doesn't exceed maxDictSize, which must be
+// at least 256 bytes.
+func BuildDictOptimizeFastCover(samples [][]byte, maxDictSize, steps int) ([]byte, FastCoverParams, error) {
+	if maxDictSize < minDictLen {
+		return nil, FastCoverParams{}, fmt.Errorf("too small maxDictSize: %d bytes; it must be at least %d bytes", maxDictSize, minDictLen)
 	}
+	if steps < 0 {
+		return nil, FastCoverParams{}, fmt.Errorf("steps cannot be negative; got %d", steps)
+	}
+	var dt DictTrainer
+	dict := dt.prepare(samples, maxDictSize)
+	fcp := newFastCoverParams(FastCoverParams{}, steps)
 
-	dictLen := int(result)
-	return dict[:dictLen]
+	// See the comment in DictTrainer.train for the lock.
+	buildDictLock.Lock()
+	result := C.ZDICT_optimizeTrainFromBuffer_fastCover(
+		unsafe.Pointer(&dict[0]),
+		C.size_t(len(dict)),
+		unsafe.Pointer(&dt.samplesBuf[0]),
+		&dt.samplesSizes[0],
+		C.unsigned(len(dt.samplesSizes)),
+		&fcp)
+	buildDictLock.Unlock()
+	if C.ZDICT_isError(result) != 0 {
+		return nil, FastCoverParams{}, fmt.Errorf("cannot train dictionary: %s", C.GoString(C.ZDICT_getErrorName(result)))
+	}
+	params := FastCoverParams{
+		K:     int(fcp.k),
+		D:     int(fcp.d),
+		F:     int(fcp.f),
+		Accel: int(fcp.accel),
+	}
+	return dict[:result], params, nil
 }
 
-var buildDictLock sync.Mutex
+func newFastCoverParams(params FastCoverParams, steps int) C.ZDICT_fastCover_params_t {
+	var fcp C.ZDICT_fastCover_params_t
+	fcp.k = C.unsigned(params.K)
+	fcp.d = C.unsigned(params.D)
+	fcp.f = C.unsigned(params.F)
+	fcp.accel = C.unsigned(params.Accel)
+	fcp.steps = C.unsigned(steps)
+	fcp.nbThreads = 1
+	fcp.splitPoint = 1.0
+	return fcp
+}
 
 // CDict is a dictionary used for compression.
 //
@@ -136,6 +515,25 @@ func NewCDictLevel(dict []byte, compressionLevel int) (*CDict, error) {
 	return cd, nil
 }
 
+// LoadCDict creates new CDict from the dictionary stored in the file
+// at the given path using the given compressionLevel.
+//
+// Call Release when the returned dict is no longer used.
+func LoadCDict(path string, compressionLevel int) (*CDict, error) {
+	dict, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dict: %w", err)
+	}
+	return NewCDictLevel(dict, compressionLevel)
+}
+
+// ID returns the dictionary ID of cd.
+//
+// 0 is returned for raw content dictionaries.
+func (cd *CDict) ID() uint32 {
+	return uint32(C.ZSTD_getDictID_fromCDict(cd.p))
+}
+
 // Release releases resources occupied by cd.
 //
 // cd cannot be used after the release.
@@ -154,7 +552,8 @@ func freeCDict(v interface{}) {
 
 // DDict is a dictionary used for decompression.
 //
-// A single DDict may be re-used in concurrently running goroutines.
+// A single DDict may be re-used in concurrently running goroutines,
+// since it is read-only during decompression.
 type DDict struct {
 	p *C.ZSTD_DDict
 }
@@ -178,6 +577,41 @@ func NewDDict(dict []byte) (*DDict, error) {
 	return dd, nil
 }
 
+// LoadDDict creates new DDict from the dictionary stored in the file
+// at the given path.
+//
+// Call Release when the returned dict is no longer needed.
+func LoadDDict(path string) (*DDict, error) {
+	dict, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dict: %w", err)
+	}
+	return NewDDict(dict)
+}
+
+// ID returns the dictionary ID of dd.
+//
+// 0 is returned for raw content dictionaries.
+func (dd *DDict) ID() uint32 {
+	return uint32(C.ZSTD_getDictID_fromDDict(dd.p))
+}
+
+// ddictID returns the ID of dd. 0 is returned for nil dd.
+func ddictID(dd *DDict) uint32 {
+	if dd == nil {
+		return 0
+	}
+	return dd.ID()
+}
+
+// MemorySize returns the memory size occupied by dd.
+//
+// dd isn't modified during decompression, so the size remains the same
+// regardless of the number of goroutines sharing dd.
+func (dd *DDict) MemorySize() int {
+	return int(C.ZSTD_sizeof_DDict(dd.p))
+}
+
 // Release releases resources occupied by dd.
 //
 // dd cannot be used after the release.
@@ -191,6 +625,50 @@ func (dd *DDict) Release() {
 	dd.p = nil
 }
 
+// Matches returns true if the frame at the start of src may be decompressed
+// with dd.
+//
+// It compares the dictionary ID stored in the frame header against
+// the dd ID, so it is much cheaper than a failed decompression attempt.
+// false is returned if dd has non-zero ID, while the frame has no dictionary ID.
+func (dd *DDict) Matches(src []byte) bool {
+	if len(src) == 0 {
+		return false
+	}
+	frameID := C.ZSTD_getDictID_fromFrame_wrapper(
+		C.uintptr_t(uintptr(unsafe.Pointer(&src[0]))),
+		C.size_t(len(src)))
+	// Prevent from GC'ing of src during CGO call above.
+	runtime.KeepAlive(src)
+	return uint32(frameID) == dd.ID()
+}
+
+// mismatchedFrameDictID returns the dictionary ID of the first frame in src,
+// which requires a dictionary other than the dictionary with dictID.
+//
+// 0 is returned if such frame cannot be found.
+func mismatchedFrameDictID(src []byte, dictID uint32) uint32 {
+	for len(src) > 0 {
+		frameID := uint32(C.ZSTD_getDictID_fromFrame_wrapper(
+			C.uintptr_t(uintptr(unsafe.Pointer(&src[0]))),
+			C.size_t(len(src))))
+		if frameID != 0 && frameID != dictID {
+			runtime.KeepAlive(src)
+			return frameID
+		}
+		frameSize := C.ZSTD_findFrameCompressedSize_dict_wrapper(
+			C.uintptr_t(uintptr(unsafe.Pointer(&src[0]))),
+			C.size_t(len(src)))
+		// Prevent from GC'ing of src during CGO calls above.
+		runtime.KeepAlive(src)
+		if zstdIsError(frameSize) {
+			return 0
+		}
+		src = src[int(frameSize):]
+	}
+	return 0
+}
+
 func freeDDict(v interface{}) {
 	v.(*DDict).Release()
 }
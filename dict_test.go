@@ -1,8 +1,15 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 )
@@ -59,6 +66,179 @@ func TestDDictCreateRelease(t *testing.T) {
 	}
 }
 
+func TestLoadDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+
+	f, err := ioutil.TempFile("", "gozstd-dict")
+	if err != nil {
+		t.Fatalf("cannot create temporary file: %s", err)
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	if _, err := f.Write(dict); err != nil {
+		t.Fatalf("cannot write dict to %q: %s", path, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close %q: %s", path, err)
+	}
+
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	cdLoaded, err := LoadCDict(path, DefaultCompressionLevel)
+	if err != nil {
+		t.Fatalf("cannot load CDict: %s", err)
+	}
+	defer cdLoaded.Release()
+	if cd.ID() == 0 {
+		t.Fatalf("expecting non-zero CDict ID")
+	}
+	if cdLoaded.ID() != cd.ID() {
+		t.Fatalf("unexpected loaded CDict ID; got %d; want %d", cdLoaded.ID(), cd.ID())
+	}
+
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+	ddLoaded, err := LoadDDict(path)
+	if err != nil {
+		t.Fatalf("cannot load DDict: %s", err)
+	}
+	defer ddLoaded.Release()
+	if ddLoaded.ID() != dd.ID() || dd.ID() != cd.ID() {
+		t.Fatalf("unexpected loaded DDict ID; got %d; want %d", ddLoaded.ID(), cd.ID())
+	}
+
+	// Round-trip with loaded dicts.
+	src := []byte("sample 12345")
+	plainData, err := DecompressDict(nil, CompressDict(nil, src, cdLoaded), ddLoaded)
+	if err != nil {
+		t.Fatalf("cannot decompress data with loaded dicts: %s", err)
+	}
+	if string(plainData) != string(src) {
+		t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, src)
+	}
+
+	// Missing files.
+	if _, err := LoadCDict(path+".missing", DefaultCompressionLevel); err == nil {
+		t.Fatalf("expecting non-nil error when loading CDict from missing file")
+	}
+	if _, err := LoadDDict(path + ".missing"); err == nil {
+		t.Fatalf("expecting non-nil error when loading DDict from missing file")
+	}
+}
+
+func TestDDictMatches(t *testing.T) {
+	var dds []*DDict
+	var cds []*CDict
+	for i := 0; i < 2; i++ {
+		var samples [][]byte
+		for j := 0; j < 1000; j++ {
+			samples = append(samples, []byte(fmt.Sprintf("sample %d for dict %d", j, i)))
+		}
+		dict := BuildDict(samples, 8*1024)
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		defer cd.Release()
+		cds = append(cds, cd)
+		dd, err := NewDDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create DDict: %s", err)
+		}
+		defer dd.Release()
+		dds = append(dds, dd)
+	}
+
+	src := []byte("sample 123 for dict 0")
+	for i, cd := range cds {
+		compressedData := CompressDict(nil, src, cd)
+		if !dds[i].Matches(compressedData) {
+			t.Fatalf("DDict #%d must match the frame compressed with CDict #%d", i, i)
+		}
+		if dds[1-i].Matches(compressedData) {
+			t.Fatalf("DDict #%d mustn't match the frame compressed with CDict #%d", 1-i, i)
+		}
+	}
+
+	// Frames without dict ID mustn't match dicts with non-zero ID.
+	if dds[0].Matches(Compress(nil, src)) {
+		t.Fatalf("DDict mustn't match the frame compressed without dict")
+	}
+	if dds[0].Matches(nil) {
+		t.Fatalf("DDict mustn't match empty src")
+	}
+}
+
+func TestDDictMemorySizeConcurrent(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d, rand num %d, other num %X", i, rand.Intn(100), rand.Intn(100000))))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	sizeBefore := dd.MemorySize()
+	if sizeBefore < len(dict) {
+		t.Fatalf("too small DDict memory size; got %d; want at least %d", sizeBefore, len(dict))
+	}
+
+	const concurrency = 200
+	ch := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func(n int) {
+			var buf, plainData []byte
+			for j := 0; j < 100; j++ {
+				sample := samples[(n*100+j)%len(samples)]
+				buf = CompressDict(buf[:0], sample, cd)
+				var err error
+				plainData, err = DecompressDict(plainData[:0], buf, dd)
+				if err != nil {
+					ch <- fmt.Errorf("cannot decompress data: %w", err)
+					return
+				}
+				if string(plainData) != string(sample) {
+					ch <- fmt.Errorf("unexpected decompressed data; got %q; want %q", plainData, sample)
+					return
+				}
+			}
+			ch <- nil
+		}(i)
+	}
+	for i := 0; i < concurrency; i++ {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Fatalf("error in concurrent decompression: %s", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	if sizeAfter := dd.MemorySize(); sizeAfter != sizeBefore {
+		t.Fatalf("DDict memory size changed after concurrent use; got %d; want %d", sizeAfter, sizeBefore)
+	}
+}
+
 func TestBuildDict(t *testing.T) {
 	for _, samplesCount := range []int{0, 1, 10, 100, 1000} {
 		t.Run(fmt.Sprintf("samples_%d", samplesCount), func(t *testing.T) {
@@ -77,6 +257,327 @@ func TestBuildDict(t *testing.T) {
 	}
 }
 
+func TestDictTrainer(t *testing.T) {
+	var dt DictTrainer
+	for tenant := 0; tenant < 5; tenant++ {
+		var samples [][]byte
+		for i := 0; i < 1000; i++ {
+			sample := []byte(fmt.Sprintf("tenant %d, sample %d, rand num %d", tenant, i, rand.Intn(100)))
+			samples = append(samples, sample)
+		}
+		dict, err := dt.Train(samples, 4*1024)
+		if err != nil {
+			t.Fatalf("cannot train dict for tenant %d: %s", tenant, err)
+		}
+		if len(dict) == 0 || len(dict) > 4*1024 {
+			t.Fatalf("unexpected dict size for tenant %d: %d bytes", tenant, len(dict))
+		}
+		if dictExpected := BuildDict(samples, 4*1024); !bytes.Equal(dict, dictExpected) {
+			t.Fatalf("unexpected dict for tenant %d; got\n%X; want\n%X", tenant, dict, dictExpected)
+		}
+
+		// The dict must remain valid after the next Train call.
+		dictCopy := append([]byte{}, dict...)
+		if _, err := dt.Train(samples[:500], 2*1024); err != nil {
+			t.Fatalf("cannot train dict for tenant %d: %s", tenant, err)
+		}
+		if !bytes.Equal(dict, dictCopy) {
+			t.Fatalf("the dict for tenant %d has been changed by the next Train call", tenant)
+		}
+	}
+
+	if _, err := dt.Train(nil, 100); err == nil {
+		t.Fatalf("expecting non-nil error for too small maxDictSize")
+	}
+}
+
+func TestBuildDictOptimizeFastCover(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 2000; i++ {
+		sample := fmt.Sprintf(`{"id":%d,"name":"user %d","email":"user%d@example.com","status":%q,"role":%q}`,
+			i, rand.Intn(1000), i, []string{"active", "blocked", "pending"}[i%3], []string{"member", "admin"}[i%2])
+		samples = append(samples, []byte(sample))
+	}
+	compressedSize := func(dict []byte) int {
+		t.Helper()
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		defer cd.Release()
+		n := 0
+		for _, sample := range samples {
+			n += len(CompressDict(nil, sample, cd))
+		}
+		return n
+	}
+
+	const maxDictSize = 8 * 1024
+	dictDefault, err := BuildDictFastCover(samples, maxDictSize, FastCoverParams{
+		K: 50,
+		D: 8,
+	})
+	if err != nil {
+		t.Fatalf("cannot build fastCover dict: %s", err)
+	}
+	if len(dictDefault) == 0 || len(dictDefault) > maxDictSize {
+		t.Fatalf("unexpected fastCover dict size: %d bytes", len(dictDefault))
+	}
+
+	dict, params, err := BuildDictOptimizeFastCover(samples, maxDictSize, 8)
+	if err != nil {
+		t.Fatalf("cannot build optimized fastCover dict: %s", err)
+	}
+	if len(dict) == 0 || len(dict) > maxDictSize {
+		t.Fatalf("unexpected optimized fastCover dict size: %d bytes", len(dict))
+	}
+	if params.K <= 0 || params.D <= 0 || params.D > params.K || params.F <= 0 || params.Accel <= 0 {
+		t.Fatalf("unexpected params for optimized fastCover dict: %+v", params)
+	}
+	if n, nDefault := compressedSize(dict), compressedSize(dictDefault); n > nDefault {
+		t.Fatalf("the optimized dict must compress samples at least as well as the default dict; got %d bytes; want up to %d bytes", n, nDefault)
+	}
+
+	// The returned params must reproduce the dict.
+	dictReproduced, err := BuildDictFastCover(samples, maxDictSize, params)
+	if err != nil {
+		t.Fatalf("cannot build fastCover dict with params %+v: %s", params, err)
+	}
+	if !bytes.Equal(dictReproduced, dict) {
+		t.Fatalf("cannot reproduce the optimized dict with params %+v", params)
+	}
+
+	// Invalid args.
+	if _, err := BuildDictFastCover(samples, maxDictSize, FastCoverParams{}); err == nil {
+		t.Fatalf("expecting non-nil error for missing K and D")
+	}
+	if _, err := BuildDictFastCover(samples, 100, FastCoverParams{K: 50, D: 8}); err == nil {
+		t.Fatalf("expecting non-nil error for too small maxDictSize")
+	}
+	if _, _, err := BuildDictOptimizeFastCover(samples, 100, 0); err == nil {
+		t.Fatalf("expecting non-nil error for too small maxDictSize")
+	}
+}
+
+func TestSuggestDictSize(t *testing.T) {
+	f := func(samplesCount, sampleLen, dictLenExpected int) {
+		t.Helper()
+		sample := make([]byte, sampleLen)
+		var samples [][]byte
+		for i := 0; i < samplesCount; i++ {
+			samples = append(samples, sample)
+		}
+		dictLen := SuggestDictSize(samples)
+		if dictLen != dictLenExpected {
+			t.Fatalf("unexpected dict size for %d samples of %d bytes; got %d; want %d", samplesCount, sampleLen, dictLen, dictLenExpected)
+		}
+		if dictLen < minDictLen || dictLen > 110*1024 {
+			t.Fatalf("dict size for %d samples of %d bytes must be in the range [%d..%d]; got %d", samplesCount, sampleLen, minDictLen, 110*1024, dictLen)
+		}
+	}
+	f(0, 0, minDictLen)
+	f(10, 100, minDictLen)
+	f(1000, 100, 1000)
+	f(1000, 1000, 10000)
+	f(10000, 1024, 102400)
+	f(100000, 1024, 112640)
+
+	// The suggested size must be usable for BuildDict.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d, rand num %d, other num %X", i, rand.Intn(100), rand.Intn(100000))))
+	}
+	dictLen := SuggestDictSize(samples)
+	dict := BuildDict(samples, dictLen)
+	if len(dict) == 0 || len(dict) > dictLen {
+		t.Fatalf("unexpected dict length; got %d; want (0..%d]", len(dict), dictLen)
+	}
+}
+
+func TestRecommendedDictSize(t *testing.T) {
+	// The recommendation must grow with the samples volume until the cap.
+	prevDictLen := 0
+	for _, totalSampleBytes := range []int{0, 1000, 100 * 1024, 1024 * 1024, 10 * 1024 * 1024, 100 * 1024 * 1024} {
+		dictLen := RecommendedDictSize(totalSampleBytes)
+		if dictLen < prevDictLen {
+			t.Fatalf("the recommended dict size mustn't decrease with samples volume; got %d for %d bytes; previous size is %d", dictLen, totalSampleBytes, prevDictLen)
+		}
+		if dictLen < minDictLen || dictLen > 110*1024 {
+			t.Fatalf("dict size for %d bytes of samples must be in the range [%d..%d]; got %d", totalSampleBytes, minDictLen, 110*1024, dictLen)
+		}
+		prevDictLen = dictLen
+	}
+	if n := RecommendedDictSize(1024 * 1024); n != 10485 {
+		t.Fatalf("unexpected dict size for 1MB of samples; got %d; want %d", n, 10485)
+	}
+	if n := RecommendedDictSize(100 * 1024 * 1024); n != 110*1024 {
+		t.Fatalf("unexpected dict size for 100MB of samples; got %d; want %d", n, 110*1024)
+	}
+}
+
+func TestBuildDictZeroSize(t *testing.T) {
+	// BuildDict must use the suggested size for zero desiredDictLen.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d, rand num %d, other num %X", i, rand.Intn(100), rand.Intn(100000))))
+	}
+	dict := BuildDict(samples, 0)
+	dictExpected := BuildDict(samples, SuggestDictSize(samples))
+	if len(dict) == 0 || !bytes.Equal(dict, dictExpected) {
+		t.Fatalf("unexpected dict for zero desiredDictLen; got %d bytes; want %d bytes", len(dict), len(dictExpected))
+	}
+}
+
+func TestEvaluateDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		sample := fmt.Sprintf(`{"id":%d,"name":"user %d","email":"user%d@example.com","status":"active","role":"member"}`, i, i, i)
+		samples = append(samples, []byte(sample))
+	}
+	dict := BuildDict(samples, 8*1024)
+	if len(dict) == 0 {
+		t.Fatalf("cannot build dict")
+	}
+
+	ratioWithDict, ratioWithout, err := EvaluateDict(dict, samples, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ratioWithout <= 0 {
+		t.Fatalf("unexpected ratio without dict; got %f; want positive value", ratioWithout)
+	}
+	if ratioWithDict < 2*ratioWithout {
+		t.Fatalf("the dict must clearly improve the compression ratio; got %f with dict; %f without dict", ratioWithDict, ratioWithout)
+	}
+
+	// The ratios are averaged over samples rather than computed from totals.
+	small := []byte("a")
+	large := bytes.Repeat([]byte("a"), 1e5)
+	ratioWithDict, ratioWithout, err = EvaluateDict(dict, [][]byte{small, large}, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	smallRatio := float64(len(small)) / float64(len(CompressLevel(nil, small, 3)))
+	largeRatio := float64(len(large)) / float64(len(CompressLevel(nil, large, 3)))
+	if want := (smallRatio + largeRatio) / 2; math.Abs(ratioWithout-want) > 1e-9 {
+		t.Fatalf("unexpected average ratio without dict; got %f; want %f", ratioWithout, want)
+	}
+
+	// Empty samples.
+	ratioWithDict, ratioWithout, err = EvaluateDict(dict, [][]byte{nil, {}}, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ratioWithDict != 0 || ratioWithout != 0 {
+		t.Fatalf("unexpected ratios for empty samples; got %f, %f; want 0, 0", ratioWithDict, ratioWithout)
+	}
+
+	// Unusable dict.
+	if _, _, err := EvaluateDict(nil, samples, 3); err == nil {
+		t.Fatalf("expecting non-nil error for empty dict")
+	}
+}
+
+func TestDictStats(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		sample := fmt.Sprintf(`{"id":%d,"name":"user %d","email":"user%d@example.com","status":"active","role":"member"}`, i, i, i)
+		samples = append(samples, []byte(sample))
+	}
+	dict := BuildDict(samples, 8*1024)
+	if len(dict) == 0 {
+		t.Fatalf("cannot build dict")
+	}
+
+	withDict, withoutDict, err := DictStats(dict, samples, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if withDict <= 0 {
+		t.Fatalf("unexpected compressed size with dict; got %d; want positive value", withDict)
+	}
+	if withDict*2 > withoutDict {
+		t.Fatalf("the dict must clearly reduce the compressed size; got %d bytes with dict; %d bytes without dict", withDict, withoutDict)
+	}
+
+	// Empty samples.
+	withDict, withoutDict, err = DictStats(dict, [][]byte{nil, {}}, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if withDict != 0 || withoutDict != 0 {
+		t.Fatalf("unexpected compressed sizes for empty samples; got %d, %d; want 0, 0", withDict, withoutDict)
+	}
+
+	// Unusable dict.
+	if _, _, err := DictStats(nil, samples, 3); err == nil {
+		t.Fatalf("expecting non-nil error for empty dict")
+	}
+}
+
+func TestCompressBestDict(t *testing.T) {
+	newSamples := func(format string) [][]byte {
+		var samples [][]byte
+		for i := 0; i < 1000; i++ {
+			samples = append(samples, []byte(fmt.Sprintf(format, i, i*i, i%7)))
+		}
+		return samples
+	}
+	formats := []string{
+		`{"id":%d,"name":"user %d","email":"user@example.com","status":"active","role":"member","group":%d}`,
+		`<event><seq>%d</seq><timestamp>%d</timestamp><severity>warning</severity><source>kernel</source><cpu>%d</cpu></event>`,
+		`GET /api/v1/query?start=%d&end=%d&step=%ds HTTP/1.1 Host: example.com User-Agent: curl/7.68.0 Accept: */*`,
+	}
+	var cds []*CDict
+	for _, format := range formats {
+		dict := BuildDict(newSamples(format), 8*1024)
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		defer cd.Release()
+		cds = append(cds, cd)
+	}
+
+	for i, format := range formats {
+		src := []byte(fmt.Sprintf(format, 123456, 789, 3))
+		prefix := []byte("prefix")
+		dst, chosen := CompressBestDict(append([]byte{}, prefix...), src, cds, DefaultCompressionLevel)
+		if chosen != i {
+			t.Fatalf("unexpected dict chosen for %q; got %d; want %d", src, chosen, i)
+		}
+		if !bytes.Equal(dst[:len(prefix)], prefix) {
+			t.Fatalf("unexpected prefix; got %q; want %q", dst[:len(prefix)], prefix)
+		}
+		if want := CompressDict(nil, src, cds[i]); !bytes.Equal(dst[len(prefix):], want) {
+			t.Fatalf("unexpected compressed data for %q", src)
+		}
+	}
+
+	// Data unrelated to dicts must be compressed without dict.
+	src := bytes.Repeat([]byte("a"), 1000)
+	dst, chosen := CompressBestDict(nil, src, cds, DefaultCompressionLevel)
+	if chosen != -1 {
+		t.Fatalf("unexpected dict chosen for unrelated data; got %d; want -1", chosen)
+	}
+	plainData, err := Decompress(nil, dst)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// No dicts.
+	dst, chosen = CompressBestDict(nil, src, nil, DefaultCompressionLevel)
+	if chosen != -1 {
+		t.Fatalf("unexpected dict chosen without dicts; got %d; want -1", chosen)
+	}
+	if !bytes.Equal(dst, Compress(nil, src)) {
+		t.Fatalf("unexpected compressed data without dicts")
+	}
+}
+
 func testBuildDict(t *testing.T, samples [][]byte, desiredDictLen int) {
 	t.Helper()
 
@@ -105,3 +606,62 @@ func testBuildDict(t *testing.T, samples [][]byte, desiredDictLen int) {
 		}
 	}
 }
+
+func TestCompressSelfContained(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample #%d, rand num %d", i, i*i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	rawDict := []byte("foo bar baz sample #")
+
+	for _, d := range [][]byte{dict, rawDict} {
+		for _, src := range [][]byte{nil, []byte("sample #12345, rand num 123"), []byte(newTestString(100*1024, 3))} {
+			prefix := []byte("prefix")
+			cd, err := CompressSelfContained(append([]byte{}, prefix...), src, d, 5)
+			if err != nil {
+				t.Fatalf("cannot compress data: %s", err)
+			}
+			if !bytes.Equal(cd[:len(prefix)], prefix) {
+				t.Fatalf("unexpected prefix; got %q; want %q", cd[:len(prefix)], prefix)
+			}
+			cd = cd[len(prefix):]
+			plainData, err := DecompressSelfContained(append([]byte{}, prefix...), cd)
+			if err != nil {
+				t.Fatalf("cannot decompress data: %s", err)
+			}
+			if !bytes.Equal(plainData[:len(prefix)], prefix) || !bytes.Equal(plainData[len(prefix):], src) {
+				t.Fatalf("unexpected decompressed data")
+			}
+
+			// The result must contain the dict in the skippable frame.
+			if !bytes.Contains(cd, d) {
+				t.Fatalf("the result must contain the dict")
+			}
+
+			// Truncated src.
+			for _, n := range []int{0, 4, 8, 8 + len(d) - 1, 8 + len(d), len(cd) - 1} {
+				if _, err := DecompressSelfContained(nil, cd[:n]); err == nil {
+					t.Fatalf("expecting non-nil error for src truncated to %d bytes", n)
+				}
+			}
+		}
+	}
+
+	// The empty frame must be valid.
+	plainData, err := Decompress([]byte("foo"), emptyFrame)
+	if err != nil {
+		t.Fatalf("cannot decompress empty frame: %s", err)
+	}
+	if string(plainData) != "foo" {
+		t.Fatalf("unexpected data decompressed from empty frame; got %q; want %q", plainData, "foo")
+	}
+
+	// Invalid args.
+	if _, err := CompressSelfContained(nil, []byte("foo"), nil, 5); err == nil {
+		t.Fatalf("expecting non-nil error for empty dict")
+	}
+	if _, err := DecompressSelfContained(nil, Compress(nil, []byte("foobar"))); err == nil {
+		t.Fatalf("expecting non-nil error for src without dict frame")
+	}
+}
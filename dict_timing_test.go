@@ -0,0 +1,46 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkDictTrainer(b *testing.B) {
+	const dictsCount = 100
+	samplesPerDict := make([][][]byte, dictsCount)
+	for i := range samplesPerDict {
+		for j := 0; j < 100; j++ {
+			sample := []byte(fmt.Sprintf("tenant %d, sample %d, hex %08X", i, j, i*j))
+			samplesPerDict[i] = append(samplesPerDict[i], sample)
+		}
+	}
+	b.Run("BuildDict", func(b *testing.B) {
+		b.ReportAllocs()
+		n := 0
+		for i := 0; i < b.N; i++ {
+			for _, samples := range samplesPerDict {
+				n += len(BuildDict(samples, 1024))
+			}
+		}
+		atomic.AddUint64(&Sink, uint64(n))
+	})
+	b.Run("DictTrainer", func(b *testing.B) {
+		b.ReportAllocs()
+		var dt DictTrainer
+		n := 0
+		for i := 0; i < b.N; i++ {
+			for _, samples := range samplesPerDict {
+				dict, err := dt.Train(samples, 1024)
+				if err != nil {
+					panic(fmt.Errorf("BUG: cannot train dict: %s", err))
+				}
+				n += len(dict)
+			}
+		}
+		atomic.AddUint64(&Sink, uint64(n))
+	})
+}
@@ -1,4 +1,6 @@
 // Package gozstd is Go wrapper for zstd.
 //
 // Gozstd is used in https://github.com/VictoriaMetrics/VictoriaMetrics .
+//
+// Functions appending results to dst return dst unchanged on error.
 package gozstd
@@ -0,0 +1,20 @@
+package gozstd
+
+import (
+	"errors"
+)
+
+// ErrTruncated is returned from Decompress* functions when src ends
+// in the middle of a frame.
+//
+// Truncated src is usually caused by incomplete transfer, so it may be worth
+// re-fetching it, unlike corrupted src. Use errors.Is for detecting it.
+var ErrTruncated = errors.New("truncated zstd frame")
+
+// ErrDictionaryMismatch is returned from Decompress* functions and Reader
+// when the frame requires a dictionary other than the one used
+// for the decompression, including the case when no dictionary is used.
+//
+// Use errors.Is for detecting it and errors.As for obtaining Error with
+// the mismatched dictionary IDs.
+var ErrDictionaryMismatch = errors.New("zstd dictionary mismatch")
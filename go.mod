@@ -1,3 +1,3 @@
 module github.com/valyala/gozstd
 
-go 1.12
+go 1.17
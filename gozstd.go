@@ -7,6 +7,12 @@ package gozstd
 #include "zstd.h"
 #include "zstd_errors.h"
 
+// Private copy of xxhash bundled with zstd sources for CompressDigest.
+// It doesn't depend on xxhash symbols exported by libzstd.
+#define XXH_INLINE_ALL
+#define XXH_NAMESPACE GOZSTD_
+#include "zstd/lib/common/xxhash.h"
+
 // The following *_wrapper functions allow avoiding memory allocations
 // durting calls from Go.
 // See https://github.com/golang/go/issues/24450 .
@@ -30,24 +36,166 @@ static size_t ZSTD_decompress_usingDDict_wrapper(void *ctx, void *dst, size_t ds
 static unsigned long long ZSTD_getFrameContentSize_wrapper(void *src, size_t srcSize) {
     return ZSTD_getFrameContentSize((const void*)src, srcSize);
 }
+
+// ZSTD_compressDigest_wrapper compresses src into a single frame with content
+// checksum and calculates XXH64 of src in the same pass over src.
+// src is processed in chunks, so every chunk is hashed while it is in CPU cache.
+// dstCapacity must fit ZSTD_compressBound(srcSize) bytes.
+static size_t ZSTD_compressDigest_wrapper(void *ctx, void *dst, size_t dstCapacity, void *src, size_t srcSize, int compressionLevel, unsigned long long *digest) {
+    ZSTD_CCtx *cctx = (ZSTD_CCtx*)ctx;
+    ZSTD_outBuffer out = {dst, dstCapacity, 0};
+    XXH64_state_t state;
+    const size_t chunkSize = ZSTD_BLOCKSIZE_MAX;
+    size_t pos = 0;
+    size_t rv;
+
+    XXH64_reset(&state, 0);
+    ZSTD_CCtx_reset(cctx, ZSTD_reset_session_and_parameters);
+    rv = ZSTD_CCtx_setParameter(cctx, ZSTD_c_compressionLevel, compressionLevel);
+    if (ZSTD_isError(rv)) {
+        return rv;
+    }
+    rv = ZSTD_CCtx_setParameter(cctx, ZSTD_c_checksumFlag, 1);
+    if (ZSTD_isError(rv)) {
+        return rv;
+    }
+    rv = ZSTD_CCtx_setPledgedSrcSize(cctx, srcSize);
+    if (ZSTD_isError(rv)) {
+        return rv;
+    }
+    do {
+        size_t n = srcSize - pos;
+        if (n > chunkSize) {
+            n = chunkSize;
+        }
+        ZSTD_inBuffer in = {(const char*)src + pos, n, 0};
+        ZSTD_EndDirective mode = pos + n == srcSize ? ZSTD_e_end : ZSTD_e_continue;
+        XXH64_update(&state, in.src, n);
+        do {
+            size_t outPos = out.pos;
+            rv = ZSTD_compressStream2(cctx, &out, &in, mode);
+            if (ZSTD_isError(rv)) {
+                goto end;
+            }
+            if (rv != 0 && out.pos == outPos && out.pos == out.size) {
+                rv = (size_t)-ZSTD_error_dstSize_tooSmall;
+                goto end;
+            }
+        } while (in.pos < in.size || (mode == ZSTD_e_end && rv != 0));
+        pos += n;
+    } while (pos < srcSize);
+    *digest = XXH64_digest(&state);
+    rv = out.pos;
+end:
+    ZSTD_CCtx_reset(cctx, ZSTD_reset_session_and_parameters);
+    return rv;
+}
+
+static unsigned long long ZSTD_findDecompressedSize_wrapper(void *src, size_t srcSize) {
+    return ZSTD_findDecompressedSize((const void*)src, srcSize);
+}
+
+static unsigned long long ZSTD_decompressBound_wrapper(void *src, size_t srcSize) {
+    return ZSTD_decompressBound((const void*)src, srcSize);
+}
+
+static size_t ZSTD_decompressionMargin_wrapper(void *src, size_t srcSize) {
+    return ZSTD_decompressionMargin((const void*)src, srcSize);
+}
+
+static size_t ZSTD_findFrameCompressedSize_wrapper(void *src, size_t srcSize) {
+    return ZSTD_findFrameCompressedSize((const void*)src, srcSize);
+}
+
+static size_t ZSTD_getFrameWindowSize_wrapper(void *src, size_t srcSize, unsigned long long *windowSize) {
+    ZSTD_frameHeader zfh;
+    size_t result = ZSTD_getFrameHeader(&zfh, (const void*)src, srcSize);
+    if (result == 0) {
+        *windowSize = zfh.windowSize;
+    }
+    return result;
+}
+
+static size_t ZSTD_estimateDStreamSize_fromFrame_wrapper(void *src, size_t srcSize, unsigned long long *memorySize) {
+    ZSTD_frameHeader zfh;
+    size_t result = ZSTD_getFrameHeader(&zfh, (const void*)src, srcSize);
+    if (result != 0) {
+        return result;
+    }
+    result = ZSTD_estimateDStreamSize_fromFrame((const void*)src, srcSize);
+    if (ZSTD_isError(result)) {
+        return result;
+    }
+    *memorySize = result;
+    return 0;
+}
 */
 import "C"
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 // DefaultCompressionLevel is the default compression level.
 const DefaultCompressionLevel = 3 // Obtained from ZSTD_CLEVEL_DEFAULT.
 
-const maxFrameContentSize = 256 << 20 // 256 MB
+// MinCompressionLevel is the minimum compression level.
+//
+// Negative levels from -1 down to MinCompressionLevel trade compression
+// ratio for speed. See FastestCompressionLevel for details.
+const MinCompressionLevel = -(1 << 17) // Obtained from ZSTD_minCLevel().
+
+// DefaultMaxDirectDecompressSize is the default limit on the declared frame
+// content size for the direct decompression. See SetMaxDirectDecompressSize.
+const DefaultMaxDirectDecompressSize = 256 << 20 // 256 MB
+
+var maxDirectDecompressSize int64 = DefaultMaxDirectDecompressSize
+
+// SetMaxDirectDecompressSize sets the maximum declared frame content size,
+// which is decompressed directly into dst allocated for the whole content.
+//
+// Frames with bigger declared content size are decompressed via streaming,
+// which grows dst gradually while decompressing. The direct decompression
+// is faster, but it allocates memory for the declared content size upfront,
+// before the frame is validated. So a corrupted or malicious frame may result
+// in allocating up to n bytes. Raise the limit only if such memory usage
+// is acceptable.
+//
+// Non-positive n resets the limit to DefaultMaxDirectDecompressSize.
+// It is safe calling SetMaxDirectDecompressSize concurrently with
+// decompression.
+func SetMaxDirectDecompressSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxDirectDecompressSize
+	}
+	atomic.StoreInt64(&maxDirectDecompressSize, int64(n))
+}
+
+func getMaxDirectDecompressSize() uint64 {
+	return uint64(atomic.LoadInt64(&maxDirectDecompressSize))
+}
+
+const maxInt = int(^uint(0) >> 1)
 
 // Compress appends compressed src to dst and returns the result.
+//
+// src may exceed 2GB on 64-bit platforms. If the free capacity of dst
+// is insufficient, dst is grown by CompressBoundCached(len(src)) bytes before
+// the compression, so compressing big src requires memory for src plus
+// roughly the same amount for the compressed data in the worst case.
+// Use Writer or StreamCompress for compressing big data with memory usage
+// limited by the compression window.
 func Compress(dst, src []byte) []byte {
 	return compressDictLevel(dst, src, nil, DefaultCompressionLevel)
 }
@@ -55,10 +203,113 @@ func Compress(dst, src []byte) []byte {
 // CompressLevel appends compressed src to dst and returns the result.
 //
 // The given compressionLevel is used for the compression.
+// Negative levels down to MinCompressionLevel may be used
+// for faster compression at the cost of compression ratio.
+// Levels below MinCompressionLevel are clamped to it.
 func CompressLevel(dst, src []byte, compressionLevel int) []byte {
 	return compressDictLevel(dst, src, nil, compressionLevel)
 }
 
+// CompressBest appends compressed src to dst and returns the result.
+//
+// The maximum compression level supported by the bundled zstd is used
+// for the compression. It provides the best compression ratio
+// at the cost of the lowest compression speed.
+func CompressBest(dst, src []byte) []byte {
+	return compressDictLevel(dst, src, nil, maxCompressionLevel)
+}
+
+// CompressFastest appends compressed src to dst and returns the result.
+//
+// The minimum compression level supported by the bundled zstd is used
+// for the compression. It provides the highest compression speed
+// at the cost of the worst compression ratio.
+func CompressFastest(dst, src []byte) []byte {
+	return compressDictLevel(dst, src, nil, minCompressionLevel)
+}
+
+// FastestCompressionLevel returns the fastest compression level supported
+// by the bundled zstd.
+//
+// Use it instead of hardcoding negative levels, since the range
+// of negative levels may change between zstd versions.
+//
+// Negative levels trade compression ratio for speed: every level step down
+// skips more positions during the match search. Levels in the range [-7..-1]
+// are a few times faster than level 1 and still compress redundant data.
+// The compression ratio quickly degrades at lower levels, and the fastest
+// level stores the data almost uncompressed, even if it consists of zeros.
+func FastestCompressionLevel() int {
+	return MinCompressionLevel
+}
+
+var (
+	minCompressionLevel = int(C.ZSTD_minCLevel())
+	maxCompressionLevel = int(C.ZSTD_maxCLevel())
+)
+
+// CompressToSize compresses src into a new buffer, which fits maxBytes.
+//
+// It returns the compressed data for the highest compression level whose
+// output fits maxBytes together with the level. The maximum level produces
+// the smallest output, so it is tried first and an error is returned
+// without trying lower levels if its output exceeds maxBytes.
+func CompressToSize(src []byte, maxBytes int) ([]byte, int, error) {
+	return compressToSize(src, maxBytes, CompressLevel)
+}
+
+func compressToSize(src []byte, maxBytes int, compressLevel func(dst, src []byte, level int) []byte) ([]byte, int, error) {
+	dst := compressLevel(nil, src, maxCompressionLevel)
+	if len(dst) > maxBytes {
+		return nil, 0, fmt.Errorf("cannot compress %d bytes into %d bytes; the maximum compression level %d produces %d bytes",
+			len(src), maxBytes, maxCompressionLevel, len(dst))
+	}
+	return dst, maxCompressionLevel, nil
+}
+
+// CompressToRatio appends compressed src to dst using the fastest
+// compression level, which makes the compressed size at most maxRatio
+// of len(src), and returns the result together with the level used.
+//
+// Compression levels starting from 1 up to the maximum level are tried
+// in order. ok is false and dst is returned unchanged if none of the levels
+// achieves maxRatio. Like CompressPooled, it doesn't trim superfluous
+// capacity of the returned buffer.
+func CompressToRatio(dst, src []byte, maxRatio float64) (out []byte, levelUsed int, ok bool) {
+	maxLen := int(float64(len(src)) * maxRatio)
+	dstLen := len(dst)
+
+	// Re-use the same context and the grown dst for all the attempts.
+	cctx := cctxPool.Get().(*cctxWrapper)
+	for level := 1; level <= maxCompressionLevel; level++ {
+		dst = compress(cctx, nil, dst[:dstLen], src, nil, level, false)
+		if len(dst)-dstLen <= maxLen {
+			cctxPool.Put(cctx)
+			return dst, level, true
+		}
+	}
+	cctxPool.Put(cctx)
+	return dst[:dstLen], 0, false
+}
+
+// RecompressConcat decompresses the given frames, compresses the concatenation
+// of their contents into a single frame with the given compressionLevel,
+// appends it to dst and returns the result.
+//
+// This improves compression ratio for many small frames compressed
+// independently.
+func RecompressConcat(dst []byte, frames [][]byte, compressionLevel int) ([]byte, error) {
+	var plainData []byte
+	for i, frame := range frames {
+		var err error
+		plainData, err = Decompress(plainData, frame)
+		if err != nil {
+			return dst, fmt.Errorf("cannot decompress frame #%d: %w", i, err)
+		}
+	}
+	return compressDictLevel(dst, plainData, nil, compressionLevel), nil
+}
+
 // CompressDict appends compressed src to dst and returns the result.
 //
 // The given dictionary is used for the compression.
@@ -66,6 +317,506 @@ func CompressDict(dst, src []byte, cd *CDict) []byte {
 	return compressDictLevel(dst, src, cd, 0)
 }
 
+// CompressBoundCached returns the maximum compressed size for srcSize bytes
+// of input in the worst case.
+//
+// It computes zstd's closed-form bound in Go, so it avoids the overhead
+// of CGO call to ZSTD_compressBound, which is noticeable in hot loops
+// over small inputs. 0 is returned for negative srcSize and for srcSize,
+// whose bound doesn't fit int.
+func CompressBoundCached(srcSize int) int {
+	if srcSize < 0 {
+		return 0
+	}
+	// See ZSTD_COMPRESSBOUND in zstd.h. srcSize never exceeds
+	// ZSTD_MAX_INPUT_SIZE, since it is smaller than maxInt.
+	margin := 0
+	if srcSize < 128<<10 {
+		// margin from 64 to 0
+		margin = ((128 << 10) - srcSize) >> 11
+	}
+	extra := srcSize>>8 + margin
+	if srcSize > maxInt-extra {
+		return 0
+	}
+	return srcSize + extra
+}
+
+const (
+	// src is sampled by EstimateCompressedSize in estimateSampleChunks chunks
+	// with estimateChunkLen bytes each, evenly spread over src.
+	estimateSampleChunks = 4
+	estimateChunkLen     = 16 * 1024
+)
+
+var estimateBufPool BufferPool
+
+// EstimateCompressedSize returns the estimated size of src compressed
+// with the given compressionLevel.
+//
+// Unlike CompressBoundCached, which returns the worst-case size,
+// it estimates the typical size, so it may be used for pre-allocating
+// buffers for highly compressible data. The estimate isn't an upper bound,
+// so the buffer may need to grow during the compression.
+//
+// src up to 64KB is compressed as is, so the exact size is returned.
+// Otherwise 4 chunks of 16KB evenly spread over src are compressed
+// and the result is extrapolated to the whole src. Repetitions spanning
+// distances longer than the chunk size aren't detected, so the estimate
+// for such data exceeds the actual size.
+func EstimateCompressedSize(src []byte, compressionLevel int) int {
+	buf := estimateBufPool.Get()
+	cctx := cctxPool.Get().(*cctxWrapper)
+	defer func() {
+		cctxPool.Put(cctx)
+		estimateBufPool.Put(buf)
+	}()
+
+	if len(src) <= estimateSampleChunks*estimateChunkLen {
+		buf = compress(cctx, nil, buf[:0], src, nil, compressionLevel, false)
+		return len(buf)
+	}
+
+	compressedLen := 0
+	step := (len(src) - estimateChunkLen) / (estimateSampleChunks - 1)
+	for i := 0; i < estimateSampleChunks; i++ {
+		chunk := src[i*step : i*step+estimateChunkLen]
+		buf = compress(cctx, nil, buf[:0], chunk, nil, compressionLevel, false)
+		compressedLen += len(buf)
+	}
+	n := int(float64(compressedLen) * float64(len(src)) / float64(estimateSampleChunks*estimateChunkLen))
+	if bound := CompressBoundCached(len(src)); bound > 0 && n > bound {
+		n = bound
+	}
+	return n
+}
+
+// compressBoundC returns ZSTD_compressBound(srcSize).
+//
+// It is used for verifying CompressBoundCached.
+func compressBoundC(srcSize int) int {
+	return int(C.ZSTD_compressBound(C.size_t(srcSize)))
+}
+
+// CompressParams contains advanced compression parameters for CompressWith.
+type CompressParams struct {
+	// WindowLog is the maximum back-reference distance as a power of 2.
+	// Must be clamped between WindowLogMin and WindowLogMax32/64.
+	//
+	// The window log is derived from the compression level if zero.
+	WindowLog int
+
+	// Checksum enables writing 32-bit content checksum at the end of frame.
+	Checksum bool
+
+	// Strategy is the match finder strategy. See Strategy* constants.
+	// Use StrategyFromString for obtaining the strategy by name.
+	//
+	// The strategy is derived from the compression level if zero.
+	Strategy int
+
+	// LiteralCompressionMode controls compression of literals.
+	// See LiteralCompression* constants.
+	//
+	// Set it to LiteralCompressionDisable for speeding up the compression
+	// of incompressible data, which is still framed and checksummed by zstd.
+	LiteralCompressionMode int
+}
+
+// CompressOpts contains options for CompressWith.
+type CompressOpts struct {
+	// Level is the compression level.
+	//
+	// It is ignored if Dict is set, since the dictionary has its own level.
+	Level int
+
+	// Dict is the dictionary for the compression.
+	// The compression is performed without dictionary if Dict is nil.
+	Dict *CDict
+
+	// Params contains advanced compression parameters.
+	// Parameters derived from Level or Dict are used if Params is nil.
+	Params *CompressParams
+
+	// Into is caller-owned buffer for the compressed data.
+	//
+	// If Into isn't nil, then the compressed data is appended to Into
+	// within its capacity instead of dst, so Into is never re-allocated.
+	// An error is returned if the compressed data doesn't fit cap(Into).
+	// dst must be nil in this case.
+	Into []byte
+}
+
+// CompressWith appends compressed src to dst using the given opts
+// and returns the result.
+//
+// It produces the same output as the dedicated functions such as
+// CompressLevel and CompressDict for the corresponding opts.
+func CompressWith(dst, src []byte, opts CompressOpts) ([]byte, error) {
+	if opts.Into == nil {
+		if opts.Params == nil {
+			return compressDictLevel(dst, src, opts.Dict, opts.Level), nil
+		}
+		return compressParams(dst, src, &opts, true)
+	}
+
+	if dst != nil {
+		return dst, fmt.Errorf("dst must be nil when opts.Into is set")
+	}
+	if opts.Params != nil {
+		return compressParams(opts.Into, src, &opts, false)
+	}
+	dst = opts.Into
+	if len(src) == 0 {
+		return dst, nil
+	}
+	var cctx, cctxDict *cctxWrapper
+	if opts.Dict == nil {
+		cctx = cctxPool.Get().(*cctxWrapper)
+	} else {
+		cctxDict = cctxDictPool.Get().(*cctxWrapper)
+	}
+	dstLen := len(dst)
+	result := compressInternal(cctx, cctxDict, dst[dstLen:cap(dst)], src, opts.Dict, opts.Level, false)
+	if opts.Dict == nil {
+		cctxPool.Put(cctx)
+	} else {
+		cctxDictPool.Put(cctxDict)
+	}
+	return compressIntoResult(dst, result)
+}
+
+// CompressAt compresses src with the given compressionLevel directly
+// into buf[offset:] and returns the length of the compressed data.
+//
+// An error is returned if the compressed data doesn't fit buf[offset:].
+// The contents of buf[offset:] is undefined on error. 0 is returned
+// for empty src.
+func CompressAt(buf []byte, offset int, src []byte, compressionLevel int) (int, error) {
+	if offset < 0 || offset > len(buf) {
+		return 0, fmt.Errorf("offset %d is out of buf with %d bytes", offset, len(buf))
+	}
+	dst, err := CompressWith(nil, src, CompressOpts{
+		Level: compressionLevel,
+		Into:  buf[offset:offset:len(buf)],
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(dst), nil
+}
+
+// paddingFrameMagic is the magic number of the skippable frame
+// appended by CompressPadded.
+const paddingFrameMagic = 0x184D2A5E
+
+// skippableFrameHeaderSize is the size of skippable frame header
+// consisting of the magic number and the frame size.
+const skippableFrameHeaderSize = 8
+
+// maxPaddingBlockSize is the maximum blockSize for CompressPadded,
+// which guarantees the padding fits a single skippable frame.
+const maxPaddingBlockSize = 1 << 30
+
+// CompressPadded compresses src with the given compressionLevel, appends
+// the result to dst and returns it.
+//
+// The appended data is padded with a skippable frame, so its length is
+// a multiple of blockSize. The padding frame is at least 8 bytes long,
+// so up to blockSize+7 bytes may be added. Decompress and Reader skip
+// the padding, while DecompressPadded additionally verifies the alignment.
+// Nothing is appended for empty src.
+//
+// blockSize must be in the range [1 .. 1GB].
+func CompressPadded(dst, src []byte, compressionLevel, blockSize int) []byte {
+	if blockSize <= 0 || blockSize > maxPaddingBlockSize {
+		panic(fmt.Errorf("blockSize must be in the range [1..%d]; got %d", maxPaddingBlockSize, blockSize))
+	}
+	dstLen := len(dst)
+	dst = CompressLevel(dst, src, compressionLevel)
+	paddingLen := (blockSize - (len(dst)-dstLen)%blockSize) % blockSize
+	if paddingLen == 0 {
+		return dst
+	}
+	for paddingLen < skippableFrameHeaderSize {
+		paddingLen += blockSize
+	}
+	var header [skippableFrameHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:4], paddingFrameMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(paddingLen-skippableFrameHeaderSize))
+	dst = append(dst, header[:]...)
+	// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+	return append(dst, make([]byte, paddingLen-skippableFrameHeaderSize)...)
+}
+
+// DecompressPadded appends decompressed src produced by CompressPadded
+// with the given blockSize to dst and returns the result.
+//
+// An error is returned if the length of src isn't a multiple of blockSize.
+// src may contain multiple concatenated outputs of CompressPadded.
+func DecompressPadded(dst, src []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 {
+		return dst, fmt.Errorf("blockSize must be positive; got %d", blockSize)
+	}
+	if len(src)%blockSize != 0 {
+		return dst, fmt.Errorf("src length %d isn't a multiple of blockSize %d: %w", len(src), blockSize, ErrTruncated)
+	}
+	return Decompress(dst, src)
+}
+
+func compressIntoResult(dst []byte, result C.size_t) ([]byte, error) {
+	if zstdIsError(result) {
+		if C.ZSTD_getErrorCode(result) == C.ZSTD_error_dstSize_tooSmall {
+			return dst, fmt.Errorf("compressed data doesn't fit %d free bytes of opts.Into: %w", cap(dst)-len(dst), newError(result))
+		}
+		return dst, fmt.Errorf("cannot compress data: %w", newError(result))
+	}
+	return dst[:len(dst)+int(result)], nil
+}
+
+var cctxParamsPool sync.Pool
+
+func compressParams(dst, src []byte, opts *CompressOpts, growDst bool) ([]byte, error) {
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	v := cctxParamsPool.Get()
+	if v == nil {
+		v = NewCCtx()
+	}
+	c := v.(*CCtx)
+	err := setCompressOpts(c, opts)
+	if err == nil {
+		dstLen := len(dst)
+		if growDst {
+			compressBound := CompressBoundCached(len(src))
+			if n := dstLen + compressBound - cap(dst); n > 0 {
+				// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+				dst = append(dst[:cap(dst)], make([]byte, n)...)
+			}
+		}
+		result := c.compressInternal(dst[dstLen:cap(dst)], src)
+		dst, err = compressIntoResult(dst[:dstLen], result)
+		if growDst && cap(dst)-len(dst) > 4096 {
+			// Re-allocate dst in order to remove superflouos capacity and reduce memory usage.
+			dst = append([]byte{}, dst...)
+		}
+	}
+	// Reset the parameters and the dictionary before returning c to the pool.
+	c.ResetParameters()
+	cctxParamsPool.Put(c)
+	return dst, err
+}
+
+// CompressVec appends compressed srcs to dst and returns the result.
+//
+// srcs are compressed into a single frame as if they were concatenated,
+// but without the concatenation copy. The given compressionLevel is used
+// for the compression.
+func CompressVec(dst []byte, srcs [][]byte, compressionLevel int) []byte {
+	srcLen := 0
+	for _, src := range srcs {
+		srcLen += len(src)
+	}
+	if srcLen == 0 {
+		return dst
+	}
+
+	v := cctxParamsPool.Get()
+	if v == nil {
+		v = NewCCtx()
+	}
+	c := v.(*CCtx)
+	if err := c.SetParameter(CParamCompressionLevel, compressionLevel); err != nil {
+		panic(fmt.Errorf("BUG: cannot set compression level %d: %w", compressionLevel, err))
+	}
+	c.setPledgedSrcSize(uint64(srcLen))
+
+	dstLen := len(dst)
+	compressBound := CompressBoundCached(srcLen)
+	if n := dstLen + compressBound - cap(dst); n > 0 {
+		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+
+	// The free capacity of dst is enough for the whole frame,
+	// so every CompressStream call consumes all the passed data.
+	out := dst[dstLen:cap(dst)]
+	outLen := 0
+	for _, src := range srcs {
+		for len(src) > 0 {
+			consumed, produced, _, err := c.CompressStream(out[outLen:], src, EndContinue)
+			if err != nil {
+				panic(fmt.Errorf("BUG: unexpected error in CompressStream: %w", err))
+			}
+			outLen += produced
+			src = src[consumed:]
+		}
+	}
+	for {
+		_, produced, done, err := c.CompressStream(out[outLen:], nil, EndEnd)
+		if err != nil {
+			panic(fmt.Errorf("BUG: unexpected error in CompressStream: %w", err))
+		}
+		outLen += produced
+		if done {
+			break
+		}
+		if produced == 0 {
+			panic(fmt.Errorf("BUG: cannot finish the frame in %d bytes of dst", len(out)))
+		}
+	}
+	c.ResetParameters()
+	cctxParamsPool.Put(c)
+
+	dst = dst[:dstLen+outLen]
+	if cap(dst)-len(dst) > 4096 {
+		// Re-allocate dst in order to remove superflouos capacity and reduce memory usage.
+		dst = append([]byte{}, dst...)
+	}
+	return dst
+}
+
+func setCompressOpts(c *CCtx, opts *CompressOpts) error {
+	if opts.Dict != nil {
+		if err := c.refCDict(opts.Dict); err != nil {
+			return err
+		}
+	} else if err := c.SetParameter(CParamCompressionLevel, opts.Level); err != nil {
+		return err
+	}
+	p := opts.Params
+	if p.WindowLog != 0 {
+		if err := c.SetParameter(CParamWindowLog, p.WindowLog); err != nil {
+			return err
+		}
+	}
+	if p.Checksum {
+		if err := c.SetParameter(CParamChecksumFlag, 1); err != nil {
+			return err
+		}
+	}
+	if p.Strategy != 0 {
+		if err := c.SetParameter(CParamStrategy, p.Strategy); err != nil {
+			return err
+		}
+	}
+	if p.LiteralCompressionMode != LiteralCompressionAuto {
+		if err := c.SetParameter(CParamLiteralCompressionMode, p.LiteralCompressionMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompressMulti appends compressed src to dst and returns the result.
+//
+// The dictionary for the compression is obtained by calling selector(src).
+// If selector returns nil, then src is compressed without dictionary
+// using the given compressionLevel. Otherwise the compression level
+// of the returned CDict is used.
+//
+// It is a convenience wrapper over CompressDict and CompressLevel and keeps
+// no state between calls. Each call attaches the selected CDict to a pooled
+// compression context, which is cheap, so repeated selection of the same
+// CDict isn't cached.
+func CompressMulti(dst, src []byte, selector func(src []byte) *CDict, compressionLevel int) []byte {
+	cd := selector(src)
+	return compressDictLevel(dst, src, cd, compressionLevel)
+}
+
+// CompressDigest appends compressed src to dst and returns the result
+// together with XXH64 hash of src with zero seed.
+//
+// The hash is calculated while compressing src, so src is read only once.
+// The frame always contains 4-byte content checksum, which is the lower
+// 32 bits of the returned hash, so it is 4 bytes longer than the frame
+// returned by CompressLevel. The given compressionLevel is used
+// for the compression.
+func CompressDigest(dst, src []byte, compressionLevel int) ([]byte, uint64) {
+	if len(src) == 0 {
+		// No frame is produced for empty src.
+		return dst, emptyXXH64
+	}
+
+	dstLen := len(dst)
+	compressBound := CompressBoundCached(len(src))
+	if compressBound == 0 || compressBound > maxInt-dstLen {
+		panic(fmt.Errorf("BUG: too big src for the compression into dst with %d bytes: %d bytes", dstLen, len(src)))
+	}
+	if n := dstLen + compressBound - cap(dst); n > 0 {
+		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+
+	out := dst[dstLen:cap(dst)]
+	outHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&out)))
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	var digest C.ulonglong
+	cctx := cctxPool.Get().(*cctxWrapper)
+	result := C.ZSTD_compressDigest_wrapper(
+		unsafe.Pointer(cctx.cctx),
+		unsafe.Pointer(outHdr.Data),
+		C.size_t(len(out)),
+		unsafe.Pointer(srcHdr.Data),
+		C.size_t(len(src)),
+		C.int(compressionLevel),
+		&digest)
+	// Prevent from GC'ing of dst and src during CGO call above.
+	runtime.KeepAlive(out)
+	runtime.KeepAlive(src)
+	cctxPool.Put(cctx)
+	ensureNoError("ZSTD_compressStream2", result)
+
+	dst = dst[:dstLen+int(result)]
+	if cap(dst)-len(dst) > 4096 {
+		// Re-allocate dst in order to remove superflouos capacity and reduce memory usage.
+		dst = append([]byte{}, dst...)
+	}
+	return dst, uint64(digest)
+}
+
+// emptyXXH64 is XXH64 hash of empty data with zero seed.
+const emptyXXH64 = 0xef46db3751d8e999
+
+// CompressPooled appends compressed src to dst and returns the result.
+//
+// The given compressionLevel is used for the compression.
+//
+// Unlike CompressLevel, it never re-allocates dst in order to trim
+// superfluous capacity. dst is grown only via append when its capacity
+// is insufficient, so the returned slice shares the backing array with dst
+// when cap(dst) is big enough.
+func CompressPooled(dst, src []byte, compressionLevel int) []byte {
+	cctx := cctxPool.Get().(*cctxWrapper)
+	dst = compress(cctx, nil, dst, src, nil, compressionLevel, false)
+	cctxPool.Put(cctx)
+	return dst
+}
+
+// CompressToBuffer compresses src with the given compressionLevel
+// and appends the result to buf.
+//
+// The compressed data is written directly into the space reserved
+// via buf.Grow, so no intermediate buffer is used.
+func CompressToBuffer(buf *bytes.Buffer, src []byte, compressionLevel int) {
+	if len(src) == 0 {
+		return
+	}
+
+	buf.Grow(CompressBoundCached(len(src)))
+	b := buf.Bytes()
+	cctx := cctxPool.Get().(*cctxWrapper)
+	// The free capacity of b is enough for the compressed data,
+	// so compress doesn't re-allocate dst.
+	dst := compress(cctx, nil, b[len(b):len(b)], src, nil, compressionLevel, false)
+	cctxPool.Put(cctx)
+
+	// dst is located at the end of buf, so Write doesn't re-allocate buf.
+	buf.Write(dst)
+}
+
 func compressDictLevel(dst, src []byte, cd *CDict, compressionLevel int) []byte {
 	var cctx, cctxDict *cctxWrapper
 	if cd == nil {
@@ -74,7 +825,7 @@ func compressDictLevel(dst, src []byte, cd *CDict, compressionLevel int) []byte
 		cctxDict = cctxDictPool.Get().(*cctxWrapper)
 	}
 
-	dst = compress(cctx, cctxDict, dst, src, cd, compressionLevel)
+	dst = compress(cctx, cctxDict, dst, src, cd, compressionLevel, true)
 
 	if cd == nil {
 		cctxPool.Put(cctx)
@@ -110,14 +861,16 @@ type cctxWrapper struct {
 	cctx *C.ZSTD_CCtx
 }
 
-func compress(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, compressionLevel int) []byte {
+func compress(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, compressionLevel int, trimDst bool) []byte {
 	if len(src) == 0 {
 		return dst
 	}
 
 	dstLen := len(dst)
-	if cap(dst) > dstLen {
+	if compressedMayFit(cap(dst)-dstLen, src) {
 		// Fast path - try compressing without dst resize.
+		// It is skipped if the compressed data is unlikely to fit the free
+		// capacity of dst, since the failed attempt costs as much as the compression.
 		result := compressInternal(cctx, cctxDict, dst[dstLen:cap(dst)], src, cd, compressionLevel, false)
 		compressedSize := int(result)
 		if compressedSize >= 0 {
@@ -131,8 +884,15 @@ func compress(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, compressi
 	}
 
 	// Slow path - resize dst to fit compressed data.
-	compressBound := int(C.ZSTD_compressBound(C.size_t(len(src)))) + 1
-	if n := dstLen + compressBound - cap(dst) + dstLen; n > 0 {
+	//
+	// CompressBoundCached returns 0 only if the bound doesn't fit int.
+	// This is impossible on 64-bit platforms even for src above 2GB,
+	// while on 32-bit platforms such src cannot be allocated.
+	compressBound := CompressBoundCached(len(src))
+	if compressBound == 0 || compressBound > maxInt-dstLen {
+		panic(fmt.Errorf("BUG: too big src for the compression into dst with %d bytes: %d bytes", dstLen, len(src)))
+	}
+	if n := compressBound - (cap(dst) - dstLen); n > 0 {
 		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
 		dst = append(dst[:cap(dst)], make([]byte, n)...)
 	}
@@ -140,18 +900,61 @@ func compress(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, compressi
 	result := compressInternal(cctx, cctxDict, dst[dstLen:dstLen+compressBound], src, cd, compressionLevel, true)
 	compressedSize := int(result)
 	dst = dst[:dstLen+compressedSize]
-	if cap(dst)-len(dst) > 4096 {
+	if trimDst && cap(dst)-len(dst) > 4096 {
 		// Re-allocate dst in order to remove superflouos capacity and reduce memory usage.
 		dst = append([]byte{}, dst...)
 	}
 	return dst
 }
 
+// compressedMayFit returns true if src compressed with any parameters
+// may fit free bytes.
+//
+// src, which looks incompressible according to IsLikelyCompressible,
+// is expected to be stored in raw blocks, so it may fit free bytes only
+// if free is at least maxRawCompressedSize(len(src)). The entropy estimation
+// is performed only if free is smaller than this size.
+func compressedMayFit(free int, src []byte) bool {
+	if bound := CompressBoundCached(len(src)); bound > 0 && free >= bound {
+		return true
+	}
+	if free < minCompressedSize(len(src)) {
+		return false
+	}
+	return free >= maxRawCompressedSize(len(src)) || IsLikelyCompressible(src)
+}
+
+// minCompressedSize returns the lower bound for the size of the frame
+// holding srcSize > 0 bytes compressed with any parameters.
+//
+// The frame header occupies at least 6 bytes, while every block
+// with up to 128KB of data occupies at least 4 bytes - 3 bytes for the block
+// header and a byte for the content.
+func minCompressedSize(srcSize int) int {
+	const minFrameHeaderSize = 6
+	const minBlockSize = 4
+	blocks := (srcSize + blockSizeMax - 1) / blockSizeMax
+	return minFrameHeaderSize + blocks*minBlockSize
+}
+
+// maxRawCompressedSize returns the size of the biggest frame holding
+// srcSize > 0 bytes stored in raw blocks.
+//
+// The frame header occupies up to 18 bytes, while every raw block
+// has 3-byte header followed by up to 128KB of data as is.
+func maxRawCompressedSize(srcSize int) int {
+	const maxFrameHeaderSize = 18
+	const blockHeaderSize = 3
+	blocks := (srcSize + blockSizeMax - 1) / blockSizeMax
+	return maxFrameHeaderSize + blocks*blockHeaderSize + srcSize
+}
+
 // noescape hides a pointer from escape analysis. It is the identity function
 // but escape analysis doesn't think the output depends on the input.
 // noescape is inlined and currently compiles down to zero instructions.
 // This is copied from go's strings.Builder. Allows us to use stack-allocated
 // slices.
+//
 //go:nosplit
 //go:nocheckptr
 func noescape(p unsafe.Pointer) unsafe.Pointer {
@@ -165,6 +968,14 @@ func compressInternal(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, c
 	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
 
 	if cd != nil {
+		// Catch use-after-free before passing nil pointers to CGO,
+		// since this results in hard-to-debug crashes.
+		if cctxDict.cctx == nil {
+			panic(fmt.Errorf("BUG: compression context is used after being freed; probably it is used after returning to the pool"))
+		}
+		if cd.p == nil {
+			panic(fmt.Errorf("BUG: CDict is used after Release"))
+		}
 		result := C.ZSTD_compress_usingCDict_wrapper(
 			unsafe.Pointer(cctxDict.cctx),
 			unsafe.Pointer(dstHdr.Data),
@@ -178,28 +989,147 @@ func compressInternal(cctx, cctxDict *cctxWrapper, dst, src []byte, cd *CDict, c
 		if mustSucceed {
 			ensureNoError("ZSTD_compress_usingCDict", result)
 		}
-		return result
+		return result
+	}
+	if cctx.cctx == nil {
+		panic(fmt.Errorf("BUG: compression context is used after being freed; probably it is used after returning to the pool"))
+	}
+	result := C.ZSTD_compressCCtx_wrapper(
+		unsafe.Pointer(cctx.cctx),
+		unsafe.Pointer(dstHdr.Data),
+		C.size_t(cap(dst)),
+		unsafe.Pointer(srcHdr.Data),
+		C.size_t(len(src)),
+		C.int(compressionLevel))
+	// Prevent from GC'ing of dst and src during CGO call above.
+	runtime.KeepAlive(dst)
+	runtime.KeepAlive(src)
+	if mustSucceed {
+		ensureNoError("ZSTD_compressCCtx", result)
+	}
+
+	return result
+}
+
+// Decompress appends decompressed src to dst and returns the result.
+//
+// dst is returned unchanged if src is empty or if it contains frames
+// with empty content, i.e. Compress output for empty input.
+func Decompress(dst, src []byte) ([]byte, error) {
+	return DecompressWith(dst, src, DecompressOpts{
+		AllowMultipleFrames: true,
+	})
+}
+
+// DecompressOpts contains options for DecompressWith.
+type DecompressOpts struct {
+	// DDict is the dictionary for the decompression.
+	// The decompression is performed without dictionary if DDict is nil.
+	DDict *DDict
+
+	// MaxOutputSize limits the size of decompressed data if positive.
+	//
+	// ErrStreamLimitExceeded is returned if the decompressed data exceeds
+	// the limit regardless of the content size declared in frame headers.
+	MaxOutputSize int
+
+	// WindowLogMax limits the window size accepted by the decompressor
+	// as a power of 2 if positive. Frames requiring bigger window are rejected.
+	// This limits the memory used for decompressing untrusted src.
+	//
+	// By default frames with up to 128MB window are accepted.
+	WindowLogMax int
+
+	// AllowMultipleFrames allows src to contain multiple concatenated frames.
+	//
+	// An error is returned if src contains data after the first frame
+	// when AllowMultipleFrames isn't set.
+	AllowMultipleFrames bool
+}
+
+// DecompressWith appends decompressed src to dst using the given opts
+// and returns the result.
+//
+// Setting MaxOutputSize or WindowLogMax switches to streaming decompression,
+// which is slower than the decompression with default opts.
+func DecompressWith(dst, src []byte, opts DecompressOpts) ([]byte, error) {
+	if !opts.AllowMultipleFrames && len(src) > 0 {
+		srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+		frameSize := C.ZSTD_findFrameCompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+		runtime.KeepAlive(src)
+		// Invalid frames are detected during the decompression.
+		if !zstdIsError(frameSize) && int(frameSize) < len(src) {
+			return dst, fmt.Errorf("unexpected data after the first frame; frame size is %d bytes; src size is %d bytes", int(frameSize), len(src))
+		}
+	}
+	if opts.MaxOutputSize <= 0 && opts.WindowLogMax <= 0 {
+		return DecompressDict(dst, src, opts.DDict)
+	}
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	dstLen := len(dst)
+	sd := getStreamDecompressor(opts.DDict)
+	if opts.WindowLogMax > 0 {
+		if err := sd.setWindowLogMax(opts.WindowLogMax); err != nil {
+			putStreamDecompressor(sd)
+			return dst, err
+		}
 	}
-	result := C.ZSTD_compressCCtx_wrapper(
-		unsafe.Pointer(cctx.cctx),
-		unsafe.Pointer(dstHdr.Data),
-		C.size_t(cap(dst)),
-		unsafe.Pointer(srcHdr.Data),
-		C.size_t(len(src)),
-		C.int(compressionLevel))
-	// Prevent from GC'ing of dst and src during CGO call above.
-	runtime.KeepAlive(dst)
-	runtime.KeepAlive(src)
-	if mustSucceed {
-		ensureNoError("ZSTD_compressCCtx", result)
+	if opts.MaxOutputSize > 0 {
+		sd.maxDstLen = dstLen + opts.MaxOutputSize
 	}
-
-	return result
+	sd.dst = dst
+	sd.src = src
+	_, err := sd.zr.WriteTo(sd)
+	err = checkStreamComplete(sd.zr, err)
+	dst = sd.dst
+	putStreamDecompressor(sd)
+	if err != nil {
+		return dst[:dstLen], err
+	}
+	return dst, nil
 }
 
-// Decompress appends decompressed src to dst and returns the result.
-func Decompress(dst, src []byte) ([]byte, error) {
-	return DecompressDict(dst, src, nil)
+// ErrDeadlineExceeded is returned by DecompressVerified when
+// the decompression doesn't complete until the given deadline.
+var ErrDeadlineExceeded = errors.New("decompression deadline exceeded")
+
+// DecompressVerified appends decompressed src to dst and returns the result,
+// while enforcing the given budget for the decompression.
+//
+// ErrStreamLimitExceeded is returned as soon as the decompressed data
+// exceeds maxSize bytes regardless of the content size declared in frame
+// headers. ErrDeadlineExceeded is returned if the decompression doesn't
+// complete until the deadline. The deadline is checked between decompressed
+// blocks, so it may be exceeded by the time needed for decompressing a block.
+// Non-positive maxSize and zero deadline mean no limit. dd is used
+// for the decompression if it isn't nil.
+func DecompressVerified(dst, src []byte, dd *DDict, maxSize int, deadline time.Time) ([]byte, error) {
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		return dst, ErrDeadlineExceeded
+	}
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	dstLen := len(dst)
+	sd := getStreamDecompressor(dd)
+	if maxSize > 0 {
+		sd.maxDstLen = dstLen + maxSize
+	}
+	sd.deadline = deadline
+	sd.dst = dst
+	sd.src = src
+	_, err := sd.zr.WriteTo(sd)
+	err = checkStreamComplete(sd.zr, err)
+	dst = sd.dst
+	putStreamDecompressor(sd)
+	if err != nil {
+		return dst[:dstLen], err
+	}
+	return dst, nil
 }
 
 // DecompressDict appends decompressed src to dst and returns the result.
@@ -224,6 +1154,179 @@ func DecompressDict(dst, src []byte, dd *DDict) ([]byte, error) {
 	return dst, err
 }
 
+// DecompressFirstFrame appends the decompressed first frame from src to dst
+// and returns the result together with the size of the frame in src.
+//
+// Data after the first frame is ignored, so src may contain padding
+// or arbitrary trailing data. dd is used for the decompression if it isn't nil.
+func DecompressFirstFrame(dst, src []byte, dd *DDict) ([]byte, int, error) {
+	if len(src) == 0 {
+		return dst, 0, nil
+	}
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	frameSize := C.ZSTD_findFrameCompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	runtime.KeepAlive(src)
+	if zstdIsError(frameSize) {
+		return dst, 0, fmt.Errorf("cannot find the first frame in src: %w", newDecompressError(src, frameSize, 0))
+	}
+	n := int(frameSize)
+	out, err := DecompressDict(dst, src[:n], dd)
+	if err != nil {
+		return dst, 0, err
+	}
+	return out, n, nil
+}
+
+// DecompressMulti decompresses every frame in src and returns the decompressed
+// content for each frame as a separate slice.
+//
+// Skippable frames and frames with empty content result in empty slices.
+// dd is used for the decompression if it isn't nil.
+func DecompressMulti(src []byte, dd *DDict) ([][]byte, error) {
+	var frames [][]byte
+	for len(src) > 0 {
+		frame, n, err := DecompressFirstFrame(nil, src, dd)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress frame #%d: %w", len(frames), err)
+		}
+		frames = append(frames, frame)
+		src = src[n:]
+	}
+	return frames, nil
+}
+
+// DecompressStream appends decompressed src to dst and returns the result.
+//
+// Unlike DecompressDict, it always uses the streaming decompression
+// without probing the frame content size.
+// dd is used for the decompression if it isn't nil.
+func DecompressStream(dst, src []byte, dd *DDict) ([]byte, error) {
+	if len(src) == 0 {
+		return dst, nil
+	}
+	return streamDecompress(dst, src, dd)
+}
+
+// DecompressVec decompresses src into dsts.
+//
+// The decompressed data is written sequentially into dsts without
+// intermediate buffers, so dsts must be pre-sized to the original
+// segment lengths. An error is returned if the decompressed data size
+// doesn't match the total length of dsts. In this case dsts may contain
+// partially decompressed data.
+func DecompressVec(dsts [][]byte, src []byte) error {
+	v := dctxVecPool.Get()
+	if v == nil {
+		v = NewDCtx()
+	}
+	d := v.(*DCtx)
+	err := decompressVec(d, dsts, src)
+	// Reset the session, since decompressVec may stop in the middle of a frame.
+	d.ResetParameters()
+	dctxVecPool.Put(d)
+	return err
+}
+
+var dctxVecPool sync.Pool
+
+// DecompressFunc decompresses src and calls fn for each decompressed chunk.
+//
+// The chunk passed to fn is backed by an internal buffer, which is re-used
+// for the next chunk, so fn mustn't retain it after returning. Decompression
+// stops and the error is returned as is if fn returns an error.
+// dd is used for the decompression if it isn't nil. src may contain
+// multiple frames.
+func DecompressFunc(src []byte, dd *DDict, fn func(chunk []byte) error) error {
+	v := dctxFuncPool.Get()
+	if v == nil {
+		v = NewDCtx()
+	}
+	d := v.(*DCtx)
+	outBufWrapper := decOutBufPool.Get().(*bytes.Buffer)
+	err := decompressFunc(d, outBufWrapper.Bytes()[:cap(outBufWrapper.Bytes())], src, dd, fn)
+	decOutBufPool.Put(outBufWrapper)
+	// Reset the session and unload dd, since decompressFunc may stop
+	// in the middle of a frame.
+	d.ResetParameters()
+	dctxFuncPool.Put(d)
+	return err
+}
+
+var dctxFuncPool sync.Pool
+
+func decompressFunc(d *DCtx, buf, src []byte, dd *DDict, fn func(chunk []byte) error) error {
+	if dd != nil {
+		if err := d.LoadDDict(dd); err != nil {
+			return err
+		}
+	}
+	frameDone := true
+	for len(src) > 0 || !frameDone {
+		consumed, produced, hint, err := d.DecompressStream(buf, src)
+		if err != nil {
+			return err
+		}
+		if consumed == 0 && produced == 0 {
+			return fmt.Errorf("decompression error: %w", ErrTruncated)
+		}
+		if produced > 0 {
+			if err := fn(buf[:produced]); err != nil {
+				return err
+			}
+		}
+		src = src[consumed:]
+		frameDone = hint == 0
+	}
+	return nil
+}
+
+func decompressVec(d *DCtx, dsts [][]byte, src []byte) error {
+	dstsLen := 0
+	for _, dst := range dsts {
+		dstsLen += len(dst)
+	}
+
+	// frameDone is set when the last call, which made progress, completed a frame.
+	frameDone := true
+	written := 0
+	for _, dst := range dsts {
+		for len(dst) > 0 {
+			consumed, produced, hint, err := d.DecompressStream(dst, src)
+			if err != nil {
+				return err
+			}
+			if consumed == 0 && produced == 0 {
+				if !frameDone {
+					return fmt.Errorf("decompression error: %w", ErrTruncated)
+				}
+				return fmt.Errorf("too small decompressed data size; got %d bytes; want %d bytes", written, dstsLen)
+			}
+			src = src[consumed:]
+			dst = dst[produced:]
+			written += produced
+			frameDone = hint == 0
+		}
+	}
+
+	// Verify there is no more decompressed data left.
+	var tail [1]byte
+	for len(src) > 0 || !frameDone {
+		consumed, produced, hint, err := d.DecompressStream(tail[:], src)
+		if err != nil {
+			return err
+		}
+		if produced > 0 {
+			return fmt.Errorf("too big decompressed data size; got more than %d bytes", dstsLen)
+		}
+		if consumed == 0 {
+			return fmt.Errorf("decompression error: %w", ErrTruncated)
+		}
+		src = src[consumed:]
+		frameDone = hint == 0
+	}
+	return nil
+}
+
 var dctxPool = &sync.Pool{
 	New: newDCtx,
 }
@@ -267,7 +1370,7 @@ func decompress(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict) ([]byte
 
 		if C.ZSTD_getErrorCode(result) != C.ZSTD_error_dstSize_tooSmall {
 			// Error during decompression.
-			return dst[:dstLen], fmt.Errorf("decompression error: %s", errStr(result))
+			return dst[:dstLen], newDecompressError(src, result, ddictID(dd))
 		}
 	}
 
@@ -275,10 +1378,20 @@ func decompress(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict) ([]byte
 	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
 	contentSize := C.ZSTD_getFrameContentSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
 	switch {
-	case contentSize == C.ZSTD_CONTENTSIZE_UNKNOWN || contentSize > maxFrameContentSize:
+	case contentSize == C.ZSTD_CONTENTSIZE_UNKNOWN || uint64(contentSize) > getMaxDirectDecompressSize():
 		return streamDecompress(dst, src, dd)
 	case contentSize == C.ZSTD_CONTENTSIZE_ERROR:
-		return dst, fmt.Errorf("cannot decompress invalid src")
+		return dst, invalidSrcError(src)
+	case uint64(contentSize) >= uint64(maxInt-dstLen):
+		// dst cannot be extended by contentSize+1 bytes without int overflow.
+		// This is possible on 32-bit platforms. Fall back to streaming,
+		// which grows dst gradually.
+		return streamDecompress(dst, src, dd)
+	}
+	if isTruncatedSrc(src) {
+		// Do not allocate decompressBound bytes for src, which cannot be
+		// decompressed anyway. For example, src may contain only frame header.
+		return dst, fmt.Errorf("cannot decompress src: %w", ErrTruncated)
 	}
 	decompressBound := int(contentSize) + 1
 
@@ -299,7 +1412,196 @@ func decompress(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict) ([]byte
 	}
 
 	// Error during decompression.
-	return dst[:dstLen], fmt.Errorf("decompression error: %s", errStr(result))
+	return dst[:dstLen], newDecompressError(src, result, ddictID(dd))
+}
+
+// GetFrameWindowSize returns the window size of the first frame in src.
+//
+// The window size is the amount of memory the decompressor needs for
+// holding the already decompressed data when decompressing in streaming
+// mode. Only the frame header is parsed, so src may contain just the start
+// of the frame. 0 is returned for skippable frames.
+func GetFrameWindowSize(src []byte) (uint64, error) {
+	var windowSize C.ulonglong
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_getFrameWindowSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)), &windowSize)
+	// Prevent from GC'ing of src during CGO call above.
+	runtime.KeepAlive(src)
+	if zstdIsError(result) {
+		return 0, fmt.Errorf("cannot parse frame header: %w", newError(result))
+	}
+	if result > 0 {
+		return 0, fmt.Errorf("truncated frame header; got %d bytes; want at least %d bytes", len(src), uint64(result))
+	}
+	return uint64(windowSize), nil
+}
+
+const (
+	// zstdFrameMagic is the magic number of zstd frame.
+	zstdFrameMagic = 0xFD2FB528
+
+	// skippableFrameMagic is the magic number of skippable frame.
+	// The lower 4 bits may have arbitrary values.
+	skippableFrameMagic     = 0x184D2A50
+	skippableFrameMagicMask = 0xFFFFFFF0
+)
+
+// IsZstdFrame returns true if src starts with zstd frame or skippable frame.
+//
+// Only the magic number at the start of src is checked, so src may contain
+// just the first 4 bytes of the frame.
+func IsZstdFrame(src []byte) bool {
+	if len(src) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(src)
+	return magic == zstdFrameMagic || magic&skippableFrameMagicMask == skippableFrameMagic
+}
+
+// FrameDecompressionMemory returns the estimated memory size needed
+// for decompressing the first frame in src in streaming mode.
+//
+// The estimate is derived from the window size declared in the frame header
+// and includes the decoder context, so it may be used for rejecting frames
+// from untrusted sources, which require too much memory, before allocating
+// anything. Only the frame header is parsed, so src may contain just
+// the start of the frame. An error is returned for frames with the window
+// exceeding the maximum window supported by zstd.
+func FrameDecompressionMemory(src []byte) (uint64, error) {
+	var memorySize C.ulonglong
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_estimateDStreamSize_fromFrame_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)), &memorySize)
+	// Prevent from GC'ing of src during CGO call above.
+	runtime.KeepAlive(src)
+	if zstdIsError(result) {
+		return 0, fmt.Errorf("cannot estimate decompression memory: %w", newError(result))
+	}
+	if result > 0 {
+		return 0, fmt.Errorf("truncated frame header; got %d bytes; want at least %d bytes", len(src), uint64(result))
+	}
+	return uint64(memorySize), nil
+}
+
+// DecompressInPlaceBufferSize returns the minimum buffer size required
+// for decompressing src with DecompressInPlace.
+//
+// src may contain multiple frames. All of them must have known content size.
+func DecompressInPlaceBufferSize(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	contentSize := C.ZSTD_findDecompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	switch {
+	case contentSize == C.ZSTD_CONTENTSIZE_UNKNOWN:
+		return 0, fmt.Errorf("cannot decompress in place: src has unknown content size")
+	case contentSize == C.ZSTD_CONTENTSIZE_ERROR:
+		return 0, invalidSrcError(src)
+	case uint64(contentSize) > uint64(maxInt):
+		return 0, fmt.Errorf("cannot decompress in place: content size %d exceeds the maximum slice size", uint64(contentSize))
+	}
+	margin := C.ZSTD_decompressionMargin_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	runtime.KeepAlive(src)
+	if zstdIsError(margin) {
+		return 0, fmt.Errorf("cannot determine decompression margin: %w", newError(margin))
+	}
+	if uint64(margin) > uint64(maxInt)-uint64(contentSize) {
+		return 0, fmt.Errorf("cannot decompress in place: content size %d plus margin %d exceeds the maximum slice size", uint64(contentSize), uint64(margin))
+	}
+	return int(contentSize) + int(margin), nil
+}
+
+// DecompressBound returns the upper bound for the decompressed size
+// of all the frames in src.
+//
+// Unlike the content size stored in frame headers, the bound is known
+// for frames without content size too, since it is derived from
+// the number of blocks in every frame. It may be used for allocating dst
+// for decompressing concatenated frames. src must contain whole frames.
+func DecompressBound(src []byte) (uint64, error) {
+	if len(src) == 0 {
+		return 0, nil
+	}
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	bound := C.ZSTD_decompressBound_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	runtime.KeepAlive(src)
+	if bound == C.ZSTD_CONTENTSIZE_ERROR {
+		if isTruncatedSrc(src) {
+			return 0, fmt.Errorf("cannot determine decompressed size bound for invalid src: %w", ErrTruncated)
+		}
+		return 0, fmt.Errorf("cannot determine decompressed size bound for invalid src")
+	}
+	return uint64(bound), nil
+}
+
+// TotalContentSize returns the exact decompressed size of all the frames
+// in src according to the content sizes stored in frame headers.
+//
+// Unlike DecompressBound, it returns an error if the content size
+// of any frame is unknown. Skippable frames have zero content size.
+// src must contain whole frames.
+func TotalContentSize(src []byte) (uint64, error) {
+	total := uint64(0)
+	for frameNum := 0; len(src) > 0; frameNum++ {
+		srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+		frameSize := C.ZSTD_findFrameCompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+		contentSize := C.ZSTD_getFrameContentSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+		runtime.KeepAlive(src)
+		if zstdIsError(frameSize) {
+			return 0, fmt.Errorf("cannot find frame #%d: %w", frameNum, newDecompressError(src, frameSize, 0))
+		}
+		switch contentSize {
+		case C.ZSTD_CONTENTSIZE_UNKNOWN:
+			return 0, fmt.Errorf("content size of frame #%d is unknown", frameNum)
+		case C.ZSTD_CONTENTSIZE_ERROR:
+			return 0, fmt.Errorf("cannot read content size of frame #%d: %w", frameNum, invalidSrcError(src))
+		}
+		if uint64(contentSize) > math.MaxUint64-total {
+			return 0, fmt.Errorf("total content size exceeds %d bytes at frame #%d", uint64(math.MaxUint64), frameNum)
+		}
+		total += uint64(contentSize)
+		src = src[int(frameSize):]
+	}
+	return total, nil
+}
+
+// DecompressInPlace decompresses the compressed data stored at the end
+// of buf into the start of buf and returns the decompressed data.
+//
+// The compressed data must occupy the last compressedLen bytes of buf,
+// i.e. buf[len(buf)-compressedLen:]. The decompressed data overwrites buf
+// starting from buf[0], so the compressed data is destroyed.
+// len(buf) must be at least DecompressInPlaceBufferSize(compressed data),
+// which equals to the decompressed size plus a safety margin.
+//
+// All the frames in the compressed data must have known content size.
+//
+// The returned slice shares the memory with buf.
+func DecompressInPlace(buf []byte, compressedLen int) ([]byte, error) {
+	if compressedLen < 0 || compressedLen > len(buf) {
+		return nil, fmt.Errorf("compressedLen=%d must be in the range [0..%d]", compressedLen, len(buf))
+	}
+	if compressedLen == 0 {
+		return buf[:0], nil
+	}
+	src := buf[len(buf)-compressedLen:]
+	bufSize, err := DecompressInPlaceBufferSize(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < bufSize {
+		return nil, fmt.Errorf("too small buf for in-place decompression; got %d bytes; need at least %d bytes", len(buf), bufSize)
+	}
+
+	dctx := dctxPool.Get().(*dctxWrapper)
+	// decompressInternal uses cap(dst) as the output capacity,
+	// so limit it to len(buf), where the compressed data ends.
+	result := decompressInternal(dctx, nil, buf[:len(buf):len(buf)], src, nil)
+	dctxPool.Put(dctx)
+	if zstdIsError(result) {
+		return nil, fmt.Errorf("decompression error: %w", newError(result))
+	}
+	return buf[:int(result)], nil
 }
 
 func decompressInternal(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict) C.size_t {
@@ -308,7 +1610,15 @@ func decompressInternal(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict)
 		srcHdr = (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
 		n      C.size_t
 	)
+	// Catch use-after-free before passing nil pointers to CGO,
+	// since this results in hard-to-debug crashes.
 	if dd != nil {
+		if dctxDict.dctx == nil {
+			panic(fmt.Errorf("BUG: decompression context is used after being freed; probably it is used after returning to the pool"))
+		}
+		if dd.p == nil {
+			panic(fmt.Errorf("BUG: DDict is used after Release"))
+		}
 		n = C.ZSTD_decompress_usingDDict_wrapper(
 			unsafe.Pointer(dctxDict.dctx),
 			unsafe.Pointer(dstHdr.Data),
@@ -317,6 +1627,9 @@ func decompressInternal(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict)
 			C.size_t(len(src)),
 			unsafe.Pointer(dd.p))
 	} else {
+		if dctx.dctx == nil {
+			panic(fmt.Errorf("BUG: decompression context is used after being freed; probably it is used after returning to the pool"))
+		}
 		n = C.ZSTD_decompressDCtx_wrapper(
 			unsafe.Pointer(dctx.dctx),
 			unsafe.Pointer(dstHdr.Data),
@@ -330,6 +1643,145 @@ func decompressInternal(dctx, dctxDict *dctxWrapper, dst, src []byte, dd *DDict)
 	return n
 }
 
+// Error is an error reported by zstd.
+//
+// Errors returned from Decompress*, DecompressInPlace and Reader wrap Error
+// when they originate from zstd. Use errors.As for obtaining it.
+type Error struct {
+	// Code is zstd error code. See ZSTD_ErrorCode in zstd_errors.h.
+	Code int
+
+	// FrameDictID and DictID are set for errors matching ErrDictionaryMismatch.
+	//
+	// FrameDictID is the dictionary ID required by the frame. It is 0
+	// if it cannot be determined. DictID is the ID of the dictionary used
+	// for the decompression. It is 0 if no dictionary is used.
+	FrameDictID uint32
+	DictID      uint32
+
+	// truncated is set if the error is caused by truncated src.
+	truncated bool
+}
+
+// ErrUnsupportedFrameFeature is returned from Decompress* functions when
+// the frame uses features unsupported by the linked zstd.
+//
+// This usually means the frame is produced by newer zstd version
+// than the one returned by Version. Use errors.Is for detecting it.
+var ErrUnsupportedFrameFeature = errors.New("unsupported zstd frame feature")
+
+// Is returns true if target is ErrTruncated and e is caused by truncated src,
+// if target is ErrUnsupportedFrameFeature and e is caused by unsupported
+// frame feature, or if target is ErrDictionaryMismatch and e is caused
+// by the dictionary mismatch.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrTruncated:
+		return e.truncated
+	case ErrUnsupportedFrameFeature:
+		return e.isUnsupported()
+	case ErrDictionaryMismatch:
+		return e.isDictMismatch()
+	default:
+		return false
+	}
+}
+
+func (e *Error) isUnsupported() bool {
+	return e.Code == C.ZSTD_error_frameParameter_unsupported || e.Code == C.ZSTD_error_version_unsupported
+}
+
+func (e *Error) isDictMismatch() bool {
+	return e.Code == C.ZSTD_error_dictionary_wrong
+}
+
+// Error implements error interface.
+func (e *Error) Error() string {
+	errCStr := C.ZSTD_getErrorString(C.ZSTD_ErrorCode(e.Code))
+	s := C.GoString(errCStr)
+	if e.isUnsupported() {
+		s = fmt.Sprintf("%s; the frame may be produced by zstd newer than the linked zstd v%s", s, Version())
+	}
+	if e.isDictMismatch() {
+		frameDict := "unknown dictionary"
+		if e.FrameDictID != 0 {
+			frameDict = fmt.Sprintf("dictionary with ID %d", e.FrameDictID)
+		}
+		dict := "no dictionary is used"
+		if e.DictID != 0 {
+			dict = fmt.Sprintf("dictionary with ID %d is used", e.DictID)
+		}
+		s = fmt.Sprintf("%s; the frame requires %s, while %s", s, frameDict, dict)
+	}
+	return s
+}
+
+// Version returns the version of the linked zstd library, e.g. "1.5.5".
+func Version() string {
+	return zstdVersion
+}
+
+var zstdVersion = C.GoString(C.ZSTD_versionString())
+
+func newError(result C.size_t) *Error {
+	return &Error{
+		Code: int(C.ZSTD_getErrorCode(result)),
+	}
+}
+
+// newDecompressError returns an error for the given result of src
+// decompression with the dictionary with dictID.
+func newDecompressError(src []byte, result C.size_t, dictID uint32) error {
+	e := newError(result)
+	e.truncated = isTruncatedSrc(src)
+	e.setDictIDs(src, dictID)
+	return fmt.Errorf("decompression error: %w", e)
+}
+
+// setDictIDs sets the mismatched dictionary IDs for e if it is caused
+// by the dictionary mismatch while decompressing src with the dictionary
+// with dictID.
+func (e *Error) setDictIDs(src []byte, dictID uint32) {
+	if !e.isDictMismatch() {
+		return
+	}
+	e.FrameDictID = mismatchedFrameDictID(src, dictID)
+	e.DictID = dictID
+}
+
+// invalidSrcError returns an error for src with invalid frame header.
+func invalidSrcError(src []byte) error {
+	if isTruncatedSrc(src) {
+		return fmt.Errorf("cannot decompress invalid src: %w", ErrTruncated)
+	}
+	// Obtain the exact error from the frame header parser.
+	var windowSize C.ulonglong
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	result := C.ZSTD_getFrameWindowSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)), &windowSize)
+	runtime.KeepAlive(src)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot decompress invalid src: %w", newError(result))
+	}
+	return fmt.Errorf("cannot decompress invalid src")
+}
+
+// isTruncatedSrc returns true if src ends in the middle of a frame.
+//
+// It walks frame headers and block headers in src, so it distinguishes
+// truncated src from src with corrupted block contents.
+func isTruncatedSrc(src []byte) bool {
+	for len(src) > 0 {
+		srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+		result := C.ZSTD_findFrameCompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+		runtime.KeepAlive(src)
+		if zstdIsError(result) {
+			return C.ZSTD_getErrorCode(result) == C.ZSTD_error_srcSize_wrong
+		}
+		src = src[int(result):]
+	}
+	return false
+}
+
 func errStr(result C.size_t) string {
 	errCode := C.ZSTD_getErrorCode(result)
 	errCStr := C.ZSTD_getErrorString(errCode)
@@ -350,14 +1802,129 @@ func zstdIsError(result C.size_t) bool {
 	return C.ZSTD_isError(result) != 0
 }
 
+// DecompressHead appends up to maxBytes of decompressed src to dst
+// and returns the result.
+//
+// It stops decompressing as soon as maxBytes are produced, so it is much
+// cheaper than Decompress for obtaining the head of a big frame.
+// Less than maxBytes are appended if src decompresses to less than maxBytes.
+// dst is grown gradually while decompressing, so big maxBytes doesn't result
+// in big memory allocations for small src. ErrTruncated is returned if src
+// ends in the middle of a frame before maxBytes are produced.
+func DecompressHead(dst, src []byte, maxBytes int) ([]byte, error) {
+	if len(src) == 0 || maxBytes <= 0 {
+		return dst, nil
+	}
+
+	// Do not grow dst beyond the decompressed size if it is known.
+	// The extra byte is needed for reading till the end of src.
+	limit := maxBytes
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	contentSize := C.ZSTD_findDecompressedSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	runtime.KeepAlive(src)
+	if contentSize != C.ZSTD_CONTENTSIZE_UNKNOWN && contentSize != C.ZSTD_CONTENTSIZE_ERROR && uint64(contentSize) < uint64(limit) {
+		limit = int(contentSize) + 1
+	}
+
+	dstLen := len(dst)
+	sd := getStreamDecompressor(nil)
+	sd.src = src
+	var err error
+	for len(dst)-dstLen < maxBytes {
+		produced := len(dst) - dstLen
+		if len(dst) == cap(dst) {
+			n := produced
+			if n < int(dstreamOutBufSize) {
+				n = int(dstreamOutBufSize)
+			}
+			remaining := limit - produced
+			if remaining <= 0 {
+				// The frame content size is incorrect. The error is detected
+				// by zstd during the decompression.
+				remaining = maxBytes - produced
+			}
+			if n > remaining {
+				n = remaining
+			}
+			// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+			dst = append(dst[:cap(dst)], make([]byte, n)...)[:len(dst)]
+		}
+		buf := dst[len(dst):cap(dst)]
+		if n := maxBytes - produced; n < len(buf) {
+			buf = buf[:n]
+		}
+		var n int
+		n, err = sd.zr.Read(buf)
+		dst = dst[:len(dst)+n]
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+				if !sd.zr.FrameComplete() {
+					err = fmt.Errorf("decompression error: %w", &Error{
+						Code:      int(C.ZSTD_error_srcSize_wrong),
+						truncated: true,
+					})
+				}
+			}
+			break
+		}
+	}
+	putStreamDecompressor(sd)
+	if err != nil {
+		return dst[:dstLen], err
+	}
+	return dst, nil
+}
+
+// DecompressToBuffer appends decompressed src to buf.
+//
+// The given dictionary dd is used for the decompression if it isn't nil.
+// buf is left unchanged on error.
+func DecompressToBuffer(buf *bytes.Buffer, src []byte, dd *DDict) error {
+	if len(src) == 0 {
+		return nil
+	}
+
+	srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+	contentSize := C.ZSTD_getFrameContentSize_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	if contentSize != C.ZSTD_CONTENTSIZE_UNKNOWN && contentSize != C.ZSTD_CONTENTSIZE_ERROR && uint64(contentSize) <= getMaxDirectDecompressSize() {
+		buf.Grow(int(contentSize))
+	}
+
+	bufLen := buf.Len()
+	sd := getStreamDecompressor(dd)
+	sd.src = src
+	_, err := sd.zr.WriteTo(buf)
+	err = checkStreamComplete(sd.zr, err)
+	putStreamDecompressor(sd)
+	if err != nil {
+		buf.Truncate(bufLen)
+	}
+	return err
+}
+
+// checkStreamComplete returns an error if zr stopped in the middle of a frame
+// after successful reading of the whole src.
+func checkStreamComplete(zr *Reader, err error) error {
+	if err == nil && !zr.FrameComplete() {
+		return fmt.Errorf("decompression error: %w", ErrTruncated)
+	}
+	return err
+}
+
 func streamDecompress(dst, src []byte, dd *DDict) ([]byte, error) {
+	dstLen := len(dst)
 	sd := getStreamDecompressor(dd)
 	sd.dst = dst
 	sd.src = src
 	_, err := sd.zr.WriteTo(sd)
+	err = checkStreamComplete(sd.zr, err)
 	dst = sd.dst
 	putStreamDecompressor(sd)
-	return dst, err
+	if err != nil {
+		return dst[:dstLen], err
+	}
+	return dst, nil
 }
 
 type streamDecompressor struct {
@@ -365,6 +1932,16 @@ type streamDecompressor struct {
 	src       []byte
 	srcOffset int
 
+	// maxDstLen limits len(dst) if positive.
+	maxDstLen int
+
+	// deadline limits the decompression time if it isn't zero.
+	deadline time.Time
+
+	// windowLogMax is the windowLogMax parameter set on zr.
+	// Zero means the default value.
+	windowLogMax int
+
 	zr *Reader
 }
 
@@ -381,10 +1958,26 @@ func (sr *srcReader) Read(p []byte) (int, error) {
 }
 
 func (sd *streamDecompressor) Write(p []byte) (int, error) {
+	if !sd.deadline.IsZero() && time.Now().After(sd.deadline) {
+		return 0, ErrDeadlineExceeded
+	}
+	if sd.maxDstLen > 0 && len(sd.dst)+len(p) > sd.maxDstLen {
+		n := sd.maxDstLen - len(sd.dst)
+		sd.dst = append(sd.dst, p[:n]...)
+		return n, ErrStreamLimitExceeded
+	}
 	sd.dst = append(sd.dst, p...)
 	return len(p), nil
 }
 
+func (sd *streamDecompressor) setWindowLogMax(windowLogMax int) error {
+	if err := sd.zr.setParameter(DParamWindowLogMax, windowLogMax); err != nil {
+		return err
+	}
+	sd.windowLogMax = windowLogMax
+	return nil
+}
+
 func getStreamDecompressor(dd *DDict) *streamDecompressor {
 	v := streamDecompressorPool.Get()
 	if v == nil {
@@ -402,7 +1995,17 @@ func putStreamDecompressor(sd *streamDecompressor) {
 	sd.dst = nil
 	sd.src = nil
 	sd.srcOffset = 0
+	sd.maxDstLen = 0
+	sd.deadline = time.Time{}
 	sd.zr.Reset(nil, nil)
+	if sd.windowLogMax != 0 {
+		// The parameter persists across zr.Reset calls, so restore the default.
+		// This is possible only after zr.Reset, since zr may be stopped
+		// in the middle of a frame.
+		if err := sd.setWindowLogMax(0); err != nil {
+			panic(fmt.Errorf("BUG: cannot restore the default windowLogMax: %w", err))
+		}
+	}
 	streamDecompressorPool.Put(sd)
 }
 
@@ -0,0 +1,106 @@
+//go:build cgo && gozstd_large
+// +build cgo,gozstd_large
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// The tests in this file compress data bigger than 2GB, so they are enabled
+// only with gozstd_large build tag:
+//
+//	go test -tags gozstd_large -run Large -timeout 30m
+//
+// They require 64-bit platform and about 4GB of free memory.
+
+const largeDataSize = 3 << 30
+
+func newLargeTestData() []byte {
+	data := make([]byte, largeDataSize)
+	n := 0
+	for i := 0; n < 1024*1024; i++ {
+		n += copy(data[n:], fmt.Sprintf("line %d, value %d\n", i, i%1000))
+	}
+	// Repeat the first megabyte over the whole data.
+	for n < len(data) {
+		n += copy(data[n:], data[:n])
+	}
+	return data
+}
+
+func TestCompressDecompressLarge(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skipf("skipping the test on %d-bit platform", strconv.IntSize)
+	}
+	src := newLargeTestData()
+
+	if bound, boundExpected := CompressBoundCached(len(src)), compressBoundC(len(src)); bound != boundExpected {
+		t.Fatalf("unexpected compress bound for %d bytes; got %d; want %d", len(src), bound, boundExpected)
+	}
+
+	verify := func(name string, compressedData []byte) {
+		t.Helper()
+		contentSize, err := TotalContentSize(compressedData)
+		if err == nil && contentSize != uint64(len(src)) {
+			t.Fatalf("%s: unexpected content size; got %d; want %d", name, contentSize, len(src))
+		}
+		vw := &verifyingWriter{
+			data: src,
+		}
+		if err := StreamDecompress(vw, bytes.NewReader(compressedData)); err != nil {
+			t.Fatalf("%s: cannot decompress data: %s", name, err)
+		}
+		if vw.err != nil {
+			t.Fatalf("%s: %s", name, vw.err)
+		}
+		if vw.n != len(src) {
+			t.Fatalf("%s: unexpected decompressed data size; got %d; want %d", name, vw.n, len(src))
+		}
+	}
+
+	// Streaming compression.
+	var bb bytes.Buffer
+	if err := StreamCompress(&bb, bytes.NewReader(src)); err != nil {
+		t.Fatalf("cannot compress data: %s", err)
+	}
+	verify("stream", bb.Bytes())
+
+	// Block compression into dst with enough free capacity
+	// for the compressed data, so only src must be held in memory.
+	dst := make([]byte, 0, 64*1024*1024)
+	dst = Compress(dst, src)
+	if cap(dst) != 64*1024*1024 {
+		t.Fatalf("dst mustn't be re-allocated; got cap(dst)=%d", cap(dst))
+	}
+	if _, err := TotalContentSize(dst); err != nil {
+		t.Fatalf("cannot obtain content size: %s", err)
+	}
+	verify("block", dst)
+}
+
+// verifyingWriter verifies that the data written to it matches data.
+type verifyingWriter struct {
+	data []byte
+	n    int
+	err  error
+}
+
+func (vw *verifyingWriter) Write(p []byte) (int, error) {
+	if vw.err != nil {
+		return 0, vw.err
+	}
+	if len(p) > len(vw.data)-vw.n {
+		vw.err = fmt.Errorf("too big data written; got more than %d bytes", len(vw.data))
+		return 0, vw.err
+	}
+	if !bytes.Equal(p, vw.data[vw.n:vw.n+len(p)]) {
+		vw.err = fmt.Errorf("unexpected data written at offset %d", vw.n)
+		return 0, vw.err
+	}
+	vw.n += len(p)
+	return len(p), nil
+}
@@ -1,10 +1,17 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/bits"
 	"math/rand"
 	"runtime"
 	"strings"
@@ -63,6 +70,204 @@ func TestCompressEmpty(t *testing.T) {
 	}
 }
 
+func TestDecompressEmptyWithPrefix(t *testing.T) {
+	prefix := []byte("header")
+
+	// Compress produces zero bytes for empty input.
+	emptyFrame := Compress(nil, nil)
+	testDecompressEmptyWithPrefix(t, prefix, emptyFrame)
+
+	// Writer produces a valid frame with zero content size for empty input.
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close writer: %s", err)
+	}
+	zw.Release()
+	testDecompressEmptyWithPrefix(t, prefix, bb.Bytes())
+}
+
+func testDecompressEmptyWithPrefix(t *testing.T, prefix, src []byte) {
+	t.Helper()
+
+	// prefix without spare capacity.
+	dst := append([]byte{}, prefix...)
+	result, err := Decompress(dst[:len(dst):len(dst)], src)
+	if err != nil {
+		t.Fatalf("unexpected error when decompressing %X: %s", src, err)
+	}
+	if string(result) != string(prefix) {
+		t.Fatalf("unexpected result; got %q; want %q", result, prefix)
+	}
+
+	// prefix with spare capacity.
+	dst = make([]byte, len(prefix), len(prefix)+64)
+	copy(dst, prefix)
+	result, err = Decompress(dst, src)
+	if err != nil {
+		t.Fatalf("unexpected error when decompressing %X into buffer with spare capacity: %s", src, err)
+	}
+	if string(result) != string(prefix) {
+		t.Fatalf("unexpected result for buffer with spare capacity; got %q; want %q", result, prefix)
+	}
+}
+
+func TestDecompressHead(t *testing.T) {
+	data := []byte(newTestString(1e6, 20))
+	cd := Compress(nil, data)
+
+	for _, maxBytes := range []int{0, 1, 100, 4096, 64 * 1024, 300 * 1000} {
+		prefix := []byte("foobar")
+		head, err := DecompressHead(prefix, cd, maxBytes)
+		if err != nil {
+			t.Fatalf("cannot decompress head of %d bytes: %s", maxBytes, err)
+		}
+		if string(head[:len(prefix)]) != string(prefix) {
+			t.Fatalf("unexpected prefix in the decompressed head: %X; want %X", head[:len(prefix)], prefix)
+		}
+		head = head[len(prefix):]
+		if len(head) != maxBytes {
+			t.Fatalf("unexpected head length; got %d; want %d", len(head), maxBytes)
+		}
+		if !bytes.Equal(head, data[:maxBytes]) {
+			t.Fatalf("unexpected head contents for maxBytes=%d", maxBytes)
+		}
+	}
+
+	// maxBytes exceeding the decompressed size.
+	head, err := DecompressHead(nil, Compress(nil, []byte("foo bar")), 100)
+	if err != nil {
+		t.Fatalf("cannot decompress head of short frame: %s", err)
+	}
+	if string(head) != "foo bar" {
+		t.Fatalf("unexpected head of short frame; got %q; want %q", head, "foo bar")
+	}
+
+	// Invalid data.
+	if _, err := DecompressHead(nil, []byte("invalid compressed data"), 100); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing head of invalid data")
+	}
+
+	// Huge maxBytes mustn't result in huge allocations.
+	head, err = DecompressHead(nil, cd, maxInt)
+	if err != nil {
+		t.Fatalf("cannot decompress head with huge maxBytes: %s", err)
+	}
+	if !bytes.Equal(head, data) {
+		t.Fatalf("unexpected head contents for huge maxBytes")
+	}
+	if cap(head) > 2*len(data) {
+		t.Fatalf("too big capacity for the decompressed head; got %d; want up to %d", cap(head), 2*len(data))
+	}
+
+	// Truncated src.
+	for _, src := range [][]byte{cd[:len(cd)/2], cd[:len(cd)-1]} {
+		prefix := []byte("foobar")
+		head, err := DecompressHead(prefix, src, len(data)+1)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when decompressing head of truncated src")
+		}
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+		}
+		var ze *Error
+		if !errors.As(err, &ze) {
+			t.Fatalf("expecting *Error for truncated src; got %T", err)
+		}
+		if string(head) != string(prefix) {
+			t.Fatalf("unexpected result for truncated src; got %q; want %q", head, prefix)
+		}
+	}
+}
+
+func TestCompressToBuffer(t *testing.T) {
+	for _, size := range []int{0, 1, 1e3, 1e5, 1e6} {
+		for _, level := range []int{-3, 0, 5} {
+			data := []byte(newTestString(size, 20))
+
+			var buf bytes.Buffer
+			buf.WriteString("prefix")
+			CompressToBuffer(&buf, data, level)
+			if !bytes.Equal(buf.Bytes(), CompressLevel([]byte("prefix"), data, level)) {
+				t.Fatalf("unexpected buffer contents after compressing %d bytes at level %d", size, level)
+			}
+			plainData, err := Decompress(nil, buf.Bytes()[len("prefix"):])
+			if err != nil {
+				t.Fatalf("cannot decompress %d bytes compressed at level %d: %s", size, level, err)
+			}
+			if !bytes.Equal(plainData, data) {
+				t.Fatalf("unexpected data decompressed from buffer for %d bytes at level %d", size, level)
+			}
+		}
+	}
+
+	// CompressToBuffer mustn't allocate memory for buffer with enough capacity.
+	data := []byte(newTestString(1e5, 20))
+	var buf bytes.Buffer
+	buf.Grow(2 * len(data))
+	allocs := testing.AllocsPerRun(10, func() {
+		buf.Reset()
+		CompressToBuffer(&buf, data, 1)
+	})
+	if allocs > 0 {
+		t.Fatalf("unexpected number of memory allocations; got %v; want 0", allocs)
+	}
+}
+
+func TestDecompressToBuffer(t *testing.T) {
+	for _, size := range []int{1, 1e3, 1e5, 1e6} {
+		data := []byte(newTestString(size, 20))
+		cd := Compress(nil, data)
+
+		var buf bytes.Buffer
+		buf.WriteString("prefix")
+		if err := DecompressToBuffer(&buf, cd, nil); err != nil {
+			t.Fatalf("cannot decompress %d bytes to buffer: %s", size, err)
+		}
+		if !bytes.Equal(buf.Bytes(), append([]byte("prefix"), data...)) {
+			t.Fatalf("unexpected buffer contents after decompressing %d bytes", size)
+		}
+	}
+
+	// Decompression with dict.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is sample %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cdict, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cdict.Release()
+	ddict, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer ddict.Release()
+
+	data := []byte("this is sample 12345")
+	var buf bytes.Buffer
+	if err := DecompressToBuffer(&buf, CompressDict(nil, data, cdict), ddict); err != nil {
+		t.Fatalf("cannot decompress to buffer with dict: %s", err)
+	}
+	if buf.String() != string(data) {
+		t.Fatalf("unexpected buffer contents; got %q; want %q", buf.Bytes(), data)
+	}
+
+	// buf must remain unchanged on error.
+	buf.Reset()
+	buf.WriteString("prefix")
+	cd := Compress(nil, []byte(newTestString(1e5, 15)))
+	cd[len(cd)-1]++
+	if err := DecompressToBuffer(&buf, cd, nil); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing corrupted data")
+	}
+	if buf.String() != "prefix" {
+		t.Fatalf("unexpected buffer contents after error; got %q; want %q", buf.Bytes(), "prefix")
+	}
+}
+
 func TestDecompressTooLarge(t *testing.T) {
 	src := []byte{40, 181, 47, 253, 228, 122, 118, 105, 67, 140, 234, 85, 20, 159, 67}
 	_, err := Decompress(nil, src)
@@ -71,6 +276,258 @@ func TestDecompressTooLarge(t *testing.T) {
 	}
 }
 
+func TestDecompressInPlace(t *testing.T) {
+	for _, size := range []int{1, 100, 1e4, 3e5} {
+		data := []byte(newTestString(size, 20))
+		compressedData := Compress(nil, data)
+
+		bufSize, err := DecompressInPlaceBufferSize(compressedData)
+		if err != nil {
+			t.Fatalf("cannot determine buffer size for %d bytes: %s", size, err)
+		}
+		if bufSize < len(data) {
+			t.Fatalf("too small buffer size; got %d; must be at least %d", bufSize, len(data))
+		}
+
+		buf := make([]byte, bufSize)
+		copy(buf[len(buf)-len(compressedData):], compressedData)
+		plainData, err := DecompressInPlace(buf, len(compressedData))
+		if err != nil {
+			t.Fatalf("cannot decompress %d bytes in place: %s", size, err)
+		}
+		if !bytes.Equal(plainData, data) {
+			t.Fatalf("unexpected data decompressed in place; got\n%X; want\n%X", plainData, data)
+		}
+
+		// Too small buffer must be rejected.
+		buf = make([]byte, bufSize-1)
+		copy(buf[len(buf)-len(compressedData):], compressedData)
+		if _, err := DecompressInPlace(buf, len(compressedData)); err == nil {
+			t.Fatalf("expecting non-nil error for too small buffer")
+		}
+	}
+
+	// Frames with unknown content size cannot be decompressed in place.
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	if _, err := zw.Write([]byte("foo bar baz")); err != nil {
+		t.Fatalf("cannot write data: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot close writer: %s", err)
+	}
+	zw.Release()
+	if _, err := DecompressInPlaceBufferSize(bb.Bytes()); err == nil {
+		t.Fatalf("expecting non-nil error for frame with unknown content size")
+	}
+
+	// Invalid compressedLen.
+	if _, err := DecompressInPlace(make([]byte, 10), 11); err == nil {
+		t.Fatalf("expecting non-nil error for too big compressedLen")
+	}
+}
+
+func TestDecompressErrorType(t *testing.T) {
+	// Direct decompression path - small claimed content size.
+	_, errDirect := Decompress(nil, newCorruptedFrame(100))
+	// Stream decompression path - claimed content size exceeds DefaultMaxDirectDecompressSize.
+	_, errStream := Decompress(nil, newCorruptedFrame(1<<30))
+
+	var zerrDirect, zerrStream *Error
+	if !errors.As(errDirect, &zerrDirect) {
+		t.Fatalf("expecting *Error in the direct path; got %T: %v", errDirect, errDirect)
+	}
+	if !errors.As(errStream, &zerrStream) {
+		t.Fatalf("expecting *Error in the stream path; got %T: %v", errStream, errStream)
+	}
+	if zerrDirect.Code != zerrStream.Code {
+		t.Fatalf("unexpected error code in the stream path; got %d (%s); want %d (%s)", zerrStream.Code, zerrStream, zerrDirect.Code, zerrDirect)
+	}
+	if zerrDirect.Error() == "" {
+		t.Fatalf("expecting non-empty error message")
+	}
+}
+
+// newCorruptedFrame returns a frame claiming the given contentSize,
+// which contains a block with reserved type.
+func TestDecompressHugeContentSize(t *testing.T) {
+	// Frames declaring content size, which doesn't fit int, must be rejected
+	// without allocating dst for the declared size.
+	for _, contentSize := range []uint64{uint64(maxInt) - 1, uint64(maxInt), uint64(maxInt) + 1, 1<<64 - 3} {
+		frame := newHugeFrame(contentSize)
+		if _, err := Decompress(nil, frame); err == nil {
+			t.Fatalf("expecting non-nil error for content size %d", contentSize)
+		}
+		// Non-empty dst must be handled without int overflow.
+		dst := make([]byte, 1024)
+		if _, err := Decompress(dst, frame); err == nil {
+			t.Fatalf("expecting non-nil error for content size %d and non-empty dst", contentSize)
+		}
+		if _, err := DecompressInPlaceBufferSize(frame); err == nil {
+			t.Fatalf("expecting non-nil error from DecompressInPlaceBufferSize for content size %d", contentSize)
+		}
+	}
+}
+
+// newHugeFrame returns structurally valid single-segment frame declaring
+// the given contentSize, while containing only an empty raw block.
+func newHugeFrame(contentSize uint64) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// Frame header descriptor: single segment with 8-byte content size.
+	frame = append(frame, 0xe0)
+	var fcs [8]byte
+	binary.LittleEndian.PutUint64(fcs[:], contentSize)
+	frame = append(frame, fcs[:]...)
+	// Last empty raw block.
+	frame = append(frame, 0x01, 0x00, 0x00)
+	return frame
+}
+
+func newCorruptedFrame(contentSize uint64) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// Frame header descriptor: 8-byte content size, followed by
+	// window descriptor for 1KB window.
+	frame = append(frame, 0xc0, 0x00)
+	var fcs [8]byte
+	binary.LittleEndian.PutUint64(fcs[:], contentSize)
+	frame = append(frame, fcs[:]...)
+	// Last block with reserved block type.
+	frame = append(frame, 0x07, 0x00, 0x00)
+	return frame
+}
+
+func TestCompressPooled(t *testing.T) {
+	src := []byte(newTestString(100*1024, 20))
+	cdExpected := CompressLevel(nil, src, DefaultCompressionLevel)
+
+	// Pre-sized dst must be re-used.
+	dst := make([]byte, 0, 1024*1024)
+	dst = append(dst, "prefix"...)
+	result := CompressPooled(dst, src, DefaultCompressionLevel)
+	if &result[0] != &dst[0] {
+		t.Fatalf("the backing array of pre-sized dst must be re-used")
+	}
+	if string(result[:len("prefix")]) != "prefix" {
+		t.Fatalf("unexpected prefix in the compressed result: %q", result[:len("prefix")])
+	}
+	if !bytes.Equal(result[len("prefix"):], cdExpected) {
+		t.Fatalf("unexpected compressed data")
+	}
+
+	// Too small dst must be grown without trimming the capacity.
+	dst = make([]byte, 0, 16)
+	result = CompressPooled(dst, src, DefaultCompressionLevel)
+	if !bytes.Equal(result, cdExpected) {
+		t.Fatalf("unexpected compressed data for too small dst")
+	}
+	if cap(result)-len(result) <= 4096 {
+		t.Fatalf("the capacity of grown dst mustn't be trimmed; cap=%d, len=%d", cap(result), len(result))
+	}
+	result = CompressPooled(result[:0], src, DefaultCompressionLevel)
+	if !bytes.Equal(result, cdExpected) {
+		t.Fatalf("unexpected compressed data for grown dst")
+	}
+}
+
+func TestCompressDigest(t *testing.T) {
+	// Known XXH64 values with zero seed.
+	if _, h := CompressDigest(nil, nil, DefaultCompressionLevel); h != 0xef46db3751d8e999 {
+		t.Fatalf("unexpected hash for empty src; got %016x; want %016x", h, uint64(0xef46db3751d8e999))
+	}
+	if _, h := CompressDigest(nil, []byte("abc"), DefaultCompressionLevel); h != 0x44bc2cf5ad770999 {
+		t.Fatalf("unexpected hash for %q; got %016x; want %016x", "abc", h, uint64(0x44bc2cf5ad770999))
+	}
+
+	for _, size := range []int{1, 31, 32, 33, 1e3, 1e5, 1e6} {
+		src := []byte(newTestString(size, 256))
+		prefix := []byte("prefix")
+		dst, h := CompressDigest(prefix, src, DefaultCompressionLevel)
+		if hWant := testXXH64(src); h != hWant {
+			t.Fatalf("unexpected hash for %d bytes; got %016x; want %016x", size, h, hWant)
+		}
+		if string(dst[:len(prefix)]) != string(prefix) {
+			t.Fatalf("unexpected prefix in the compressed result: %X; want %X", dst[:len(prefix)], prefix)
+		}
+		// The frame must end with the content checksum.
+		if checksum := binary.LittleEndian.Uint32(dst[len(dst)-4:]); checksum != uint32(h) {
+			t.Fatalf("unexpected content checksum for %d bytes; got %08x; want %08x", size, checksum, uint32(h))
+		}
+		plainData, err := Decompress(nil, dst[len(prefix):])
+		if err != nil {
+			t.Fatalf("cannot decompress %d bytes: %s", size, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data for %d bytes", size)
+		}
+	}
+}
+
+// testXXH64 is a reference XXH64 implementation with zero seed.
+func testXXH64(b []byte) uint64 {
+	var (
+		p1 uint64 = 11400714785074694791
+		p2 uint64 = 14029467366897019727
+		p3 uint64 = 1609587929392839161
+		p4 uint64 = 9650029242287828579
+		p5 uint64 = 2870177450012600261
+	)
+	round := func(acc, v uint64) uint64 {
+		acc += v * p2
+		acc = bits.RotateLeft64(acc, 31)
+		return acc * p1
+	}
+	merge := func(acc, v uint64) uint64 {
+		acc ^= round(0, v)
+		return acc*p1 + p4
+	}
+
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint64(0)
+		v4 := -p1
+		for len(b) >= 32 {
+			v1 = round(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(b[24:]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = merge(h, v1)
+		h = merge(h, v2)
+		h = merge(h, v3)
+		h = merge(h, v4)
+	} else {
+		h = p5
+	}
+	h += uint64(n)
+
+	for len(b) >= 8 {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*p1 + p4
+		b = b[8:]
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * p1
+		h = bits.RotateLeft64(h, 23)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * p5
+		h = bits.RotateLeft64(h, 11) * p1
+	}
+
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}
+
 func mustUnhex(dataHex string) []byte {
 	data, err := hex.DecodeString(dataHex)
 	if err != nil {
@@ -199,6 +656,71 @@ func testCompressDecompressDistinctConcurrentDicts(cd *CDict, dd *DDict, data []
 	return nil
 }
 
+func TestCompressMulti(t *testing.T) {
+	var cdicts []*CDict
+	var ddicts []*DDict
+	defer func() {
+		for _, cd := range cdicts {
+			cd.Release()
+		}
+		for _, dd := range ddicts {
+			dd.Release()
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		var samples [][]byte
+		for j := 0; j < 1000; j++ {
+			sample := fmt.Sprintf("record type %d, field %d", i, j)
+			samples = append(samples, []byte(sample))
+		}
+		dict := BuildDict(samples, 4*1024)
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		cdicts = append(cdicts, cd)
+		dd, err := NewDDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create DDict: %s", err)
+		}
+		ddicts = append(ddicts, dd)
+	}
+
+	n := 0
+	selector := func(src []byte) *CDict {
+		cd := cdicts[n%len(cdicts)]
+		n++
+		return cd
+	}
+	for i := 0; i < 10; i++ {
+		src := []byte(fmt.Sprintf("record type %d, field %d", i%2, i))
+		compressedData := CompressMulti(nil, src, selector, DefaultCompressionLevel)
+		plainData, err := DecompressDict(nil, compressedData, ddicts[i%2])
+		if err != nil {
+			t.Fatalf("cannot decompress frame #%d: %s", i, err)
+		}
+		if string(plainData) != string(src) {
+			t.Fatalf("unexpected data for frame #%d; got %q; want %q", i, plainData, src)
+		}
+
+		// The frame mustn't decompress with the other dict.
+		if _, err := DecompressDict(nil, compressedData, ddicts[(i+1)%2]); err == nil {
+			t.Fatalf("expecting non-nil error when decompressing frame #%d with the wrong dict", i)
+		}
+	}
+
+	// nil dict means compression without dictionary.
+	src := []byte("foo bar baz")
+	compressedData := CompressMulti(nil, src, func(src []byte) *CDict { return nil }, 5)
+	plainData, err := Decompress(nil, compressedData)
+	if err != nil {
+		t.Fatalf("cannot decompress data compressed without dict: %s", err)
+	}
+	if string(plainData) != string(src) {
+		t.Fatalf("unexpected data; got %q; want %q", plainData, src)
+	}
+}
+
 func TestCompressDecompressDict(t *testing.T) {
 	var samples [][]byte
 	for i := 0; i < 1000; i++ {
@@ -269,71 +791,1220 @@ func testCompressDecompressDictSerial(cd *CDict, dd *DDict) error {
 	return nil
 }
 
-func TestDecompressInvalidData(t *testing.T) {
-	// Try decompressing invalid data.
-	src := []byte("invalid compressed data")
-	buf := make([]byte, len(src))
-	if _, err := Decompress(nil, src); err == nil {
-		t.Fatalf("expecting error when decompressing invalid data")
+func TestDecompressTruncated(t *testing.T) {
+	src := []byte(newTestString(300*1024, 3))
+
+	// Frame with unknown content size and multiple blocks.
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	for i := 0; i < len(src); i += 10000 {
+		end := i + 10000
+		if end > len(src) {
+			end = len(src)
+		}
+		if _, err := zw.Write(src[i:end]); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		if err := zw.Flush(); err != nil {
+			t.Fatalf("unexpected error in Flush: %s", err)
+		}
 	}
-	if _, err := Decompress(buf[:0], src); err == nil {
-		t.Fatalf("expecting error when decompressing invalid data into existing buffer")
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
 	}
+	zw.Release()
 
-	// Try decompressing corrupted data.
-	s := newTestString(64*1024, 15)
-	cd := Compress(nil, []byte(s))
-	cd[len(cd)-1]++
+	// Frame with checksum.
+	c := NewCCtx()
+	defer c.Release()
+	if err := c.SetParameter(CParamChecksumFlag, 1); err != nil {
+		t.Fatalf("cannot enable checksum: %s", err)
+	}
 
-	if _, err := Decompress(nil, cd); err == nil {
-		t.Fatalf("expecting error when decompressing corrupted data")
+	frames := map[string][]byte{
+		"known size":   Compress(nil, src),
+		"unknown size": bb.Bytes(),
+		"checksum":     c.Compress(nil, src),
+		"multi-frame":  append(Compress(nil, src[:1000]), Compress(nil, src)...),
 	}
-	if _, err := Decompress(buf[:0], cd); err == nil {
-		t.Fatalf("expecting error when decompressing corrupdate data into existing buffer")
+	for name, cd := range frames {
+		for _, n := range []int{1, 3, 4, 5, 6, 10, len(cd) / 3, len(cd) / 2, len(cd) - 5, len(cd) - 4, len(cd) - 1} {
+			truncated := cd[:n]
+			if _, err := Decompress(nil, truncated); !errors.Is(err, ErrTruncated) {
+				t.Fatalf("unexpected error for %s frame truncated to %d bytes; got %v; want %v", name, n, err, ErrTruncated)
+			}
+			prefix := []byte("prefix")
+			dst, err := Decompress(append(make([]byte, 0, 2*len(src)), prefix...), truncated)
+			if !errors.Is(err, ErrTruncated) {
+				t.Fatalf("unexpected error for %s frame truncated to %d bytes decompressed into big buffer; got %v; want %v", name, n, err, ErrTruncated)
+			}
+			if !bytes.Equal(dst, prefix) {
+				t.Fatalf("dst must be returned unchanged on error for %s frame truncated to %d bytes; got %q; want %q", name, n, dst, prefix)
+			}
+		}
 	}
-}
 
-func TestCompressLevel(t *testing.T) {
-	src := []byte("foobar baz")
-
-	for compressLevel := 1; compressLevel < 22; compressLevel++ {
-		testCompressLevel(t, src, compressLevel)
+	// Corrupted data mustn't be reported as truncated.
+	cd := Compress(nil, src)
+	cd[len(cd)-1]++
+	if _, err := Decompress(nil, cd); err == nil || errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for corrupted frame; got %v; want non-truncated error", err)
+	}
+	if _, err := Decompress(nil, []byte("invalid compressed data")); err == nil || errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for invalid data; got %v; want non-truncated error", err)
 	}
-
-	// Test invalid compression levels - they should clamp
-	// to the closest valid levels.
-	testCompressLevel(t, src, -123)
-	testCompressLevel(t, src, 234324)
 }
 
-func testCompressLevel(t *testing.T, src []byte, compressionLevel int) {
-	t.Helper()
+func TestDecompressHeaderOnly(t *testing.T) {
+	src := []byte(newTestString(1024*1024, 3))
+	cd := Compress(nil, src)
 
-	cd := CompressLevel(nil, src, compressionLevel)
-	dd, err := Decompress(nil, cd)
-	if err != nil {
-		t.Fatalf("unexpected error during decompression: %s", err)
+	// Find the frame header length.
+	headerLen := 0
+	for n := 1; n <= len(cd); n++ {
+		if _, err := GetFrameWindowSize(cd[:n]); err == nil {
+			headerLen = n
+			break
+		}
 	}
-	if string(dd) != string(src) {
-		t.Fatalf("unexpected dd\n%X; want\n%X", dd, src)
+	if headerLen == 0 || headerLen >= len(cd) {
+		t.Fatalf("cannot determine frame header length")
 	}
-}
+	header := cd[:headerLen]
 
-func TestCompressDecompress(t *testing.T) {
-	testCompressDecompress(t, "")
-	testCompressDecompress(t, "a")
-	testCompressDecompress(t, "foo bar")
+	dst, err := Decompress(nil, header)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for header-only src; got %v; want %v", err, ErrTruncated)
+	}
+	if cap(dst) > 0 {
+		t.Fatalf("dst mustn't be allocated for header-only src; got cap(dst)=%d", cap(dst))
+	}
 
-	for size := 1; size <= 1e6; size *= 10 {
-		s := newTestString(size, 20)
-		testCompressDecompress(t, s)
+	prefix := []byte("prefix")
+	dst, err = Decompress(prefix, header)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for header-only src; got %v; want %v", err, ErrTruncated)
+	}
+	if &dst[0] != &prefix[0] || !bytes.Equal(dst, prefix) {
+		t.Fatalf("dst must be returned unchanged for header-only src; got %q; want %q", dst, prefix)
 	}
 }
 
-func testCompressDecompress(t *testing.T, s string) {
-	t.Helper()
-
-	if err := testCompressDecompressSerial(s); err != nil {
+func TestDecompressFirstFrame(t *testing.T) {
+	src := []byte(newTestString(100*1024, 3))
+	r := rand.New(rand.NewSource(1))
+	for _, trailerLen := range []int{0, 1, 3, 4, 100, 4096} {
+		trailer := make([]byte, trailerLen)
+		r.Read(trailer)
+		for _, frame := range [][]byte{Compress(nil, src), mustCompressStream(t, src)} {
+			data := append(append([]byte{}, frame...), trailer...)
+			prefix := []byte("prefix")
+			dst, n, err := DecompressFirstFrame(prefix, data, nil)
+			if err != nil {
+				t.Fatalf("unexpected error for frame with %d trailing bytes: %s", trailerLen, err)
+			}
+			if n != len(frame) {
+				t.Fatalf("unexpected number of consumed bytes for frame with %d trailing bytes; got %d; want %d", trailerLen, n, len(frame))
+			}
+			if !bytes.Equal(dst[:len(prefix)], prefix) {
+				t.Fatalf("unexpected prefix; got %q; want %q", dst[:len(prefix)], prefix)
+			}
+			if !bytes.Equal(dst[len(prefix):], src) {
+				t.Fatalf("unexpected decompressed data for frame with %d trailing bytes", trailerLen)
+			}
+		}
+	}
+
+	// Multiple frames must be decompressed one by one.
+	data := append(Compress(nil, src[:1000]), Compress(nil, src)...)
+	data = append(data, 0, 0, 0)
+	dst, n, err := DecompressFirstFrame(nil, data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(dst, src[:1000]) {
+		t.Fatalf("unexpected data for the first frame")
+	}
+	dst, m, err := DecompressFirstFrame(nil, data[n:], nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(dst, src) {
+		t.Fatalf("unexpected data for the second frame")
+	}
+	if n+m != len(data)-3 {
+		t.Fatalf("unexpected number of consumed bytes; got %d; want %d", n+m, len(data)-3)
+	}
+
+	// Truncated and invalid frames.
+	cd := Compress(nil, src)
+	if _, _, err := DecompressFirstFrame(nil, cd[:len(cd)-1], nil); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated frame; got %v; want %v", err, ErrTruncated)
+	}
+	prefix := []byte("prefix")
+	dst, n, err = DecompressFirstFrame(prefix, []byte("invalid compressed data"), nil)
+	if err == nil {
+		t.Fatalf("expecting non-nil error for invalid data")
+	}
+	if n != 0 || !bytes.Equal(dst, prefix) {
+		t.Fatalf("unexpected result for invalid data; got %q, %d; want %q, 0", dst, n, prefix)
+	}
+}
+
+func TestDecompressMulti(t *testing.T) {
+	srcs := [][]byte{
+		[]byte(newTestString(100*1024, 3)),
+		[]byte("short frame"),
+		[]byte(newTestString(300*1024, 10)),
+	}
+	var data []byte
+	data = append(data, Compress(nil, srcs[0])...)
+	data = append(data, Compress(nil, srcs[1])...)
+	data = append(data, mustCompressStream(t, srcs[2])...)
+	frames, err := DecompressMulti(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(frames) != len(srcs) {
+		t.Fatalf("unexpected number of frames; got %d; want %d", len(frames), len(srcs))
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(frame, srcs[i]) {
+			t.Fatalf("unexpected content for frame #%d", i)
+		}
+	}
+
+	// Empty src.
+	frames, err = DecompressMulti(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error for empty src: %s", err)
+	}
+	if len(frames) != 0 {
+		t.Fatalf("unexpected number of frames for empty src; got %d; want 0", len(frames))
+	}
+
+	// Frames with dict.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is sample %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+	data = data[:0]
+	for _, sample := range samples[:3] {
+		data = CompressDict(data, sample, cd)
+	}
+	frames, err = DecompressMulti(data, dd)
+	if err != nil {
+		t.Fatalf("unexpected error with dict: %s", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("unexpected number of frames with dict; got %d; want 3", len(frames))
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(frame, samples[i]) {
+			t.Fatalf("unexpected content for frame #%d with dict; got %q; want %q", i, frame, samples[i])
+		}
+	}
+
+	// Truncated last frame.
+	if _, err := DecompressMulti(data[:len(data)-1], dd); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated frame; got %v; want %v", err, ErrTruncated)
+	}
+}
+
+func TestDecompressFunc(t *testing.T) {
+	srcs := [][]byte{
+		[]byte(newTestString(1024*1024, 3)),
+		[]byte("short frame"),
+		[]byte(newTestString(300*1024, 10)),
+	}
+	var data []byte
+	data = append(data, Compress(nil, srcs[0])...)
+	data = append(data, Compress(nil, srcs[1])...)
+	data = append(data, mustCompressStream(t, srcs[2])...)
+	plainDataExpected := bytes.Join(srcs, nil)
+
+	// Concatenated chunks must match the full decompression.
+	var plainData []byte
+	chunks := 0
+	err := DecompressFunc(data, nil, func(chunk []byte) error {
+		plainData = append(plainData, chunk...)
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(plainData, plainDataExpected) {
+		t.Fatalf("unexpected decompressed data; len(got)=%d; len(want)=%d", len(plainData), len(plainDataExpected))
+	}
+	if chunks < 2 {
+		t.Fatalf("expecting multiple chunks; got %d", chunks)
+	}
+
+	// An error in fn must stop the decompression.
+	errStop := fmt.Errorf("stop")
+	chunks = 0
+	err = DecompressFunc(data, nil, func(chunk []byte) error {
+		chunks++
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("unexpected error; got %v; want %v", err, errStop)
+	}
+	if chunks != 1 {
+		t.Fatalf("fn mustn't be called after returning an error; got %d calls", chunks)
+	}
+
+	// Truncated and invalid src.
+	if err := DecompressFunc(data[:len(data)-1], nil, func(chunk []byte) error { return nil }); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+	}
+	if err := DecompressFunc([]byte("invalid frame"), nil, func(chunk []byte) error { return nil }); err == nil {
+		t.Fatalf("expecting non-nil error for invalid src")
+	}
+
+	// Frames with dict.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("this is sample %d", i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+	data = data[:0]
+	for _, sample := range samples[:3] {
+		data = CompressDict(data, sample, cd)
+	}
+	plainData = plainData[:0]
+	err = DecompressFunc(data, dd, func(chunk []byte) error {
+		plainData = append(plainData, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error with dict: %s", err)
+	}
+	if plainDataExpected := bytes.Join(samples[:3], nil); !bytes.Equal(plainData, plainDataExpected) {
+		t.Fatalf("unexpected decompressed data with dict; got %q; want %q", plainData, plainDataExpected)
+	}
+}
+
+func mustCompressStream(t *testing.T, src []byte) []byte {
+	t.Helper()
+	var bb bytes.Buffer
+	if err := StreamCompress(&bb, bytes.NewReader(src)); err != nil {
+		t.Fatalf("cannot compress stream: %s", err)
+	}
+	return bb.Bytes()
+}
+
+func TestDecompressStream(t *testing.T) {
+	src := []byte(newTestString(300*1024, 3))
+	cd := mustCompressStream(t, src)
+
+	prefix := []byte("prefix")
+	dst, err := DecompressStream(prefix, cd, nil)
+	if err != nil {
+		t.Fatalf("cannot decompress frame without content size: %s", err)
+	}
+	if !bytes.Equal(dst[:len(prefix)], prefix) || !bytes.Equal(dst[len(prefix):], src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// Frames with content size and multiple frames.
+	multiFrame := append(Compress(nil, src[:1000]), cd...)
+	dst, err = DecompressStream(nil, multiFrame, nil)
+	if err != nil {
+		t.Fatalf("cannot decompress multiple frames: %s", err)
+	}
+	if !bytes.Equal(dst, append(append([]byte{}, src[:1000]...), src...)) {
+		t.Fatalf("unexpected data decompressed from multiple frames")
+	}
+
+	// Frame compressed with dict.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample #%d, rand num %d", i, i*i)))
+	}
+	dict := BuildDict(samples, 8*1024)
+	cdict, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cdict.Release()
+	ddict, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer ddict.Release()
+	var bb bytes.Buffer
+	if err := StreamCompressDict(&bb, bytes.NewReader(samples[123]), cdict); err != nil {
+		t.Fatalf("cannot compress data with dict: %s", err)
+	}
+	dst, err = DecompressStream(nil, bb.Bytes(), ddict)
+	if err != nil {
+		t.Fatalf("cannot decompress data with dict: %s", err)
+	}
+	if !bytes.Equal(dst, samples[123]) {
+		t.Fatalf("unexpected data decompressed with dict; got %q; want %q", dst, samples[123])
+	}
+
+	// Empty, truncated and invalid src.
+	dst, err = DecompressStream(prefix, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error for empty src: %s", err)
+	}
+	if !bytes.Equal(dst, prefix) {
+		t.Fatalf("unexpected result for empty src; got %q; want %q", dst, prefix)
+	}
+	dst, err = DecompressStream(prefix, cd[:len(cd)-1], nil)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+	}
+	if !bytes.Equal(dst, prefix) {
+		t.Fatalf("dst must be returned unchanged for truncated src; got %q; want %q", dst, prefix)
+	}
+	if _, err := DecompressStream(nil, []byte("invalid compressed data"), nil); err == nil {
+		t.Fatalf("expecting non-nil error for invalid src")
+	}
+}
+
+func TestCompressVec(t *testing.T) {
+	f := func(srcs [][]byte, compressionLevel int) {
+		t.Helper()
+		joined := bytes.Join(srcs, nil)
+		prefix := []byte("prefix")
+		cd := CompressVec(append([]byte{}, prefix...), srcs, compressionLevel)
+		if !bytes.Equal(cd[:len(prefix)], prefix) {
+			t.Fatalf("unexpected prefix; got %q; want %q", cd[:len(prefix)], prefix)
+		}
+		cd = cd[len(prefix):]
+		if len(joined) == 0 {
+			if len(cd) != 0 {
+				t.Fatalf("unexpected non-empty result for empty srcs; got %d bytes", len(cd))
+			}
+			return
+		}
+		if !bytes.Equal(cd, CompressLevel(nil, joined, compressionLevel)) {
+			t.Fatalf("CompressVec result must match CompressLevel result for the joined srcs")
+		}
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data: %s", err)
+		}
+		if !bytes.Equal(plainData, joined) {
+			t.Fatalf("unexpected decompressed data")
+		}
+		contentSize, err := DecompressInPlaceBufferSize(cd)
+		if err != nil {
+			t.Fatalf("the frame must contain content size: %s", err)
+		}
+		if contentSize < len(joined) {
+			t.Fatalf("unexpected content size; got %d; want at least %d", contentSize, len(joined))
+		}
+	}
+
+	header := []byte("header: foo bar\n")
+	payload := []byte(newTestString(300*1024, 3))
+	trailer := []byte("trailer: baz")
+	f(nil, DefaultCompressionLevel)
+	f([][]byte{nil, {}}, DefaultCompressionLevel)
+	f([][]byte{header}, DefaultCompressionLevel)
+	f([][]byte{header, payload, trailer}, DefaultCompressionLevel)
+	f([][]byte{header, nil, payload, {}, trailer}, 1)
+	f([][]byte{payload, payload, payload}, 5)
+	f([][]byte{header, trailer}, -5)
+}
+
+func TestSetMaxDirectDecompressSize(t *testing.T) {
+	defer SetMaxDirectDecompressSize(0)
+
+	src := []byte(newTestString(1024*1024, 3))
+	cd := Compress(nil, src)
+	decompressAllocs := func() float64 {
+		t.Helper()
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+		return testing.AllocsPerRun(10, func() {
+			if _, err := Decompress(nil, cd); err != nil {
+				panic(fmt.Errorf("cannot decompress data: %w", err))
+			}
+		})
+	}
+
+	// The direct decompression allocates dst for the whole content at once,
+	// while the streaming decompression grows dst gradually.
+	allocsDirect := decompressAllocs()
+	SetMaxDirectDecompressSize(len(src) / 2)
+	allocsStream := decompressAllocs()
+	if allocsStream <= 2*allocsDirect {
+		t.Fatalf("the streaming decompression must be used for content size exceeding the limit; got %v allocations vs %v allocations for direct decompression",
+			allocsStream, allocsDirect)
+	}
+
+	// Raise the threshold above the default limit.
+	SetMaxDirectDecompressSize(2 * DefaultMaxDirectDecompressSize)
+	if n := getMaxDirectDecompressSize(); n != 2*DefaultMaxDirectDecompressSize {
+		t.Fatalf("unexpected limit; got %d; want %d", n, 2*DefaultMaxDirectDecompressSize)
+	}
+	if allocs := decompressAllocs(); allocs > allocsDirect+1 {
+		t.Fatalf("the direct decompression must be used at the raised limit; got %v allocations; want no more than %v", allocs, allocsDirect+1)
+	}
+
+	// Non-positive value resets the default limit.
+	SetMaxDirectDecompressSize(-1)
+	if n := getMaxDirectDecompressSize(); n != DefaultMaxDirectDecompressSize {
+		t.Fatalf("unexpected limit after reset; got %d; want %d", n, DefaultMaxDirectDecompressSize)
+	}
+}
+
+func TestDecompressVec(t *testing.T) {
+	header := []byte("header: foo bar\n")
+	payload := []byte(newTestString(300*1024, 3))
+	trailer := []byte("trailer: baz")
+	srcs := [][]byte{header, payload, trailer}
+	joined := bytes.Join(srcs, nil)
+
+	newDsts := func(lens ...int) [][]byte {
+		var dsts [][]byte
+		for _, n := range lens {
+			dsts = append(dsts, make([]byte, n))
+		}
+		return dsts
+	}
+
+	for _, cd := range [][]byte{Compress(nil, joined), mustCompressStream(t, joined), CompressVec(nil, srcs, 5)} {
+		dsts := newDsts(len(header), len(payload), len(trailer))
+		if err := DecompressVec(dsts, cd); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for i, dst := range dsts {
+			if !bytes.Equal(dst, srcs[i]) {
+				t.Fatalf("unexpected data in segment #%d", i)
+			}
+		}
+
+		// Segment boundaries may differ from the original ones.
+		dsts = newDsts(1, len(joined)-2, 1)
+		if err := DecompressVec(dsts, cd); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(bytes.Join(dsts, nil), joined) {
+			t.Fatalf("unexpected decompressed data")
+		}
+
+		// Size mismatch.
+		if err := DecompressVec(newDsts(len(header), len(payload), len(trailer)-1), cd); err == nil {
+			t.Fatalf("expecting non-nil error for too small dsts")
+		}
+		if err := DecompressVec(newDsts(len(header), len(payload), len(trailer)+1), cd); err == nil || errors.Is(err, ErrTruncated) {
+			t.Fatalf("unexpected error for too big dsts; got %v; want non-truncated error", err)
+		}
+
+		// Truncated src.
+		dsts = newDsts(len(header), len(payload), len(trailer))
+		if err := DecompressVec(dsts, cd[:len(cd)-1]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+		}
+	}
+
+	// Multiple frames.
+	cd := append(Compress(nil, header), Compress(nil, payload)...)
+	dsts := newDsts(len(header)+10, len(payload)-10)
+	if err := DecompressVec(dsts, cd); err != nil {
+		t.Fatalf("unexpected error for multiple frames: %s", err)
+	}
+	if !bytes.Equal(bytes.Join(dsts, nil), append(append([]byte{}, header...), payload...)) {
+		t.Fatalf("unexpected data decompressed from multiple frames")
+	}
+
+	// Empty src and dsts.
+	if err := DecompressVec(nil, nil); err != nil {
+		t.Fatalf("unexpected error for empty src: %s", err)
+	}
+	if err := DecompressVec(newDsts(0, 0), nil); err != nil {
+		t.Fatalf("unexpected error for empty src: %s", err)
+	}
+	if err := DecompressVec(newDsts(10), nil); err == nil {
+		t.Fatalf("expecting non-nil error for empty src and non-empty dsts")
+	}
+	if err := DecompressVec(nil, []byte("invalid compressed data")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid src")
+	}
+}
+
+func TestDecompressBound(t *testing.T) {
+	src := []byte(newTestString(300*1024, 3))
+	frames := [][]byte{
+		Compress(nil, src[:1000]),
+		mustCompressStream(t, src),
+		CompressLevel(nil, src[:100*1024], 1),
+		mustCompressStream(t, src[:10]),
+	}
+	var cd []byte
+	plainSize := 0
+	for _, frame := range frames {
+		cd = append(cd, frame...)
+		plainData, err := Decompress(nil, frame)
+		if err != nil {
+			t.Fatalf("cannot decompress frame: %s", err)
+		}
+		plainSize += len(plainData)
+
+		bound, err := DecompressBound(cd)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if bound < uint64(plainSize) {
+			t.Fatalf("too small bound for %d frames; got %d; want at least %d", len(frames), bound, plainSize)
+		}
+	}
+
+	// The bound must be sufficient for decompressing all the frames.
+	bound, err := DecompressBound(cd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dst := make([]byte, 0, bound)
+	plainData, err := Decompress(dst, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress concatenated frames: %s", err)
+	}
+	if len(plainData) != plainSize {
+		t.Fatalf("unexpected decompressed size; got %d; want %d", len(plainData), plainSize)
+	}
+	if &plainData[0] != &dst[:1][0] {
+		t.Fatalf("dst mustn't be re-allocated when its capacity is the decompressed size bound")
+	}
+
+	// Empty, truncated and invalid src.
+	if bound, err := DecompressBound(nil); err != nil || bound != 0 {
+		t.Fatalf("unexpected result for empty src; got %d, %v; want 0, nil", bound, err)
+	}
+	if _, err := DecompressBound(cd[:len(cd)-1]); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+	}
+	if _, err := DecompressBound([]byte("invalid compressed data")); err == nil || errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for invalid src; got %v; want non-truncated error", err)
+	}
+}
+
+func TestTotalContentSize(t *testing.T) {
+	src := []byte(newTestString(300*1024, 3))
+	var cd []byte
+	plainSize := 0
+	for _, n := range []int{1000, len(src), 10, 100 * 1024} {
+		cd = append(cd, Compress(nil, src[:n])...)
+		plainSize += n
+
+		size, err := TotalContentSize(cd)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if size != uint64(plainSize) {
+			t.Fatalf("unexpected total content size; got %d; want %d", size, plainSize)
+		}
+	}
+
+	// Skippable frames have zero content size.
+	selfContained, err := CompressSelfContained(nil, src[:100], src[:1000], DefaultCompressionLevel)
+	if err != nil {
+		t.Fatalf("cannot compress self-contained data: %s", err)
+	}
+	size, err := TotalContentSize(append(selfContained, cd...))
+	if err != nil {
+		t.Fatalf("unexpected error for frames with skippable frame: %s", err)
+	}
+	if size != uint64(plainSize+100) {
+		t.Fatalf("unexpected total content size for frames with skippable frame; got %d; want %d", size, plainSize+100)
+	}
+
+	// Empty src.
+	if size, err := TotalContentSize(nil); err != nil || size != 0 {
+		t.Fatalf("unexpected result for empty src; got %d, %v; want 0, nil", size, err)
+	}
+
+	// Frames with unknown content size.
+	if _, err := TotalContentSize(append(cd, mustCompressStream(t, src)...)); err == nil {
+		t.Fatalf("expecting non-nil error for frame with unknown content size")
+	}
+
+	// Total content size overflow.
+	hugeFrame := newHugeFrame(1<<64 - 3)
+	if _, err := TotalContentSize(append(hugeFrame, hugeFrame...)); err == nil {
+		t.Fatalf("expecting non-nil error for total content size overflow")
+	}
+
+	// Truncated and invalid src.
+	if _, err := TotalContentSize(cd[:len(cd)-1]); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated src; got %v; want %v", err, ErrTruncated)
+	}
+	if _, err := TotalContentSize([]byte("invalid compressed data")); err == nil || errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for invalid src; got %v; want non-truncated error", err)
+	}
+}
+
+func TestDecompressUnsupportedFrameFeature(t *testing.T) {
+	version := Version()
+	if n := strings.Count(version, "."); n != 2 {
+		t.Fatalf("unexpected version format; got %q; want x.y.z", version)
+	}
+
+	src := []byte(newTestString(100*1024, 3))
+	cd := Compress(nil, src)
+	// Set the reserved bit in the frame header descriptor.
+	cd[4] |= 0x08
+
+	check := func(err error) {
+		t.Helper()
+		if !errors.Is(err, ErrUnsupportedFrameFeature) {
+			t.Fatalf("unexpected error; got %v; want %v", err, ErrUnsupportedFrameFeature)
+		}
+		if errors.Is(err, ErrTruncated) {
+			t.Fatalf("the error mustn't be reported as truncated: %s", err)
+		}
+		if !strings.Contains(err.Error(), version) {
+			t.Fatalf("the error must contain the linked zstd version %q; got %q", version, err)
+		}
+	}
+
+	_, err := Decompress(nil, cd)
+	check(err)
+	_, err = Decompress(make([]byte, 0, 2*len(src)), cd)
+	check(err)
+	zr := NewReader(bytes.NewReader(cd))
+	_, err = io.Copy(ioutil.Discard, zr)
+	zr.Release()
+	check(err)
+
+	// Other errors mustn't be reported as unsupported frame feature.
+	cd = Compress(nil, src)
+	if _, err := Decompress(nil, cd[:len(cd)-1]); errors.Is(err, ErrUnsupportedFrameFeature) {
+		t.Fatalf("truncated src mustn't be reported as unsupported frame feature: %s", err)
+	}
+	if _, err := Decompress(nil, []byte("invalid compressed data")); errors.Is(err, ErrUnsupportedFrameFeature) {
+		t.Fatalf("invalid src mustn't be reported as unsupported frame feature: %s", err)
+	}
+}
+
+func TestDecompressDictionaryMismatch(t *testing.T) {
+	newDicts := func(prefix string) (*CDict, *DDict) {
+		t.Helper()
+		var samples [][]byte
+		for i := 0; i < 1000; i++ {
+			samples = append(samples, []byte(fmt.Sprintf("%s sample %d", prefix, i)))
+		}
+		dict := BuildDict(samples, 8*1024)
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		dd, err := NewDDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create DDict: %s", err)
+		}
+		return cd, dd
+	}
+	cd, dd := newDicts("foo")
+	defer cd.Release()
+	defer dd.Release()
+	cdOther, ddOther := newDicts("bar")
+	defer cdOther.Release()
+	defer ddOther.Release()
+	if dd.ID() == 0 || ddOther.ID() == 0 || dd.ID() == ddOther.ID() {
+		t.Fatalf("unexpected dictionary IDs: %d, %d", dd.ID(), ddOther.ID())
+	}
+
+	src := []byte(newTestString(100*1024, 3))
+	data := CompressDict(nil, src, cd)
+	// The frame with other dictionary is preceded by a frame without
+	// dictionary, so the mismatched frame must be found in the middle of src.
+	dataOther := append(Compress(nil, src[:1000]), CompressDict(nil, src, cdOther)...)
+
+	check := func(err error, frameDictID, dictID uint32) {
+		t.Helper()
+		if !errors.Is(err, ErrDictionaryMismatch) {
+			t.Fatalf("unexpected error; got %v; want %v", err, ErrDictionaryMismatch)
+		}
+		var zerr *Error
+		if !errors.As(err, &zerr) {
+			t.Fatalf("expecting *Error; got %T: %v", err, err)
+		}
+		if zerr.FrameDictID != frameDictID {
+			t.Fatalf("unexpected FrameDictID; got %d; want %d", zerr.FrameDictID, frameDictID)
+		}
+		if zerr.DictID != dictID {
+			t.Fatalf("unexpected DictID; got %d; want %d", zerr.DictID, dictID)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("ID %d", frameDictID)) {
+			t.Fatalf("the error must contain the frame dictionary ID %d; got %q", frameDictID, err)
+		}
+	}
+
+	// No dictionary.
+	_, err := Decompress(nil, data)
+	check(err, dd.ID(), 0)
+	_, err = Decompress(make([]byte, 0, 2*len(src)), data)
+	check(err, dd.ID(), 0)
+	_, err = DecompressWith(nil, data, DecompressOpts{
+		MaxOutputSize: 2 * len(src),
+	})
+	check(err, dd.ID(), 0)
+
+	// Wrong dictionary.
+	_, err = DecompressDict(nil, dataOther, dd)
+	check(err, ddOther.ID(), dd.ID())
+	_, err = DecompressDict(make([]byte, 0, 2*len(src)), dataOther, dd)
+	check(err, ddOther.ID(), dd.ID())
+	_, err = DecompressStream(nil, dataOther, dd)
+	check(err, ddOther.ID(), dd.ID())
+
+	zr := NewReaderDict(bytes.NewReader(dataOther), dd)
+	_, err = io.Copy(ioutil.Discard, zr)
+	zr.Release()
+	check(err, ddOther.ID(), dd.ID())
+
+	d := NewDCtx()
+	defer d.Release()
+	if err := d.LoadDDict(dd); err != nil {
+		t.Fatalf("cannot load DDict: %s", err)
+	}
+	_, err = d.Decompress(nil, dataOther)
+	check(err, ddOther.ID(), dd.ID())
+	d.ResetParameters()
+	_, _, _, err = d.DecompressStream(make([]byte, 2*len(src)), data)
+	check(err, dd.ID(), 0)
+
+	// Other errors mustn't be reported as dictionary mismatch.
+	if _, err := DecompressDict(nil, data[:len(data)-1], dd); errors.Is(err, ErrDictionaryMismatch) {
+		t.Fatalf("truncated src mustn't be reported as dictionary mismatch: %s", err)
+	}
+	if _, err := Decompress(nil, []byte("invalid compressed data")); errors.Is(err, ErrDictionaryMismatch) {
+		t.Fatalf("invalid src mustn't be reported as dictionary mismatch: %s", err)
+	}
+}
+
+func TestDecompressInvalidData(t *testing.T) {
+	// Try decompressing invalid data.
+	src := []byte("invalid compressed data")
+	buf := make([]byte, len(src))
+	if _, err := Decompress(nil, src); err == nil {
+		t.Fatalf("expecting error when decompressing invalid data")
+	}
+	if _, err := Decompress(buf[:0], src); err == nil {
+		t.Fatalf("expecting error when decompressing invalid data into existing buffer")
+	}
+
+	// Try decompressing corrupted data.
+	s := newTestString(64*1024, 15)
+	cd := Compress(nil, []byte(s))
+	cd[len(cd)-1]++
+
+	if _, err := Decompress(nil, cd); err == nil {
+		t.Fatalf("expecting error when decompressing corrupted data")
+	}
+	if _, err := Decompress(buf[:0], cd); err == nil {
+		t.Fatalf("expecting error when decompressing corrupdate data into existing buffer")
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	src := []byte("foobar baz")
+
+	for compressLevel := 1; compressLevel < 22; compressLevel++ {
+		testCompressLevel(t, src, compressLevel)
+	}
+
+	// Test invalid compression levels - they should clamp
+	// to the closest valid levels.
+	testCompressLevel(t, src, -123)
+	testCompressLevel(t, src, 234324)
+	testCompressLevel(t, src, FastestCompressionLevel()-1)
+}
+
+func TestCompressBestFastest(t *testing.T) {
+	if minCompressionLevel != MinCompressionLevel {
+		t.Fatalf("unexpected MinCompressionLevel; got %d; want %d", MinCompressionLevel, minCompressionLevel)
+	}
+	if n := FastestCompressionLevel(); n != MinCompressionLevel {
+		t.Fatalf("unexpected FastestCompressionLevel; got %d; want %d", n, MinCompressionLevel)
+	}
+
+	var bb bytes.Buffer
+	for bb.Len() < 1e5 {
+		fmt.Fprintf(&bb, "line %d, size %d\n", bb.Len()%1000, bb.Len())
+	}
+	src := bb.Bytes()
+
+	best := CompressBest(nil, src)
+	fastest := CompressFastest(nil, src)
+	if len(best) > len(fastest) {
+		t.Fatalf("CompressBest output must not exceed CompressFastest output; got %d bytes vs %d bytes", len(best), len(fastest))
+	}
+	fastestLevel := CompressLevel(nil, src, FastestCompressionLevel())
+	if !bytes.Equal(fastestLevel, fastest) {
+		t.Fatalf("CompressLevel with FastestCompressionLevel must match CompressFastest output")
+	}
+	for _, cd := range [][]byte{best, fastest, fastestLevel} {
+		plainData, err := Decompress(nil, cd)
+		if err != nil {
+			t.Fatalf("cannot decompress data: %s", err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+	}
+
+	// Levels in the range [-7..-1] must still compress redundant data.
+	zeros := make([]byte, 1e5)
+	for level := -7; level <= -1; level++ {
+		if cd := CompressLevel(nil, zeros, level); len(cd) >= len(zeros)/10 {
+			t.Fatalf("too big compressed size at level %d; got %d bytes for %d zero bytes", level, len(cd), len(zeros))
+		}
+	}
+}
+
+func TestCompressToSize(t *testing.T) {
+	var bb bytes.Buffer
+	for bb.Len() < 1e5 {
+		fmt.Fprintf(&bb, "line %d, size %d\n", bb.Len()%1000, bb.Len())
+	}
+	src := bb.Bytes()
+
+	// The budget fits only the output of high compression levels.
+	maxBytes := len(CompressBest(nil, src))
+	if n := len(CompressLevel(nil, src, 1)); n <= maxBytes {
+		t.Fatalf("level 1 output must exceed the budget; got %d bytes; budget %d bytes", n, maxBytes)
+	}
+	cd, level, err := CompressToSize(src, maxBytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(cd) > maxBytes {
+		t.Fatalf("too big compressed data; got %d bytes; want up to %d bytes", len(cd), maxBytes)
+	}
+	if level != maxCompressionLevel {
+		t.Fatalf("unexpected compression level; got %d; want the highest fitting level %d", level, maxCompressionLevel)
+	}
+	plainData, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// Too small budget.
+	if _, _, err := CompressToSize(src, 10); err == nil {
+		t.Fatalf("expecting non-nil error for too small budget")
+	}
+}
+
+func TestCompressToSizeOverBudget(t *testing.T) {
+	src := bytes.Repeat([]byte("foobar baz "), 1000)
+
+	var levels []int
+	compressLevel := func(dst, src []byte, level int) []byte {
+		levels = append(levels, level)
+		return CompressLevel(dst, src, level)
+	}
+	cd, level, err := compressToSize(src, 10, compressLevel)
+	if err == nil {
+		t.Fatalf("expecting non-nil error for too small budget")
+	}
+	if cd != nil || level != 0 {
+		t.Fatalf("unexpected result for too small budget; got %d bytes at level %d", len(cd), level)
+	}
+	if len(levels) != 1 || levels[0] != maxCompressionLevel {
+		t.Fatalf("unexpected compression attempts; got levels %v; want only the maximum level %d", levels, maxCompressionLevel)
+	}
+}
+
+func TestCompressToRatio(t *testing.T) {
+	// Highly compressible data must meet strict ratio at low level.
+	src := bytes.Repeat([]byte("highly compressible data "), 4000)
+	prefix := []byte("prefix")
+	cd, level, ok := CompressToRatio(prefix, src, 0.01)
+	if !ok {
+		t.Fatalf("cannot achieve ratio 0.01 for highly compressible data")
+	}
+	if level != 1 {
+		t.Fatalf("unexpected compression level; got %d; want 1", level)
+	}
+	if !bytes.HasPrefix(cd, prefix) {
+		t.Fatalf("missing prefix in the result")
+	}
+	if n := len(cd) - len(prefix); n > len(src)/100 {
+		t.Fatalf("too big compressed data; got %d bytes; want up to %d bytes", n, len(src)/100)
+	}
+	plainData, err := Decompress(nil, cd[len(prefix):])
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// The ratio unreachable at low levels must result in higher level.
+	var bb bytes.Buffer
+	for bb.Len() < 1e5 {
+		fmt.Fprintf(&bb, "line %d, size %d\n", bb.Len()%1000, bb.Len())
+	}
+	src = bb.Bytes()
+	maxRatio := float64(len(CompressBest(nil, src))) / float64(len(src))
+	cd, level, ok = CompressToRatio(nil, src, maxRatio)
+	if !ok {
+		t.Fatalf("cannot achieve ratio %.4f reached by CompressBest", maxRatio)
+	}
+	if level <= 1 {
+		t.Fatalf("unexpected compression level; got %d; want higher than 1", level)
+	}
+	if float64(len(cd)) > maxRatio*float64(len(src)) {
+		t.Fatalf("too big compressed data; got %d bytes for ratio %.4f", len(cd), maxRatio)
+	}
+
+	// Incompressible data.
+	src = make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(src)
+	cd, level, ok = CompressToRatio(prefix, src, 0.9)
+	if ok {
+		t.Fatalf("unexpected ratio 0.9 achieved for random data at level %d", level)
+	}
+	if !bytes.Equal(cd, prefix) {
+		t.Fatalf("dst must be returned unchanged on failure; got %q; want %q", cd, prefix)
+	}
+}
+
+func TestRecompressConcat(t *testing.T) {
+	var frames [][]byte
+	var want []byte
+	for i := 0; i < 1000; i++ {
+		s := fmt.Sprintf("record %d, value %d\n", i, i%17)
+		frames = append(frames, Compress(nil, []byte(s)))
+		want = append(want, s...)
+	}
+	// Empty frame must be handled as well.
+	frames = append(frames, Compress(nil, nil))
+
+	prefix := []byte("prefix")
+	cd, err := RecompressConcat(prefix, frames, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.HasPrefix(cd, prefix) {
+		t.Fatalf("missing prefix in the result")
+	}
+	cd = cd[len(prefix):]
+
+	framesLen := 0
+	for _, frame := range frames {
+		framesLen += len(frame)
+	}
+	if len(cd) >= framesLen {
+		t.Fatalf("merged frame must be smaller than the original frames; got %d bytes; want less than %d bytes", len(cd), framesLen)
+	}
+	plainData, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress merged frame: %s", err)
+	}
+	if !bytes.Equal(plainData, want) {
+		t.Fatalf("unexpected decompressed data; got %d bytes; want %d bytes", len(plainData), len(want))
+	}
+
+	// Invalid frame must result in error.
+	frames[10] = []byte("invalid frame")
+	if _, err := RecompressConcat(nil, frames, 5); err == nil {
+		t.Fatalf("expecting non-nil error for invalid frame")
+	}
+}
+
+func TestGetFrameWindowSize(t *testing.T) {
+	// The frame with unknown content size must contain the window size
+	// set via WindowLog.
+	var bb bytes.Buffer
+	zw := NewWriterParams(&bb, &WriterParams{
+		WindowLog: 20,
+	})
+	if _, err := zw.Write([]byte(newTestString(1e5, 3))); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	zw.Release()
+	windowSize, err := GetFrameWindowSize(bb.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if windowSize != 1<<20 {
+		t.Fatalf("unexpected window size; got %d; want %d", windowSize, 1<<20)
+	}
+
+	// The window size for small single-segment frame equals to content size.
+	cd := Compress(nil, []byte("foobar"))
+	windowSize, err = GetFrameWindowSize(cd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if windowSize != 6 {
+		t.Fatalf("unexpected window size; got %d; want %d", windowSize, 6)
+	}
+
+	// Truncated header.
+	for _, n := range []int{0, 1, 4, 5} {
+		if _, err := GetFrameWindowSize(cd[:n]); err == nil {
+			t.Fatalf("expecting non-nil error for header truncated to %d bytes", n)
+		}
+	}
+
+	// Invalid header.
+	if _, err := GetFrameWindowSize([]byte("invalid frame header")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid header")
+	}
+}
+
+func TestIsZstdFrame(t *testing.T) {
+	f := func(src []byte, resultExpected bool) {
+		t.Helper()
+		if result := IsZstdFrame(src); result != resultExpected {
+			t.Fatalf("unexpected result for %q; got %v; want %v", src, result, resultExpected)
+		}
+	}
+	cd := Compress(nil, []byte("foobar"))
+	f(cd, true)
+	f(cd[:4], true)
+	f(cd[:3], false)
+	f(CompressPadded(nil, []byte("foobar"), DefaultCompressionLevel, 64)[len(cd):], true)
+	f(nil, false)
+	f([]byte("foobar"), false)
+}
+
+func TestFrameDecompressionMemory(t *testing.T) {
+	// The estimate must grow with the window log.
+	prevMemorySize := uint64(0)
+	for _, windowLog := range []int{10, 15, 20, 25, 27} {
+		var bb bytes.Buffer
+		zw := NewWriterParams(&bb, &WriterParams{
+			WindowLog: windowLog,
+		})
+		if _, err := zw.Write([]byte("foobar")); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+		zw.Release()
+		memorySize, err := FrameDecompressionMemory(bb.Bytes())
+		if err != nil {
+			t.Fatalf("unexpected error for windowLog=%d: %s", windowLog, err)
+		}
+		if memorySize < 1<<uint(windowLog) {
+			t.Fatalf("too small memory estimate for windowLog=%d; got %d bytes; want at least %d bytes", windowLog, memorySize, 1<<uint(windowLog))
+		}
+		if memorySize <= prevMemorySize {
+			t.Fatalf("the memory estimate must grow with windowLog; got %d bytes for windowLog=%d; previous estimate is %d bytes", memorySize, windowLog, prevMemorySize)
+		}
+		prevMemorySize = memorySize
+	}
+
+	// The frame header requiring 2GB window must be detected
+	// without the frame data. Such window is supported only on 64-bit platforms.
+	header := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 21 << 3}
+	if bits.UintSize == 64 {
+		memorySize, err := FrameDecompressionMemory(header)
+		if err != nil {
+			t.Fatalf("unexpected error for 2GB window: %s", err)
+		}
+		if memorySize < 2<<30 {
+			t.Fatalf("too small memory estimate for 2GB window; got %d bytes", memorySize)
+		}
+	}
+
+	// Too big window.
+	header = []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 22 << 3}
+	if _, err := FrameDecompressionMemory(header); err == nil {
+		t.Fatalf("expecting non-nil error for too big window")
+	}
+
+	// Truncated header.
+	cd := Compress(nil, []byte("foobar"))
+	for _, n := range []int{0, 1, 4, 5} {
+		if _, err := FrameDecompressionMemory(cd[:n]); err == nil {
+			t.Fatalf("expecting non-nil error for header truncated to %d bytes", n)
+		}
+	}
+
+	// Invalid header.
+	if _, err := FrameDecompressionMemory([]byte("invalid frame header")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid header")
+	}
+}
+
+func TestCompressNegativeLevel(t *testing.T) {
+	src := []byte(newTestString(1e5, 20))
+	for _, level := range []int{-1, -5, -100, FastestCompressionLevel()} {
+		testCompressLevel(t, src, level)
+	}
+
+	// Negative levels must produce valid frames distinct from level 1.
+	cdNegative := CompressLevel(nil, src, -5)
+	cdPositive := CompressLevel(nil, src, 1)
+	if bytes.Equal(cdNegative, cdPositive) {
+		t.Fatalf("level -5 must produce distinct output from level 1")
+	}
+}
+
+func testCompressLevel(t *testing.T, src []byte, compressionLevel int) {
+	t.Helper()
+
+	cd := CompressLevel(nil, src, compressionLevel)
+	dd, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("unexpected error during decompression: %s", err)
+	}
+	if string(dd) != string(src) {
+		t.Fatalf("unexpected dd\n%X; want\n%X", dd, src)
+	}
+}
+
+func TestCompressDecompress(t *testing.T) {
+	testCompressDecompress(t, "")
+	testCompressDecompress(t, "a")
+	testCompressDecompress(t, "foo bar")
+
+	for size := 1; size <= 1e6; size *= 10 {
+		s := newTestString(size, 20)
+		testCompressDecompress(t, s)
+	}
+}
+
+func testCompressDecompress(t *testing.T, s string) {
+	t.Helper()
+
+	if err := testCompressDecompressSerial(s); err != nil {
 		t.Fatalf("error in serial test: %s", err)
 	}
 
@@ -416,3 +2087,590 @@ func TestCompressDecompressMultiFrames(t *testing.T) {
 			plainData, origData, len(plainData), len(origData))
 	}
 }
+
+func TestDecompressWith(t *testing.T) {
+	src := []byte(newTestString(1e5, 3))
+	cd := Compress(nil, src)
+	prefix := []byte("prefix")
+
+	checkDecompress := func(src, want []byte, opts DecompressOpts) {
+		t.Helper()
+		plainData, err := DecompressWith(append([]byte{}, prefix...), src, opts)
+		if err != nil {
+			t.Fatalf("unexpected error for opts %+v: %s", opts, err)
+		}
+		if !bytes.Equal(plainData[:len(prefix)], prefix) {
+			t.Fatalf("unexpected prefix for opts %+v; got %q; want %q", opts, plainData[:len(prefix)], prefix)
+		}
+		if !bytes.Equal(plainData[len(prefix):], want) {
+			t.Fatalf("unexpected decompressed data for opts %+v", opts)
+		}
+	}
+	checkError := func(src []byte, opts DecompressOpts) error {
+		t.Helper()
+		plainData, err := DecompressWith(append([]byte{}, prefix...), src, opts)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for opts %+v", opts)
+		}
+		if !bytes.Equal(plainData, prefix) {
+			t.Fatalf("dst must be left unchanged on error for opts %+v; got %q; want %q", opts, plainData, prefix)
+		}
+		return err
+	}
+
+	// Default opts.
+	checkDecompress(cd, src, DecompressOpts{})
+	checkDecompress(nil, nil, DecompressOpts{})
+
+	// Output size limit.
+	checkDecompress(cd, src, DecompressOpts{MaxOutputSize: len(src)})
+	err := checkError(cd, DecompressOpts{MaxOutputSize: len(src) - 1})
+	if !errors.Is(err, ErrStreamLimitExceeded) {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrStreamLimitExceeded)
+	}
+
+	// Window log override.
+	var bb bytes.Buffer
+	zw := NewWriterParams(&bb, &WriterParams{
+		WindowLog: 20,
+	})
+	if _, err := zw.Write(src); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	zw.Release()
+	cdWindow := bb.Bytes()
+	checkDecompress(cdWindow, src, DecompressOpts{WindowLogMax: 20})
+	checkDecompress(cdWindow, src, DecompressOpts{WindowLogMax: 20, MaxOutputSize: len(src)})
+	checkError(cdWindow, DecompressOpts{WindowLogMax: 19})
+	checkError(cdWindow, DecompressOpts{WindowLogMax: 1})
+	// The window log override mustn't leak into subsequent calls.
+	checkDecompress(cdWindow, src, DecompressOpts{MaxOutputSize: len(src)})
+
+	// Multiple frames.
+	multi := append(append([]byte{}, cd...), cd...)
+	multiSrc := append(append([]byte{}, src...), src...)
+	err = checkError(multi, DecompressOpts{})
+	if errors.Is(err, ErrStreamLimitExceeded) {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkError(multi, DecompressOpts{MaxOutputSize: len(multiSrc)})
+	checkDecompress(multi, multiSrc, DecompressOpts{AllowMultipleFrames: true, MaxOutputSize: len(multiSrc)})
+	checkError(multi, DecompressOpts{AllowMultipleFrames: true, MaxOutputSize: len(multiSrc) - 1})
+	checkError(append(cd, "garbage"...), DecompressOpts{})
+
+	// Dictionary.
+	dict := []byte(newTestString(32*1024, 3))
+	cdict, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cdict.Release()
+	ddict, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer ddict.Release()
+	cdDict := CompressDict(nil, src, cdict)
+	checkDecompress(cdDict, src, DecompressOpts{DDict: ddict})
+	checkDecompress(cdDict, src, DecompressOpts{DDict: ddict, MaxOutputSize: len(src), WindowLogMax: 20})
+	checkError(cdDict, DecompressOpts{DDict: ddict, MaxOutputSize: 10})
+}
+
+func TestDecompressVerified(t *testing.T) {
+	src := []byte(newTestString(1024*1024, 3))
+	compressedData := Compress(nil, src)
+	prefix := []byte("prefix")
+
+	f := func(maxSize int, deadline time.Time, errExpected error) {
+		t.Helper()
+		dst := append([]byte{}, prefix...)
+		result, err := DecompressVerified(dst, compressedData, nil, maxSize, deadline)
+		if err != errExpected {
+			t.Fatalf("unexpected error for maxSize=%d; got %v; want %v", maxSize, err, errExpected)
+		}
+		if err != nil {
+			if string(result) != string(prefix) {
+				t.Fatalf("dst must be returned unchanged on error; got %d bytes", len(result))
+			}
+			return
+		}
+		if string(result[:len(prefix)]) != string(prefix) || !bytes.Equal(result[len(prefix):], src) {
+			t.Fatalf("unexpected decompressed data; len(got)=%d; len(want)=%d", len(result)-len(prefix), len(src))
+		}
+	}
+
+	// No limits.
+	f(0, time.Time{}, nil)
+
+	// Size limit.
+	f(len(src), time.Time{}, nil)
+	f(len(src)-1, time.Time{}, ErrStreamLimitExceeded)
+	f(1000, time.Now().Add(time.Hour), ErrStreamLimitExceeded)
+
+	// Deadline.
+	f(len(src), time.Now().Add(time.Hour), nil)
+	f(len(src), time.Now().Add(-time.Second), ErrDeadlineExceeded)
+	f(0, time.Now().Add(time.Nanosecond), ErrDeadlineExceeded)
+
+	// The deadline must be checked while decompressing the data.
+	sd := getStreamDecompressor(nil)
+	sd.src = compressedData
+	sd.deadline = time.Now().Add(time.Hour)
+	n, err := sd.zr.WriteTo(&deadlineWriter{
+		sd: sd,
+	})
+	putStreamDecompressor(sd)
+	if err != ErrDeadlineExceeded {
+		t.Fatalf("unexpected error for the deadline exceeded during decompression; got %v; want %v", err, ErrDeadlineExceeded)
+	}
+	if n == 0 || n >= int64(len(src)) {
+		t.Fatalf("the decompression must stop in the middle; decompressed %d bytes out of %d bytes", n, len(src))
+	}
+
+	// Invalid src.
+	if _, err := DecompressVerified(nil, []byte("invalid frame"), nil, len(src), time.Now().Add(time.Hour)); err == nil {
+		t.Fatalf("expecting non-nil error for invalid src")
+	}
+}
+
+// deadlineWriter moves sd deadline to the past after the first write to sd.
+type deadlineWriter struct {
+	sd *streamDecompressor
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	n, err := dw.sd.Write(p)
+	dw.sd.deadline = time.Now().Add(-time.Second)
+	return n, err
+}
+
+func TestCompressWith(t *testing.T) {
+	src := []byte(newTestString(1e5, 3))
+	prefix := []byte("prefix")
+
+	dict := []byte(newTestString(32*1024, 3))
+	cdict, err := NewCDictLevel(dict, 5)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cdict.Release()
+	ddict, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer ddict.Release()
+
+	c := NewCCtx()
+	defer c.Release()
+	compressCCtx := func(level int, params *CompressParams) []byte {
+		t.Helper()
+		c.ResetParameters()
+		if err := c.SetParameter(CParamCompressionLevel, level); err != nil {
+			t.Fatalf("cannot set compression level: %s", err)
+		}
+		if params.WindowLog != 0 {
+			if err := c.SetParameter(CParamWindowLog, params.WindowLog); err != nil {
+				t.Fatalf("cannot set window log: %s", err)
+			}
+		}
+		if params.Checksum {
+			if err := c.SetParameter(CParamChecksumFlag, 1); err != nil {
+				t.Fatalf("cannot set checksum flag: %s", err)
+			}
+		}
+		return c.Compress(append([]byte{}, prefix...), src)
+	}
+
+	f := func(opts CompressOpts, want []byte) {
+		t.Helper()
+		result, err := CompressWith(append([]byte{}, prefix...), src, opts)
+		if err != nil {
+			t.Fatalf("unexpected error for opts %+v: %s", opts, err)
+		}
+		if !bytes.Equal(result, want) {
+			t.Fatalf("unexpected result for opts %+v; got %d bytes; want %d bytes", opts, len(result), len(want))
+		}
+
+		// Compress into caller-owned buffer.
+		into := make([]byte, len(prefix), len(want)+10)
+		copy(into, prefix)
+		opts.Into = into
+		result, err = CompressWith(nil, src, opts)
+		if err != nil {
+			t.Fatalf("unexpected error for opts %+v: %s", opts, err)
+		}
+		if !bytes.Equal(result, want) {
+			t.Fatalf("unexpected result for opts %+v; got %d bytes; want %d bytes", opts, len(result), len(want))
+		}
+		if &result[0] != &into[0] {
+			t.Fatalf("the result must share the backing array with opts.Into")
+		}
+
+		// Too small buffer.
+		opts.Into = into[: len(prefix) : len(want)-1]
+		result, err = CompressWith(nil, src, opts)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for too small opts.Into")
+		}
+		if !bytes.Equal(result, prefix) {
+			t.Fatalf("opts.Into must be returned unchanged on error; got %q; want %q", result, prefix)
+		}
+
+		// dst and Into cannot be set simultaneously.
+		opts.Into = into
+		if _, err := CompressWith(prefix, src, opts); err == nil {
+			t.Fatalf("expecting non-nil error when both dst and opts.Into are set")
+		}
+
+		plainData, err := DecompressDict(nil, want[len(prefix):], ddict)
+		if err != nil {
+			t.Fatalf("cannot decompress data for opts %+v: %s", opts, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data for opts %+v", opts)
+		}
+	}
+
+	f(CompressOpts{}, Compress(prefix, src))
+	f(CompressOpts{Level: 7}, CompressLevel(prefix, src, 7))
+	f(CompressOpts{Level: -5}, CompressLevel(prefix, src, -5))
+	f(CompressOpts{Dict: cdict}, CompressDict(prefix, src, cdict))
+	f(CompressOpts{Dict: cdict, Level: 7}, CompressDict(prefix, src, cdict))
+
+	params := &CompressParams{}
+	f(CompressOpts{Params: params}, compressCCtx(0, params))
+	f(CompressOpts{Level: 7, Params: params}, compressCCtx(7, params))
+	params = &CompressParams{
+		WindowLog: 15,
+		Checksum:  true,
+	}
+	f(CompressOpts{Level: 7, Params: params}, compressCCtx(7, params))
+	f(CompressOpts{Dict: cdict, Params: params}, mustCompressWith(t, src, CompressOpts{Dict: cdict, Params: params}))
+
+	// Invalid params.
+	result, err := CompressWith(prefix, src, CompressOpts{Params: &CompressParams{WindowLog: 100}})
+	if err == nil {
+		t.Fatalf("expecting non-nil error for invalid window log")
+	}
+	if !bytes.Equal(result, prefix) {
+		t.Fatalf("dst must be returned unchanged on error; got %q; want %q", result, prefix)
+	}
+
+	// Empty src.
+	result, err = CompressWith(prefix, nil, CompressOpts{Params: params})
+	if err != nil {
+		t.Fatalf("unexpected error for empty src: %s", err)
+	}
+	if !bytes.Equal(result, prefix) {
+		t.Fatalf("unexpected result for empty src; got %q; want %q", result, prefix)
+	}
+}
+
+func TestCompressAt(t *testing.T) {
+	srcs := [][]byte{
+		[]byte(newTestString(100*1024, 3)),
+		[]byte("second record"),
+	}
+	buf := make([]byte, 64*1024)
+
+	// Write every frame after 4-byte length prefix.
+	offset := 0
+	for i, src := range srcs {
+		n, err := CompressAt(buf, offset+4, src, DefaultCompressionLevel)
+		if err != nil {
+			t.Fatalf("cannot compress record #%d: %s", i, err)
+		}
+		if want := Compress(nil, src); !bytes.Equal(buf[offset+4:offset+4+n], want) {
+			t.Fatalf("unexpected compressed data for record #%d", i)
+		}
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(n))
+		offset += 4 + n
+	}
+
+	// Decode every frame by its length prefix.
+	offset = 0
+	for i, src := range srcs {
+		n := int(binary.LittleEndian.Uint32(buf[offset:]))
+		plainData, err := Decompress(nil, buf[offset+4:offset+4+n])
+		if err != nil {
+			t.Fatalf("cannot decompress record #%d: %s", i, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data for record #%d", i)
+		}
+		offset += 4 + n
+	}
+
+	// Empty src.
+	if n, err := CompressAt(buf, offset, nil, DefaultCompressionLevel); err != nil || n != 0 {
+		t.Fatalf("unexpected result for empty src; got %d, %v; want 0, nil", n, err)
+	}
+
+	// The compressed data doesn't fit buf.
+	if _, err := CompressAt(buf, len(buf)-10, srcs[0], DefaultCompressionLevel); err == nil {
+		t.Fatalf("expecting non-nil error when the compressed data doesn't fit buf")
+	}
+
+	// Invalid offset.
+	for _, offset := range []int{-1, len(buf) + 1} {
+		if _, err := CompressAt(buf, offset, srcs[1], DefaultCompressionLevel); err == nil {
+			t.Fatalf("expecting non-nil error for offset %d", offset)
+		}
+	}
+}
+
+func TestCompressFreeCapacity(t *testing.T) {
+	// minCompressedSize must be the lower bound for the compressed size
+	// of the most compressible data.
+	for _, n := range []int{1, 10, 1000, 128 * 1024, 128*1024 + 1, 1024 * 1024} {
+		src := make([]byte, n)
+		for _, level := range []int{FastestCompressionLevel(), 1, DefaultCompressionLevel, 19} {
+			compressedData := CompressLevel(nil, src, level)
+			if minSize := minCompressedSize(n); len(compressedData) < minSize {
+				t.Fatalf("too small compressed size for %d zero bytes at level %d; got %d bytes; want at least %d bytes", n, level, len(compressedData), minSize)
+			}
+		}
+	}
+
+	// The result mustn't depend on the free capacity of dst around
+	// the compressed size.
+	randomSrc := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(randomSrc)
+	for _, src := range [][]byte{[]byte("foobar"), make([]byte, 1000), []byte(newTestString(100*1024, 3)), randomSrc} {
+		compressedDataExpected := Compress(nil, src)
+		compressedLen := len(compressedDataExpected)
+		minSize := minCompressedSize(len(src))
+		compressBound := CompressBoundCached(len(src))
+		for _, free := range []int{0, 1, minSize - 1, minSize, compressedLen - 1, compressedLen, compressedLen + 1, compressBound} {
+			dst := make([]byte, 3, 3+free)
+			copy(dst, "abc")
+			dst = Compress(dst, src)
+			if string(dst[:3]) != "abc" {
+				t.Fatalf("unexpected prefix for free capacity %d; got %q; want %q", free, dst[:3], "abc")
+			}
+			if !bytes.Equal(dst[3:], compressedDataExpected) {
+				t.Fatalf("unexpected compressed data for free capacity %d; len(got)=%d; len(want)=%d", free, len(dst)-3, len(compressedDataExpected))
+			}
+			if free == compressBound && cap(dst) != 3+free {
+				t.Fatalf("dst mustn't be re-allocated for free capacity %d, which fits the compress bound", free)
+			}
+		}
+	}
+
+	// Highly compressible data must be compressed directly into dst
+	// with the free capacity much smaller than the compress bound.
+	var bb bytes.Buffer
+	for i := 0; bb.Len() < 64*1024; i++ {
+		fmt.Fprintf(&bb, `{"level":"info","ts":%d,"msg":"request served","status":200}`+"\n", 1700000000+i)
+	}
+	src := bb.Bytes()
+	free := len(Compress(nil, src)) + 64
+	dst := Compress(make([]byte, 0, free), src)
+	if cap(dst) != free {
+		t.Fatalf("dst mustn't be re-allocated for free capacity %d, which fits the compressed data; got cap=%d", free, cap(dst))
+	}
+	if !compressedMayFit(free, src) {
+		t.Fatalf("the compression into free capacity %d must be attempted for compressible data", free)
+	}
+
+	// The doomed compression attempt must be skipped for incompressible data,
+	// which doesn't fit the free capacity by a few bytes.
+	for _, n := range []int{1024, 16 * 1024, 200 * 1024} {
+		src := randomSrc[:n]
+		compressedLen := len(Compress(nil, src))
+		if maxSize := maxRawCompressedSize(n); compressedLen > maxSize {
+			t.Fatalf("too big compressed size for %d random bytes; got %d bytes; want up to %d bytes", n, compressedLen, maxSize)
+		}
+		for _, free := range []int{n - 1, compressedLen - 1} {
+			if compressedMayFit(free, src) {
+				t.Fatalf("the compression of %d random bytes into free capacity %d mustn't be attempted; compressed size is %d bytes", n, free, compressedLen)
+			}
+		}
+	}
+}
+
+func TestCompressPadded(t *testing.T) {
+	f := func(src []byte, blockSize int) {
+		t.Helper()
+		prefix := []byte("prefix")
+		dst := CompressPadded(append([]byte{}, prefix...), src, DefaultCompressionLevel, blockSize)
+		if string(dst[:len(prefix)]) != string(prefix) {
+			t.Fatalf("unexpected prefix for blockSize=%d; got %q; want %q", blockSize, dst[:len(prefix)], prefix)
+		}
+		compressedData := dst[len(prefix):]
+		if len(compressedData)%blockSize != 0 {
+			t.Fatalf("unaligned compressed data for blockSize=%d; got %d bytes", blockSize, len(compressedData))
+		}
+		if len(src) > 0 && len(compressedData) > len(Compress(nil, src))+blockSize+skippableFrameHeaderSize {
+			t.Fatalf("too big padding for blockSize=%d; got %d bytes", blockSize, len(compressedData))
+		}
+		plainData, err := Decompress(nil, compressedData)
+		if err != nil {
+			t.Fatalf("cannot decompress data for blockSize=%d: %s", blockSize, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed data for blockSize=%d; len(got)=%d; len(want)=%d", blockSize, len(plainData), len(src))
+		}
+		plainData, err = DecompressPadded(nil, compressedData, blockSize)
+		if err != nil {
+			t.Fatalf("cannot decompress padded data for blockSize=%d: %s", blockSize, err)
+		}
+		if !bytes.Equal(plainData, src) {
+			t.Fatalf("unexpected decompressed padded data for blockSize=%d; len(got)=%d; len(want)=%d", blockSize, len(plainData), len(src))
+		}
+	}
+	for _, src := range [][]byte{nil, []byte("foobar"), []byte(newTestString(100*1024, 3))} {
+		for _, blockSize := range []int{1, 3, 8, 9, 512, 4096} {
+			f(src, blockSize)
+		}
+	}
+
+	// Padding shorter than the skippable frame header.
+	src := []byte("foobar")
+	compressedLen := len(Compress(nil, src))
+	f(src, compressedLen+1)
+	f(src, compressedLen+skippableFrameHeaderSize)
+
+	// Concatenated padded frames.
+	data := CompressPadded(nil, []byte("foo"), DefaultCompressionLevel, 64)
+	data = CompressPadded(data, []byte("bar"), DefaultCompressionLevel, 64)
+	plainData, err := DecompressPadded(nil, data, 64)
+	if err != nil {
+		t.Fatalf("cannot decompress concatenated padded frames: %s", err)
+	}
+	if string(plainData) != "foobar" {
+		t.Fatalf("unexpected data decompressed from concatenated padded frames; got %q; want %q", plainData, "foobar")
+	}
+
+	// Truncated data.
+	if _, err := DecompressPadded(nil, data[:len(data)-1], 64); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("unexpected error for truncated data; got %v; want %v", err, ErrTruncated)
+	}
+}
+
+func mustCompressWith(t *testing.T, src []byte, opts CompressOpts) []byte {
+	t.Helper()
+	result, err := CompressWith([]byte("prefix"), src, opts)
+	if err != nil {
+		t.Fatalf("unexpected error for opts %+v: %s", opts, err)
+	}
+	return result
+}
+
+func TestEstimateCompressedSize(t *testing.T) {
+	f := func(name string, src []byte, maxFactor float64) {
+		t.Helper()
+		n := EstimateCompressedSize(src, DefaultCompressionLevel)
+		realSize := len(CompressLevel(nil, src, DefaultCompressionLevel))
+		if len(src) <= 64*1024 && n != realSize {
+			t.Fatalf("%s: unexpected estimate for small src; got %d; want %d", name, n, realSize)
+		}
+		if float64(n) > float64(realSize)*maxFactor || float64(n) < float64(realSize)/maxFactor {
+			t.Fatalf("%s: too inaccurate estimate; got %d; real size %d; want within factor %.1f", name, n, realSize, maxFactor)
+		}
+		if n > CompressBoundCached(len(src)) {
+			t.Fatalf("%s: the estimate %d exceeds CompressBoundCached=%d", name, n, CompressBoundCached(len(src)))
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 3*1024*1024)
+	r.Read(random)
+	var lines []byte
+	for i := 0; len(lines) < 5*1024*1024; i++ {
+		lines = append(lines, fmt.Sprintf("%d: GET /api/v1/query?id=%d HTTP/1.1 %d %d\n", i, r.Intn(1000), 200+r.Intn(3)*100, r.Intn(10000))...)
+	}
+
+	f("empty", nil, 1)
+	f("small", []byte(newTestString(1000, 3)), 1)
+	f("64KB", []byte(newTestString(64*1024, 3)), 1)
+	f("text", []byte(newTestString(10*1024*1024, 3)), 1.5)
+	f("lines", lines, 1.5)
+	f("random", random, 1.1)
+
+	// Highly compressible data must get small estimate.
+	zeros := make([]byte, 10*1024*1024)
+	if n := EstimateCompressedSize(zeros, DefaultCompressionLevel); n > len(zeros)/100 {
+		t.Fatalf("too big estimate for zeros; got %d; want up to %d", n, len(zeros)/100)
+	}
+}
+
+func TestCompressBoundCached(t *testing.T) {
+	f := func(srcSize int) {
+		t.Helper()
+		bound := CompressBoundCached(srcSize)
+		boundExpected := compressBoundC(srcSize)
+		if bound != boundExpected {
+			t.Fatalf("unexpected bound for srcSize=%d; got %d; want %d", srcSize, bound, boundExpected)
+		}
+	}
+	for srcSize := 0; srcSize < 200*1024; srcSize++ {
+		f(srcSize)
+	}
+	for _, srcSize := range []int{1 << 20, 1<<20 + 1, 123456789, 1 << 30, 1<<30 + 12345} {
+		f(srcSize)
+	}
+
+	if n := CompressBoundCached(-1); n != 0 {
+		t.Fatalf("unexpected bound for negative srcSize; got %d; want 0", n)
+	}
+	if n := CompressBoundCached(maxInt); n != 0 {
+		t.Fatalf("unexpected bound for srcSize=%d; got %d; want 0", maxInt, n)
+	}
+}
+
+func TestUseAfterFree(t *testing.T) {
+	src := []byte(newTestString(1000, 3))
+	cd := Compress(nil, src)
+	dst := make([]byte, 0, 10000)
+
+	expectPanic := func(name, substr string, f func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			if r == nil {
+				t.Fatalf("expecting panic in %s", name)
+			}
+			if s := fmt.Sprint(r); !strings.Contains(s, substr) {
+				t.Fatalf("unexpected panic message in %s; got %q; want it containing %q", name, s, substr)
+			}
+		}()
+		f()
+	}
+
+	expectPanic("compressInternal", "compression context is used after being freed", func() {
+		compressInternal(&cctxWrapper{}, nil, dst, src, nil, 3, false)
+	})
+	expectPanic("decompressInternal", "decompression context is used after being freed", func() {
+		decompressInternal(&dctxWrapper{}, nil, dst, cd, nil)
+	})
+
+	dict := []byte(newTestString(1000, 3))
+	cdict, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	ddict, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	expectPanic("compressInternal with dict", "compression context is used after being freed", func() {
+		compressInternal(nil, &cctxWrapper{}, dst, src, cdict, 3, false)
+	})
+	expectPanic("decompressInternal with dict", "decompression context is used after being freed", func() {
+		decompressInternal(nil, &dctxWrapper{}, dst, cd, ddict)
+	})
+
+	cdict.Release()
+	ddict.Release()
+	expectPanic("CompressDict", "CDict is used after Release", func() {
+		CompressDict(nil, src, cdict)
+	})
+	expectPanic("DecompressDict", "DDict is used after Release", func() {
+		DecompressDict(nil, cd, ddict)
+	})
+}
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
@@ -158,6 +161,118 @@ func benchmarkCompress(b *testing.B, blockSize, level int) {
 	})
 }
 
+func BenchmarkCompressSmallFreeCapacity(b *testing.B) {
+	// dst has a few free bytes, which cannot fit the compressed data.
+	for _, free := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("free_%d", free), func(b *testing.B) {
+			src := newBenchString(1000)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				dst := make([]byte, 0, free)
+				for pb.Next() {
+					n += len(Compress(dst, src))
+				}
+				atomic.AddUint64(&Sink, uint64(n))
+			})
+		})
+	}
+}
+
+func BenchmarkCompressBarelyInsufficientCapacity(b *testing.B) {
+	// dst is a byte short of the compressed size of incompressible src,
+	// so the attempt to compress directly into dst is doomed.
+	for _, size := range []int{1024, 16 * 1024} {
+		b.Run(fmt.Sprintf("size_%d", size), func(b *testing.B) {
+			src := make([]byte, size)
+			rand.New(rand.NewSource(1)).Read(src)
+			free := len(Compress(nil, src)) - 1
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				dst := make([]byte, 0, free)
+				for pb.Next() {
+					n += len(Compress(dst, src))
+				}
+				atomic.AddUint64(&Sink, uint64(n))
+			})
+		})
+	}
+}
+
+func BenchmarkCompressPreSizedCompressible(b *testing.B) {
+	// dst is pre-sized for highly compressible data, so the compression
+	// must go directly into dst without memory allocations.
+	src := newBenchLogLines(64 * 1024)
+	dstSize := len(Compress(nil, src)) + 64
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		dst := make([]byte, 0, dstSize)
+		for pb.Next() {
+			n += len(Compress(dst, src))
+		}
+		atomic.AddUint64(&Sink, uint64(n))
+	})
+}
+
+func newBenchLogLines(size int) []byte {
+	var bb bytes.Buffer
+	for i := 0; bb.Len() < size; i++ {
+		fmt.Fprintf(&bb, `{"level":"info","ts":%d,"msg":"request served","path":"/api/v1/items","status":200}`+"\n", 1700000000+i)
+	}
+	return bb.Bytes()
+}
+
+func BenchmarkCompressNegativeLevel(b *testing.B) {
+	for _, blockSize := range []int{1e4, 1e5, 3e5} {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range []int{-5, 1, 3} {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkCompress(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkCompressRandomLiteralCompressionMode(b *testing.B) {
+	src := make([]byte, 1e5)
+	rand.New(rand.NewSource(1)).Read(src)
+	for _, mode := range []int{LiteralCompressionAuto, LiteralCompressionDisable} {
+		b.Run(fmt.Sprintf("mode_%d", mode), func(b *testing.B) {
+			opts := CompressOpts{
+				Level: 3,
+				Params: &CompressParams{
+					Checksum:               true,
+					LiteralCompressionMode: mode,
+				},
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				var dst []byte
+				var err error
+				for pb.Next() {
+					dst, err = CompressWith(dst[:0], src, opts)
+					if err != nil {
+						panic(fmt.Errorf("BUG: cannot compress data: %s", err))
+					}
+					n += len(dst)
+				}
+				atomic.AddUint64(&Sink, uint64(n))
+			})
+		})
+	}
+}
+
 func BenchmarkDecompress(b *testing.B) {
 	for _, blockSize := range benchBlockSizes {
 		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
@@ -191,3 +306,282 @@ func benchmarkDecompress(b *testing.B, blockSize, level int) {
 		atomic.AddUint64(&Sink, uint64(n))
 	})
 }
+
+// Serial benchmarks below complement the parallel benchmarks above.
+// They measure the pooled contexts without contention, so the difference
+// between serial and parallel results shows the pool contention overhead.
+
+var benchSerialBlockSizes = []int{64, 4 * 1024, 1024 * 1024}
+
+func BenchmarkCompressSerial(b *testing.B) {
+	for _, blockSize := range benchSerialBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range benchCompressionLevels {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkCompressSerial(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkCompressSerial(b *testing.B, blockSize, level int) {
+	src := newBenchString(blockSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	n := 0
+	var dst []byte
+	for i := 0; i < b.N; i++ {
+		dst = CompressLevel(dst[:0], src, level)
+		n += len(dst)
+	}
+	atomic.AddUint64(&Sink, uint64(n))
+}
+
+func BenchmarkDecompressSerial(b *testing.B) {
+	for _, blockSize := range benchSerialBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range benchCompressionLevels {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkDecompressSerial(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkDecompressSerial(b *testing.B, blockSize, level int) {
+	block := newBenchString(blockSize)
+	src := CompressLevel(nil, block, level)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	b.ResetTimer()
+	n := 0
+	var dst []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		dst, err = Decompress(dst[:0], src)
+		if err != nil {
+			panic(fmt.Errorf("unexpected error: %s", err))
+		}
+		n += len(dst)
+	}
+	atomic.AddUint64(&Sink, uint64(n))
+}
+
+func BenchmarkCompressDictSerial(b *testing.B) {
+	for _, blockSize := range benchSerialBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range benchCompressionLevels {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkCompressDictSerial(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkCompressDictSerial(b *testing.B, blockSize, level int) {
+	src := newBenchString(blockSize)
+	bd := getBenchDicts(level)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	n := 0
+	var dst []byte
+	for i := 0; i < b.N; i++ {
+		dst = CompressDict(dst[:0], src, bd.cd)
+		n += len(dst)
+	}
+	atomic.AddUint64(&Sink, uint64(n))
+}
+
+func BenchmarkDecompressDictSerial(b *testing.B) {
+	for _, blockSize := range benchSerialBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range benchCompressionLevels {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkDecompressDictSerial(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkDecompressDictSerial(b *testing.B, blockSize, level int) {
+	block := newBenchString(blockSize)
+	bd := getBenchDicts(level)
+	src := CompressDict(nil, block, bd.cd)
+	b.ReportAllocs()
+	b.SetBytes(int64(blockSize))
+	b.ResetTimer()
+	n := 0
+	var dst []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		dst, err = DecompressDict(dst[:0], src, bd.dd)
+		if err != nil {
+			panic(fmt.Errorf("BUG: cannot decompress with dict: %s", err))
+		}
+		n += len(dst)
+	}
+	atomic.AddUint64(&Sink, uint64(n))
+}
+
+func BenchmarkCompressBound(b *testing.B) {
+	b.Run("go", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			n := 0
+			for pb.Next() {
+				n += CompressBoundCached(4096)
+			}
+			atomic.AddUint64(&Sink, uint64(n))
+		})
+	})
+	b.Run("cgo", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			n := 0
+			for pb.Next() {
+				n += compressBoundC(4096)
+			}
+			atomic.AddUint64(&Sink, uint64(n))
+		})
+	})
+}
+
+func BenchmarkCCtxCompressReuse(b *testing.B) {
+	for _, blockSize := range benchBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			src := newBenchString(blockSize)
+			b.Run("Compress", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(src)))
+				b.RunParallel(func(pb *testing.PB) {
+					n := 0
+					var dst []byte
+					for pb.Next() {
+						dst = Compress(dst[:0], src)
+						n += len(dst)
+					}
+					atomic.AddUint64(&Sink, uint64(n))
+				})
+			})
+			b.Run("CompressReuse", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(src)))
+				b.RunParallel(func(pb *testing.PB) {
+					c := NewCCtx()
+					defer c.Release()
+					if err := c.SetParameter(CParamCompressionLevel, DefaultCompressionLevel); err != nil {
+						panic(fmt.Errorf("BUG: cannot set compression level: %s", err))
+					}
+					n := 0
+					var dst []byte
+					for pb.Next() {
+						dst = c.CompressReuse(dst[:0], src)
+						n += len(dst)
+					}
+					atomic.AddUint64(&Sink, uint64(n))
+				})
+			})
+		})
+	}
+}
+
+func BenchmarkDCtxLoadDDict(b *testing.B) {
+	for _, blockSize := range []int{1e1, 1e2, 1e3} {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			block := newBenchString(blockSize)
+			bd := getBenchDicts(DefaultCompressionLevel)
+			src := CompressDict(nil, block, bd.cd)
+			b.Run("DecompressDict", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(blockSize))
+				n := 0
+				var dst []byte
+				var err error
+				for i := 0; i < b.N; i++ {
+					dst, err = DecompressDict(dst[:0], src, bd.dd)
+					if err != nil {
+						panic(fmt.Errorf("BUG: cannot decompress with dict: %s", err))
+					}
+					n += len(dst)
+				}
+				atomic.AddUint64(&Sink, uint64(n))
+			})
+			b.Run("LoadDDict", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(blockSize))
+				d := NewDCtx()
+				defer d.Release()
+				if err := d.LoadDDict(bd.dd); err != nil {
+					panic(fmt.Errorf("BUG: cannot load DDict: %s", err))
+				}
+				n := 0
+				var dst []byte
+				var err error
+				for i := 0; i < b.N; i++ {
+					dst, err = d.Decompress(dst[:0], src)
+					if err != nil {
+						panic(fmt.Errorf("BUG: cannot decompress with loaded DDict: %s", err))
+					}
+					n += len(dst)
+				}
+				atomic.AddUint64(&Sink, uint64(n))
+			})
+		})
+	}
+}
+
+func BenchmarkStickyDecompressor(b *testing.B) {
+	// Decompress 100k small frames compressed with the same dictionary.
+	const framesCount = 100000
+	bd := getBenchDicts(DefaultCompressionLevel)
+	frames := make([][]byte, framesCount)
+	plainSize := 0
+	for i := range frames {
+		block := newBenchString(100 + i%100)
+		frames[i] = CompressDict(nil, block, bd.cd)
+		plainSize += len(block)
+	}
+	b.Run("DecompressDict", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(plainSize))
+		n := 0
+		var dst []byte
+		var err error
+		for i := 0; i < b.N; i++ {
+			for _, src := range frames {
+				dst, err = DecompressDict(dst[:0], src, bd.dd)
+				if err != nil {
+					panic(fmt.Errorf("BUG: cannot decompress with dict: %s", err))
+				}
+				n += len(dst)
+			}
+		}
+		atomic.AddUint64(&Sink, uint64(n))
+	})
+	b.Run("StickyDecompressor", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(plainSize))
+		sd := NewStickyDecompressor()
+		defer sd.Release()
+		sd.LoadDict(bd.dd)
+		n := 0
+		var dst []byte
+		var err error
+		for i := 0; i < b.N; i++ {
+			for _, src := range frames {
+				dst, err = sd.Decompress(dst[:0], src)
+				if err != nil {
+					panic(fmt.Errorf("BUG: cannot decompress with loaded dict: %s", err))
+				}
+				n += len(dst)
+			}
+		}
+		atomic.AddUint64(&Sink, uint64(n))
+	})
+}
@@ -0,0 +1,119 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// httpContentEncoding is the value of Content-Encoding header
+// for zstd-compressed HTTP bodies.
+const httpContentEncoding = "zstd"
+
+// NewCompressingRoundTripper returns http.RoundTripper, which compresses
+// request bodies with the given compressionLevel and sends them via base.
+//
+// Compressed requests have Content-Encoding: zstd header, while their
+// Content-Length is unknown, since the body is compressed on the fly
+// while it is sent. Requests without body and requests with already set
+// Content-Encoding header are sent as is. http.DefaultTransport is used
+// if base is nil.
+//
+// The server must decode the compressed bodies.
+func NewCompressingRoundTripper(base http.RoundTripper, compressionLevel int) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &compressingRoundTripper{
+		base:             base,
+		compressionLevel: compressionLevel,
+	}
+}
+
+type compressingRoundTripper struct {
+	base             http.RoundTripper
+	compressionLevel int
+}
+
+func (crt *compressingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return crt.base.RoundTrip(req)
+	}
+
+	// RoundTripper mustn't modify the original request.
+	r := req.Clone(req.Context())
+	r.Header.Set("Content-Encoding", httpContentEncoding)
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	r.Body = crt.compressBody(req.Body)
+	if req.GetBody != nil {
+		r.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return crt.compressBody(body), nil
+		}
+	}
+	return crt.base.RoundTrip(r)
+}
+
+// compressBody returns compressed body.
+//
+// The body is compressed in a separate goroutine, which stops
+// when the returned body is read to the end or closed.
+func (crt *compressingRoundTripper) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		err := StreamCompressLevel(pw, body, crt.compressionLevel)
+		_ = body.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// DecompressingHandler returns http.Handler, which decompresses request
+// bodies with Content-Encoding: zstd header before passing them to next.
+//
+// The decompressed body is passed to next without Content-Encoding
+// and Content-Length headers. Decompression errors are returned
+// from the body Read calls. Other requests are passed to next as is.
+//
+// The decompressed body size isn't limited, so wrap the body into
+// http.MaxBytesReader in next when reading requests from untrusted clients.
+func DecompressingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), httpContentEncoding) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		zr := NewReader(r.Body)
+		defer zr.Release()
+		req := r.Clone(r.Context())
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		req.Body = &decompressingBody{
+			zr:   zr,
+			body: r.Body,
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+type decompressingBody struct {
+	zr   *Reader
+	body io.ReadCloser
+}
+
+func (db *decompressingBody) Read(p []byte) (int, error) {
+	return db.zr.Read(p)
+}
+
+func (db *decompressingBody) Close() error {
+	return db.body.Close()
+}
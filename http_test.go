@@ -0,0 +1,118 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressingRoundTripperDecompressingHandler(t *testing.T) {
+	// echoHandler responds with the request body and Content-Encoding header.
+	echoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot read request body: %s", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+		w.Write(body)
+	})
+	var wireEncoding string
+	var wireSize int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wireEncoding = r.Header.Get("Content-Encoding")
+		cr := &countingReader{r: r.Body}
+		r.Body = ioutil.NopCloser(cr)
+		DecompressingHandler(echoHandler).ServeHTTP(w, r)
+		wireSize = int64(cr.n)
+	}))
+	defer ts.Close()
+
+	c := &http.Client{
+		Transport: NewCompressingRoundTripper(nil, 5),
+	}
+	post := func(body []byte, contentEncoding string) (string, []byte) {
+		t.Helper()
+		req, err := http.NewRequest("POST", ts.URL, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("cannot create request: %s", err)
+		}
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("cannot send request: %s", err)
+		}
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("cannot read response body: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code; got %d; want %d; response body: %q", resp.StatusCode, http.StatusOK, respBody)
+		}
+		if req.Header.Get("Content-Encoding") != contentEncoding {
+			t.Fatalf("the original request mustn't be modified")
+		}
+		return resp.Header.Get("X-Content-Encoding"), respBody
+	}
+
+	// The request body must be compressed on the wire and decompressed
+	// before passing it to the handler.
+	body := []byte(newTestString(1024*1024, 3))
+	contentEncoding, respBody := post(body, "")
+	if !bytes.Equal(respBody, body) {
+		t.Fatalf("unexpected response body")
+	}
+	if contentEncoding != "" {
+		t.Fatalf("Content-Encoding must be removed before passing the request to the handler; got %q", contentEncoding)
+	}
+	if wireEncoding != "zstd" {
+		t.Fatalf("unexpected Content-Encoding on the wire; got %q; want %q", wireEncoding, "zstd")
+	}
+	if wireSize >= int64(len(body)) {
+		t.Fatalf("the request body must be compressed on the wire; got %d bytes; want less than %d bytes", wireSize, len(body))
+	}
+
+	// Requests with already set Content-Encoding must be sent as is.
+	contentEncoding, respBody = post([]byte("foobar"), "identity")
+	if string(respBody) != "foobar" {
+		t.Fatalf("unexpected response body; got %q; want %q", respBody, "foobar")
+	}
+	if contentEncoding != "identity" || wireEncoding != "identity" {
+		t.Fatalf("unexpected Content-Encoding; got %q, %q; want %q", contentEncoding, wireEncoding, "identity")
+	}
+
+	// Requests without Content-Encoding must be passed to the handler as is.
+	resp, err := http.Post(ts.URL, "text/plain", strings.NewReader("invalid compressed data"))
+	if err != nil {
+		t.Fatalf("cannot send request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code for non-compressed body; got %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Invalid compressed body must result in read error in the handler.
+	req, err := http.NewRequest("POST", ts.URL, strings.NewReader("invalid compressed data"))
+	if err != nil {
+		t.Fatalf("cannot create request: %s", err)
+	}
+	req.Header.Set("Content-Encoding", "zstd")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot send request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code for invalid compressed body; got %d; want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
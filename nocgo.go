@@ -0,0 +1,251 @@
+//go:build !cgo
+// +build !cgo
+
+package gozstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valyala/gozstd/internal/compress/zstd"
+)
+
+// This file contains pure Go fallback for decompression functions,
+// which is used when CGO is disabled.
+//
+// Only Decompress, DecompressDict, DDict and IsLikelyCompressible
+// are available in this mode. Compression functions are stubs, which
+// return ErrCGODisabled or panic with it if they cannot return errors.
+// Streaming and dictionary building require CGO.
+
+var decoder = mustNewDecoder()
+
+func mustNewDecoder(opts ...zstd.DOption) *zstd.Decoder {
+	opts = append(opts, zstd.WithDecoderConcurrency(0))
+	d, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		panic(fmt.Errorf("BUG: cannot create zstd decoder: %s", err))
+	}
+	return d
+}
+
+// Decompress appends decompressed src to dst and returns the result.
+//
+// This is pure Go implementation, which is used when CGO is disabled.
+func Decompress(dst, src []byte) ([]byte, error) {
+	return DecompressDict(dst, src, nil)
+}
+
+// DecompressDict appends decompressed src to dst and returns the result.
+//
+// The given dictionary dd is used for the decompression.
+//
+// This is pure Go implementation, which is used when CGO is disabled.
+func DecompressDict(dst, src []byte, dd *DDict) ([]byte, error) {
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	d := decoder
+	if dd != nil {
+		d = dd.d
+	}
+	dstLen := len(dst)
+	dst, err := d.DecodeAll(src, dst)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = ErrTruncated
+		}
+		if errors.Is(err, zstd.ErrUnknownDictionary) {
+			err = newDictMismatchError(src, dd)
+		}
+		return dst[:dstLen], fmt.Errorf("decompression error: %w", err)
+	}
+	return dst, nil
+}
+
+func newDictMismatchError(src []byte, dd *DDict) error {
+	frameDict := "unknown dictionary"
+	var h zstd.Header
+	if err := h.Decode(src); err == nil && h.DictionaryID != 0 {
+		frameDict = fmt.Sprintf("dictionary with ID %d", h.DictionaryID)
+	}
+	dict := "no dictionary is used"
+	if dd != nil {
+		dict = fmt.Sprintf("dictionary with ID %d is used", dd.ID())
+	}
+	return fmt.Errorf("%w; the frame requires %s, while %s", ErrDictionaryMismatch, frameDict, dict)
+}
+
+// DDict is a dictionary used for decompression.
+//
+// A single DDict may be re-used in concurrently running goroutines.
+type DDict struct {
+	d  *zstd.Decoder
+	id uint32
+}
+
+// dictMagic is the magic number at the start of dictionaries
+// in zstd dictionary format.
+const dictMagic = 0xEC30A437
+
+// NewDDict creates new DDict from the given dict.
+//
+// Call Release when the returned dict is no longer needed.
+func NewDDict(dict []byte) (*DDict, error) {
+	if len(dict) == 0 {
+		return nil, fmt.Errorf("dict cannot be empty")
+	}
+
+	var opt zstd.DOption
+	var id uint32
+	if len(dict) >= 8 && binary.LittleEndian.Uint32(dict) == dictMagic {
+		opt = zstd.WithDecoderDicts(dict)
+		id = binary.LittleEndian.Uint32(dict[4:])
+	} else {
+		// Raw content dictionary.
+		opt = zstd.WithDecoderDictRaw(0, append([]byte{}, dict...))
+	}
+	d, err := zstd.NewReader(nil, opt, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load dict: %w", err)
+	}
+	dd := &DDict{
+		d:  d,
+		id: id,
+	}
+	return dd, nil
+}
+
+// Matches returns true if the frame at the start of src may be decompressed
+// with dd.
+//
+// It compares the dictionary ID stored in the frame header against
+// the dd ID, so it is much cheaper than a failed decompression attempt.
+// false is returned if dd has non-zero ID, while the frame has no dictionary ID.
+func (dd *DDict) Matches(src []byte) bool {
+	var h zstd.Header
+	if err := h.Decode(src); err != nil {
+		return false
+	}
+	return h.DictionaryID == dd.ID()
+}
+
+// ID returns the dictionary ID of dd.
+//
+// 0 is returned for raw content dictionaries.
+func (dd *DDict) ID() uint32 {
+	return dd.id
+}
+
+// Release releases resources occupied by dd.
+//
+// dd cannot be used after the release.
+func (dd *DDict) Release() {
+	if dd.d == nil {
+		return
+	}
+	dd.d.Close()
+	dd.d = nil
+}
+
+// ErrCGODisabled is returned from compression functions when CGO is disabled.
+//
+// Functions without error result panic with it.
+var ErrCGODisabled = errors.New("gozstd: compression isn't supported when CGO is disabled; build with CGO_ENABLED=1")
+
+// DefaultCompressionLevel is the default compression level.
+const DefaultCompressionLevel = 3 // Obtained from ZSTD_CLEVEL_DEFAULT.
+
+// Compress panics with ErrCGODisabled, since compression requires CGO.
+func Compress(dst, src []byte) []byte {
+	panic(ErrCGODisabled)
+}
+
+// CompressLevel panics with ErrCGODisabled, since compression requires CGO.
+func CompressLevel(dst, src []byte, compressionLevel int) []byte {
+	panic(ErrCGODisabled)
+}
+
+// CompressDict panics with ErrCGODisabled, since compression requires CGO.
+func CompressDict(dst, src []byte, cd *CDict) []byte {
+	panic(ErrCGODisabled)
+}
+
+// StreamCompress returns ErrCGODisabled, since compression requires CGO.
+func StreamCompress(dst io.Writer, src io.Reader) error {
+	return ErrCGODisabled
+}
+
+// StreamCompressLevel returns ErrCGODisabled, since compression requires CGO.
+func StreamCompressLevel(dst io.Writer, src io.Reader, compressionLevel int) error {
+	return ErrCGODisabled
+}
+
+// StreamCompressDict returns ErrCGODisabled, since compression requires CGO.
+func StreamCompressDict(dst io.Writer, src io.Reader, cd *CDict) error {
+	return ErrCGODisabled
+}
+
+// CDict is a dictionary used for compression.
+//
+// It cannot be created when CGO is disabled.
+type CDict struct{}
+
+// NewCDict returns ErrCGODisabled, since compression requires CGO.
+func NewCDict(dict []byte) (*CDict, error) {
+	return nil, ErrCGODisabled
+}
+
+// NewCDictLevel returns ErrCGODisabled, since compression requires CGO.
+func NewCDictLevel(dict []byte, compressionLevel int) (*CDict, error) {
+	return nil, ErrCGODisabled
+}
+
+// ID returns the dictionary ID of cd.
+func (cd *CDict) ID() uint32 {
+	return 0
+}
+
+// Release releases resources occupied by cd.
+func (cd *CDict) Release() {}
+
+// Writer implements zstd writer.
+//
+// It cannot be created when CGO is disabled.
+type Writer struct{}
+
+// NewWriter panics with ErrCGODisabled, since compression requires CGO.
+func NewWriter(w io.Writer) *Writer {
+	panic(ErrCGODisabled)
+}
+
+// NewWriterLevel panics with ErrCGODisabled, since compression requires CGO.
+func NewWriterLevel(w io.Writer, compressionLevel int) *Writer {
+	panic(ErrCGODisabled)
+}
+
+// NewWriterDict panics with ErrCGODisabled, since compression requires CGO.
+func NewWriterDict(w io.Writer, cd *CDict) *Writer {
+	panic(ErrCGODisabled)
+}
+
+// Write returns ErrCGODisabled, since compression requires CGO.
+func (zw *Writer) Write(p []byte) (int, error) {
+	return 0, ErrCGODisabled
+}
+
+// Flush returns ErrCGODisabled, since compression requires CGO.
+func (zw *Writer) Flush() error {
+	return ErrCGODisabled
+}
+
+// Close returns ErrCGODisabled, since compression requires CGO.
+func (zw *Writer) Close() error {
+	return ErrCGODisabled
+}
+
+// Release releases resources occupied by zw.
+func (zw *Writer) Release() {}
@@ -0,0 +1,35 @@
+//go:build !cgo
+// +build !cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompressionStubs(t *testing.T) {
+	expectPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			err, ok := r.(error)
+			if !ok || !errors.Is(err, ErrCGODisabled) {
+				t.Fatalf("unexpected panic in %s; got %v; want %v", name, r, ErrCGODisabled)
+			}
+		}()
+		f()
+	}
+	expectPanic("Compress", func() { Compress(nil, []byte("foo")) })
+	expectPanic("CompressLevel", func() { CompressLevel(nil, []byte("foo"), DefaultCompressionLevel) })
+	expectPanic("NewWriter", func() { NewWriter(&bytes.Buffer{}) })
+
+	if err := StreamCompress(&bytes.Buffer{}, bytes.NewReader([]byte("foo"))); !errors.Is(err, ErrCGODisabled) {
+		t.Fatalf("unexpected error from StreamCompress; got %v; want %v", err, ErrCGODisabled)
+	}
+	if _, err := NewCDict([]byte("foo")); !errors.Is(err, ErrCGODisabled) {
+		t.Fatalf("unexpected error from NewCDict; got %v; want %v", err, ErrCGODisabled)
+	}
+}
@@ -0,0 +1,78 @@
+package gozstd
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// The following fixtures are compressed via CGO. They are decompressed both
+// with CGO and with the pure Go fallback used when CGO is disabled.
+const (
+	fallbackPlainData      = "gozstd pure Go fallback decompresses data compressed via CGO. gozstd pure Go fallback decompresses data compressed via CGO."
+	fallbackCompressedData = "28B52FFD207B0502005403676F7A737464207075726520476F2066616C6C6261636B206465636F6D7072657373657320646174612064207669612043474F2E2002000A82F49A629601"
+
+	fallbackDictPlainData      = "sample 12345 for fallback"
+	fallbackDictCompressedData = "28B52FFD233C53D915196D0000203132333402FC0A1EC799BA1D"
+	fallbackDict               = "37A430EC3C53D9151D10D80A0AE90CC3300CC340B8796966C0697BCBBD09F961A1ACB5D65A1B6304004C001D5A1D000000040000008034C336000020A9A70000000020000000000000000000000000000000C4D00BAC010000000000000000000000000000010000000400000008000000722066616C6C6261636B73616D706C652037313520666F722066616C6C6261636B73616D706C652037313620666F72206661652035393820666F722066616C6C6261636B73616D706C652035393920666F722066616C6C6261636B73616D706C65203630722066616C6C6261636B73616D706C652034333320666F722066616C6C6261636B73616D706C6520343334"
+)
+
+func TestDecompressFallbackFixture(t *testing.T) {
+	unhex := func(s string) []byte {
+		t.Helper()
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatalf("cannot unhex %q: %s", s, err)
+		}
+		return b
+	}
+
+	prefix := []byte("prefix")
+	plainData, err := Decompress(prefix, unhex(fallbackCompressedData))
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if string(plainData) != string(prefix)+fallbackPlainData {
+		t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, string(prefix)+fallbackPlainData)
+	}
+
+	dd, err := NewDDict(unhex(fallbackDict))
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	dictCompressedData := unhex(fallbackDictCompressedData)
+	if !dd.Matches(dictCompressedData) {
+		t.Fatalf("DDict must match the frame compressed with the same dict")
+	}
+	if dd.Matches(unhex(fallbackCompressedData)) {
+		t.Fatalf("DDict mustn't match the frame compressed without dict")
+	}
+	plainData, err = DecompressDict(nil, dictCompressedData, dd)
+	if err != nil {
+		t.Fatalf("cannot decompress data with dict: %s", err)
+	}
+	if string(plainData) != fallbackDictPlainData {
+		t.Fatalf("unexpected data decompressed with dict; got %q; want %q", plainData, fallbackDictPlainData)
+	}
+	if _, err := Decompress(nil, dictCompressedData); !errors.Is(err, ErrDictionaryMismatch) {
+		t.Fatalf("unexpected error when decompressing without dict; got %v; want %v", err, ErrDictionaryMismatch)
+	}
+
+	if _, err := Decompress(nil, []byte("invalid compressed data")); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing invalid data")
+	}
+}
+
+func TestDecompressFallbackFixtureTruncated(t *testing.T) {
+	cd, err := hex.DecodeString(fallbackCompressedData)
+	if err != nil {
+		t.Fatalf("cannot unhex compressed data: %s", err)
+	}
+	for _, n := range []int{1, 4, 5, 10, len(cd) / 2, len(cd) - 1} {
+		if _, err := Decompress(nil, cd[:n]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("unexpected error for frame truncated to %d bytes; got %v; want %v", n, err, ErrTruncated)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+//
+// static size_t ZSTD_compress_usingPrefix_wrapper(void *cctx, void *dst, size_t dstCapacity, void *src, size_t srcSize, void *prefix, size_t prefixSize, int compressionLevel) {
+//     ZSTD_CCtx *c = (ZSTD_CCtx*)cctx;
+//     size_t rv = ZSTD_CCtx_reset(c, ZSTD_reset_session_and_parameters);
+//     if (ZSTD_isError(rv)) {
+//         return rv;
+//     }
+//     rv = ZSTD_CCtx_setParameter(c, ZSTD_c_compressionLevel, compressionLevel);
+//     if (ZSTD_isError(rv)) {
+//         return rv;
+//     }
+//     rv = ZSTD_CCtx_refPrefix(c, (const void*)prefix, prefixSize);
+//     if (ZSTD_isError(rv)) {
+//         return rv;
+//     }
+//     return ZSTD_compress2(c, dst, dstCapacity, (const void*)src, srcSize);
+// }
+//
+// static size_t ZSTD_decompress_usingPrefix_wrapper(void *dctx, void *buf, size_t prefixSize, size_t dstCapacity, void *src, size_t srcSize) {
+//     ZSTD_DCtx *d = (ZSTD_DCtx*)dctx;
+//     size_t rv = ZSTD_DCtx_reset(d, ZSTD_reset_session_only);
+//     if (ZSTD_isError(rv)) {
+//         return rv;
+//     }
+//     rv = ZSTD_DCtx_refPrefix(d, (const void*)buf, prefixSize);
+//     if (ZSTD_isError(rv)) {
+//         return rv;
+//     }
+//     // The output directly follows the prefix, so zstd treats them
+//     // as a contiguous history.
+//     return ZSTD_decompressDCtx(d, (char*)buf + prefixSize, dstCapacity, (const void*)src, srcSize);
+// }
+//
+// static unsigned long long ZSTD_decompressBound_prefix_wrapper(void *src, size_t srcSize) {
+//     return ZSTD_decompressBound((const void*)src, srcSize);
+// }
+//
+// static size_t ZSTD_findFrameCompressedSize_prefix_wrapper(void *src, size_t srcSize) {
+//     return ZSTD_findFrameCompressedSize((const void*)src, srcSize);
+// }
+import "C"
+
+var (
+	cctxPrefixPool sync.Pool
+	dctxPrefixPool sync.Pool
+)
+
+// CompressWithPrefix appends compressed src to dst using prefix
+// as the raw content prefix and returns the result.
+//
+// The compressor may reference prefix data as if it preceded src,
+// so the compressed data is small if src is similar to prefix.
+// The resulting frame can be decompressed only with the same prefix.
+// The given compressionLevel is used for the compression.
+func CompressWithPrefix(dst, src, prefix []byte, compressionLevel int) []byte {
+	dstLen := len(dst)
+	compressBound := CompressBoundCached(len(src))
+	if n := dstLen + compressBound - cap(dst); n > 0 {
+		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+
+	v := cctxPrefixPool.Get()
+	if v == nil {
+		v = NewCCtx()
+	}
+	c := v.(*CCtx)
+
+	dstBuf := dst[dstLen:cap(dst)]
+	dstHdr := (*reflect.SliceHeader)(unsafe.Pointer(&dstBuf))
+	srcHdr := (*reflect.SliceHeader)(unsafe.Pointer(&src))
+	prefixHdr := (*reflect.SliceHeader)(unsafe.Pointer(&prefix))
+	result := C.ZSTD_compress_usingPrefix_wrapper(
+		unsafe.Pointer(c.cctx),
+		unsafe.Pointer(dstHdr.Data),
+		C.size_t(len(dstBuf)),
+		unsafe.Pointer(srcHdr.Data),
+		C.size_t(len(src)),
+		unsafe.Pointer(prefixHdr.Data),
+		C.size_t(len(prefix)),
+		C.int(compressionLevel))
+	// Prevent from GC'ing of dst, src and prefix during CGO call above.
+	runtime.KeepAlive(dstBuf)
+	runtime.KeepAlive(src)
+	runtime.KeepAlive(prefix)
+	cctxPrefixPool.Put(c)
+
+	ensureNoError("ZSTD_compress2", result)
+	return dst[:dstLen+int(result)]
+}
+
+// DecompressWithPrefixContent appends decompressed src to dst using
+// the existing dst content as the raw content prefix and returns the result.
+//
+// src must be compressed via CompressWithPrefix with the prefix equal
+// to dst contents. src must contain a single frame. dst is grown before
+// the decompression to the decompressed size bound, so the frame
+// is decompressed in a single pass without copying the prefix. An error
+// is returned if the bound exceeds the limit set via SetMaxDirectDecompressSize.
+func DecompressWithPrefixContent(dst, src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return dst, nil
+	}
+
+	srcHdr := (*reflect.SliceHeader)(unsafe.Pointer(&src))
+	frameSize := C.ZSTD_findFrameCompressedSize_prefix_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	if zstdIsError(frameSize) {
+		runtime.KeepAlive(src)
+		return dst, fmt.Errorf("cannot find the frame in src: %w", newDecompressError(src, frameSize, 0))
+	}
+	if int(frameSize) < len(src) {
+		return dst, fmt.Errorf("unexpected data after the first frame; frame size is %d bytes; src size is %d bytes", int(frameSize), len(src))
+	}
+	bound := C.ZSTD_decompressBound_prefix_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+	runtime.KeepAlive(src)
+	if bound == C.ZSTD_CONTENTSIZE_ERROR {
+		return dst, invalidSrcError(src)
+	}
+	dstLen := len(dst)
+	if uint64(bound) > getMaxDirectDecompressSize() || uint64(bound) >= uint64(maxInt-dstLen) {
+		return dst, fmt.Errorf("too big decompressed size bound for src; got %d bytes; the limit is %d bytes", uint64(bound), getMaxDirectDecompressSize())
+	}
+	decompressBound := int(bound)
+	if n := dstLen + decompressBound - cap(dst); n > 0 {
+		// This should be optimized since go 1.11 - see https://golang.org/doc/go1.11#performance-compiler.
+		dst = append(dst[:cap(dst)], make([]byte, n)...)
+	}
+
+	v := dctxPrefixPool.Get()
+	if v == nil {
+		v = NewDCtx()
+	}
+	d := v.(*DCtx)
+
+	buf := dst[:dstLen+decompressBound]
+	bufHdr := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	result := C.ZSTD_decompress_usingPrefix_wrapper(
+		unsafe.Pointer(d.dctx),
+		unsafe.Pointer(bufHdr.Data),
+		C.size_t(dstLen),
+		C.size_t(decompressBound),
+		unsafe.Pointer(srcHdr.Data),
+		C.size_t(len(src)))
+	// Prevent from GC'ing of buf and src during CGO call above.
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(src)
+	dctxPrefixPool.Put(d)
+
+	if zstdIsError(result) {
+		return dst[:dstLen], newDecompressError(src, result, 0)
+	}
+	return dst[:dstLen+int(result)], nil
+}
@@ -0,0 +1,78 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestCompressDecompressWithPrefixContent(t *testing.T) {
+	// Build three versions of the data, where every version slightly
+	// modifies the previous one.
+	var versions [][]byte
+	var bb bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&bb, "line %d, value %d\n", i, i*i)
+	}
+	versions = append(versions, append([]byte{}, bb.Bytes()...))
+	for v := 1; v < 3; v++ {
+		prev := versions[len(versions)-1]
+		next := append([]byte{}, prev[:len(prev)/2]...)
+		next = append(next, fmt.Sprintf("inserted in version %d\n", v)...)
+		next = append(next, prev[len(prev)/2:]...)
+		versions = append(versions, next)
+	}
+
+	var dst []byte
+	var prev []byte
+	for v, data := range versions {
+		compressedData := CompressWithPrefix(nil, data, prev, DefaultCompressionLevel)
+		if v > 0 {
+			fullSize := len(Compress(nil, data))
+			if len(compressedData)*10 > fullSize {
+				t.Fatalf("too big delta for version %d; got %d bytes; want less than %d bytes", v, len(compressedData), fullSize/10)
+			}
+			if _, err := Decompress(nil, compressedData); err == nil {
+				t.Fatalf("expecting non-nil error when decompressing delta for version %d without prefix", v)
+			}
+		}
+
+		// dst contains the previous version, which is used as the prefix.
+		dstLen := len(dst)
+		var err error
+		dst, err = DecompressWithPrefixContent(dst, compressedData)
+		if err != nil {
+			t.Fatalf("cannot decompress version %d: %s", v, err)
+		}
+		if !bytes.Equal(dst[:dstLen], prev) {
+			t.Fatalf("the prefix mustn't be modified for version %d", v)
+		}
+		if !bytes.Equal(dst[dstLen:], data) {
+			t.Fatalf("unexpected data for version %d; len(got)=%d; len(want)=%d", v, len(dst)-dstLen, len(data))
+		}
+
+		// Keep only the last version in dst.
+		dst = append(dst[:0], dst[dstLen:]...)
+		prev = data
+	}
+
+	// Invalid src.
+	dst = append(dst[:0], "foobar"...)
+	result, err := DecompressWithPrefixContent(dst, []byte("invalid frame"))
+	if err == nil {
+		t.Fatalf("expecting non-nil error for invalid frame")
+	}
+	if string(result) != "foobar" {
+		t.Fatalf("dst must be returned unchanged on error; got %q", result)
+	}
+
+	// Multiple frames.
+	compressedData := CompressWithPrefix(nil, []byte("foo"), nil, DefaultCompressionLevel)
+	compressedData = append(compressedData, compressedData...)
+	if _, err := DecompressWithPrefixContent(nil, compressedData); err == nil {
+		t.Fatalf("expecting non-nil error for multiple frames")
+	}
+}
@@ -27,6 +27,40 @@ static size_t ZSTD_initDStream_usingDDict_wrapper(void *ds, void *dict) {
     return ZSTD_DCtx_refDDict(zds, (ZSTD_DDict *)dict);
 }
 
+static size_t ZSTD_initDStream_loadDictionary_wrapper(void *ds, void *dict, size_t dictSize) {
+    ZSTD_DStream *zds = (ZSTD_DStream *)ds;
+    size_t rv = ZSTD_DCtx_reset(zds, ZSTD_reset_session_only);
+    if (rv != 0) {
+        return rv;
+    }
+    return ZSTD_DCtx_loadDictionary(zds, dict, dictSize);
+}
+
+static size_t ZSTD_DCtx_refDDict_wrapper(void *ds, void *dict) {
+    return ZSTD_DCtx_refDDict((ZSTD_DStream*)ds, (ZSTD_DDict*)dict);
+}
+
+static size_t ZSTD_DCtx_setParameter_reader_wrapper(void *ds, ZSTD_dParameter param, int value) {
+    return ZSTD_DCtx_setParameter((ZSTD_DStream*)ds, param, value);
+}
+
+static unsigned ZSTD_getDictID_fromDict_wrapper(void *dict, size_t dictSize) {
+    return ZSTD_getDictID_fromDict((const void*)dict, dictSize);
+}
+
+// ZSTD_getFrameDictID_wrapper parses the frame header at src.
+// It returns the number of additional bytes needed for the header if src is too small.
+static size_t ZSTD_getFrameDictID_wrapper(void *src, size_t srcSize, unsigned *dictID, int *skippable) {
+    ZSTD_frameHeader zfh;
+    size_t rv = ZSTD_getFrameHeader(&zfh, (const void*)src, srcSize);
+    if (rv != 0) {
+        return rv;
+    }
+    *dictID = zfh.dictID;
+    *skippable = zfh.frameType == ZSTD_skippableFrame;
+    return 0;
+}
+
 static size_t ZSTD_freeDStream_wrapper(void *ds) {
     return ZSTD_freeDStream((ZSTD_DStream*)ds);
 }
@@ -39,10 +73,12 @@ import "C"
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"reflect"
 	"runtime"
+	"time"
 	"unsafe"
 )
 
@@ -59,11 +95,39 @@ type Reader struct {
 	ds *C.ZSTD_DStream
 	dd *DDict
 
+	// dictID is the ID of the dictionary used by ds.
+	dictID uint32
+
+	// inBufWrapper is nil if inBuf isn't obtained from the pool.
 	inBufWrapper  *bytes.Buffer
 	outBufWrapper *bytes.Buffer
 
 	skipNextRead bool
 
+	followInterval time.Duration
+
+	onFrameEnd func()
+	// frameEnded is set when the decompressor reaches the end of frame.
+	// onFrameEnd is called when the data for the frame is consumed.
+	frameEnded bool
+
+	// frameComplete is set when the current frame is completely decoded.
+	frameComplete bool
+
+	// checkDictID is set by ExpectDictID.
+	checkDictID    bool
+	expectedDictID uint32
+	// frameStart is set when the header of the next frame isn't parsed yet.
+	frameStart bool
+
+	// readResults receives the result of the underlying Read, which runs
+	// in background when ReadContext is called with cancellable context.
+	readResults chan readResult
+	// readPending is set when ReadContext has been cancelled before
+	// the underlying Read returned. The Read result is received from
+	// readResults on the next read.
+	readPending bool
+
 	readerPos int
 	inBuf     []byte
 	outBuf    []byte
@@ -98,25 +162,303 @@ func NewReaderDict(r io.Reader, dd *DDict) *Reader {
 		outBufWrapper: outBufWrapper,
 		inBuf:         inBufWrapper.Bytes(),
 		outBuf:        outBufWrapper.Bytes(),
+		frameStart:    true,
+	}
+	if dd != nil {
+		zr.dictID = dd.ID()
 	}
 
 	runtime.SetFinalizer(zr, freeDStream)
 	return zr
 }
 
+// NewReaderSize returns new zstd reader reading compressed data from r
+// using the given DDict and the input buffer with bufSize bytes.
+//
+// Bigger input buffer reduces the number of Read calls on r.
+// The default input buffer size is used if bufSize is non-positive.
+// bufSize is rounded up to 1KB if it is smaller. dd may be nil.
+// The buffer size is preserved across Reset calls.
+//
+// Call Release when the Reader is no longer needed.
+func NewReaderSize(r io.Reader, bufSize int, dd *DDict) *Reader {
+	zr := NewReaderDict(r, dd)
+	if bufSize <= 0 || bufSize == cap(zr.inBuf) {
+		return zr
+	}
+	if bufSize < minReaderBufSize {
+		bufSize = minReaderBufSize
+	}
+	// Return the pooled buffer, since the custom buffer
+	// isn't returned to the pool on Release.
+	decInBufPool.Put(zr.inBufWrapper)
+	zr.inBufWrapper = nil
+	zr.inBuf = make([]byte, 0, bufSize)
+	return zr
+}
+
+// NewAutoReader returns io.Reader, which decompresses zstd data read from r
+// or passes through the data read from r as is if it isn't zstd-compressed.
+//
+// The data is considered zstd-compressed if it starts with zstd frame
+// according to IsZstdFrame. This simplifies gradual migration of stored
+// data to zstd. Note that plain data starting with zstd magic number
+// is detected as zstd-compressed. The detection is performed on the first
+// Read call. Unlike Reader, the returned reader returns ErrTruncated
+// if zstd-compressed data ends in the middle of a frame. Resources
+// for the decompression are released when the returned reader returns
+// an error, including io.EOF.
+func NewAutoReader(r io.Reader) io.Reader {
+	return &autoReader{
+		r: r,
+	}
+}
+
+type autoReader struct {
+	r   io.Reader
+	zr  *Reader
+	err error
+
+	detected bool
+	prefix   [4]byte
+}
+
+func (ar *autoReader) Read(p []byte) (int, error) {
+	if ar.err != nil {
+		return 0, ar.err
+	}
+	if !ar.detected {
+		if err := ar.detect(); err != nil {
+			ar.err = err
+			return 0, err
+		}
+	}
+	if ar.zr == nil {
+		return ar.r.Read(p)
+	}
+	n, err := ar.zr.Read(p)
+	if err == io.EOF {
+		// Reader doesn't detect truncated frames at the end of r.
+		if errTruncated := checkStreamComplete(ar.zr, nil); errTruncated != nil {
+			err = errTruncated
+		}
+	}
+	if err != nil {
+		ar.zr.Release()
+		ar.zr = nil
+		ar.err = err
+	}
+	return n, err
+}
+
+func (ar *autoReader) detect() error {
+	ar.detected = true
+	n, err := io.ReadFull(ar.r, ar.prefix[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	prefix := ar.prefix[:n]
+	ar.r = io.MultiReader(bytes.NewReader(prefix), ar.r)
+	if IsZstdFrame(prefix) {
+		ar.zr = NewReader(ar.r)
+	}
+	return nil
+}
+
+// minReaderBufSize is the minimum input buffer size for NewReaderSize.
+const minReaderBufSize = 1024
+
+// NewReaderRawDict returns new zstd reader reading compressed data from r
+// using the given raw dictionary bytes.
+//
+// The dict is copied into the Reader, so it may be modified after the call.
+//
+// Call Release when the Reader is no longer needed.
+func NewReaderRawDict(r io.Reader, dict []byte) (*Reader, error) {
+	zr := NewReader(r)
+	if err := zr.ResetRawDict(r, dict); err != nil {
+		zr.Release()
+		return nil, err
+	}
+	return zr, nil
+}
+
+// ResetRawDict resets zr to read from r using the given raw dictionary bytes.
+//
+// The dict is copied into zr. It is dropped on the next Reset call.
+func (zr *Reader) ResetRawDict(r io.Reader, dict []byte) error {
+	if len(dict) == 0 {
+		return fmt.Errorf("dict cannot be empty")
+	}
+
+	zr.Reset(r, nil)
+	result := C.ZSTD_initDStream_loadDictionary_wrapper(
+		unsafe.Pointer(zr.ds),
+		unsafe.Pointer(&dict[0]),
+		C.size_t(len(dict)))
+	// Prevent from GC'ing of dict during CGO call above.
+	runtime.KeepAlive(dict)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot load dictionary: %w", newError(result))
+	}
+	zr.dictID = uint32(C.ZSTD_getDictID_fromDict_wrapper(unsafe.Pointer(&dict[0]), C.size_t(len(dict))))
+	runtime.KeepAlive(dict)
+	return nil
+}
+
+// ClearDict stops using the dictionary for decompressing the next frames
+// read from the same source.
+//
+// It must be called between frames. An error is returned if zr is
+// in the middle of a frame.
+func (zr *Reader) ClearDict() error {
+	zr.mustNotBeReleased()
+	result := C.ZSTD_DCtx_refDDict_wrapper(unsafe.Pointer(zr.ds), nil)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot clear dictionary: %w", newError(result))
+	}
+	zr.dd = nil
+	zr.dictID = 0
+	return nil
+}
+
+// CurrentDictID returns the ID of the dictionary used by zr.
+//
+// 0 is returned if zr doesn't use a dictionary or if the dictionary
+// has no ID.
+func (zr *Reader) CurrentDictID() uint32 {
+	return zr.dictID
+}
+
+// SetFrameEndCallback sets f to be called each time zr reaches the end
+// of a frame in the compressed stream.
+//
+// f is called after all the data for the frame is returned by zr
+// and before the data for the next frame is returned, so the frame
+// boundaries may be detected in concatenated streams.
+// The callback is preserved across Reset calls. Pass nil for disabling it.
+func (zr *Reader) SetFrameEndCallback(f func()) {
+	zr.onFrameEnd = f
+}
+
+func (zr *Reader) notifyFrameEnd() {
+	if !zr.frameEnded {
+		return
+	}
+	zr.frameEnded = false
+	if zr.onFrameEnd != nil {
+		zr.onFrameEnd()
+	}
+}
+
+// FrameComplete returns true if the current frame is completely decoded.
+//
+// This means all the frame data has been decompressed and its checksum,
+// if present, has been validated. It becomes false as soon as zr starts
+// decoding the next frame. FrameComplete returning false after io.EOF
+// means the stream is truncated in the middle of a frame, so it may be
+// used for verifying the integrity of a single-frame stream before acting
+// on the decoded data.
+//
+// Note that the decoded data for the frame may still be buffered in zr
+// when FrameComplete returns true.
+func (zr *Reader) FrameComplete() bool {
+	return zr.frameComplete
+}
+
+// ExpectDictID makes zr reject frames with dictionary ID other than id.
+//
+// The dictionary ID is verified in the frame header before decompressing
+// the frame, so frames crafted for other dictionary or without dictionary
+// are rejected early. Pass 0 for accepting only frames without dictionary ID.
+// Skippable frames are accepted. The check is disabled by Reset.
+func (zr *Reader) ExpectDictID(id uint32) {
+	zr.checkDictID = true
+	zr.expectedDictID = id
+}
+
+// checkFrameDictID verifies the dictionary ID in the header of the frame
+// at the start of inBuf. It reads more data into inBuf if needed.
+func (zr *Reader) checkFrameDictID(ctx context.Context) error {
+	for {
+		src := zr.inBuf[zr.sizes.srcPos:]
+		var dictID C.unsigned
+		var skippable C.int
+		srcHdr := (*reflect.SliceHeader)(unsafe.Pointer(&src))
+		result := C.ZSTD_getFrameDictID_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)), &dictID, &skippable)
+		if zstdIsError(result) {
+			// Let the decompressor report the invalid frame.
+			zr.frameStart = false
+			return nil
+		}
+		if result == 0 {
+			if skippable == 0 && uint32(dictID) != zr.expectedDictID {
+				return fmt.Errorf("unexpected dictionary ID in the frame header; got %d; want %d", uint32(dictID), zr.expectedDictID)
+			}
+			zr.frameStart = false
+			return nil
+		}
+		// The frame header is incomplete. Read more data.
+		if err := zr.fillInBuf(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// SetFollowInterval enables tail-follow mode for zr if d is positive.
+//
+// In this mode io.EOF from the underlying reader means there is no more
+// data yet, so zr waits for d and then tries reading again instead
+// of returning io.EOF. Partially written frame at the end of the stream
+// is decompressed as soon as its blocks become available.
+//
+// zr never returns io.EOF in this mode, so use ReadContext with cancellable
+// context for stopping the reading. Pass 0 for disabling the mode.
+// The interval is preserved across Reset calls.
+func (zr *Reader) SetFollowInterval(d time.Duration) {
+	zr.followInterval = d
+}
+
 // Reset resets zr to read from r using the given dictionary dd.
 func (zr *Reader) Reset(r io.Reader, dd *DDict) {
 	zr.readerPos = 0
+	zr.frameEnded = false
+	zr.frameComplete = false
+	zr.checkDictID = false
+	zr.expectedDictID = 0
+	zr.frameStart = true
 	zr.sizes = C.ZSTD_EXT_BufferSizes{}
+	if zr.readPending {
+		// The abandoned Read still writes into inBuf, so it cannot be reused.
+		zr.readPending = false
+		zr.readResults = nil
+		zr.inBufWrapper = nil
+		zr.inBuf = make([]byte, 0, cap(zr.inBuf))
+	}
 	zr.inBuf = zr.inBuf[:0]
 	zr.outBuf = zr.outBuf[:0]
 
 	zr.dd = dd
+	zr.dictID = 0
+	if dd != nil {
+		zr.dictID = dd.ID()
+	}
 	initDStream(zr.ds, zr.dd)
 
 	zr.r = r
 }
 
+// setParameter sets the given decompression parameter on zr.
+//
+// The parameter persists across Reset calls.
+func (zr *Reader) setParameter(param DParameter, value int) error {
+	result := C.ZSTD_DCtx_setParameter_reader_wrapper(unsafe.Pointer(zr.ds), C.ZSTD_dParameter(param), C.int(value))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot set decompression parameter %d to %d: %w", param, value, newError(result))
+	}
+	return nil
+}
+
 func initDStream(ds *C.ZSTD_DStream, dd *DDict) {
 	var ddict *C.ZSTD_DDict
 	if dd != nil {
@@ -130,6 +472,14 @@ func freeDStream(v interface{}) {
 	v.(*Reader).Release()
 }
 
+// mustNotBeReleased panics if zr is used after Release, since passing
+// nil stream to CGO results in hard-to-debug crashes.
+func (zr *Reader) mustNotBeReleased() {
+	if zr.ds == nil {
+		panic(fmt.Errorf("BUG: Reader is used after Release"))
+	}
+}
+
 // Release releases all the resources occupied by zr.
 //
 // zr cannot be used after the release.
@@ -144,11 +494,21 @@ func (zr *Reader) Release() {
 
 	zr.r = nil
 	zr.dd = nil
+	zr.onFrameEnd = nil
 
+	if zr.readPending {
+		// The abandoned Read still writes into inBuf, so it cannot be
+		// returned to the pool.
+		zr.readPending = false
+		zr.readResults = nil
+		zr.inBufWrapper = nil
+	}
 	if zr.inBuf != nil {
 		zr.inBuf = nil
-		decInBufPool.Put(zr.inBufWrapper)
-		zr.inBufWrapper = nil
+		if zr.inBufWrapper != nil {
+			decInBufPool.Put(zr.inBufWrapper)
+			zr.inBufWrapper = nil
+		}
 	}
 	if zr.outBuf != nil {
 		zr.outBuf = nil
@@ -164,7 +524,7 @@ func (zr *Reader) WriteTo(w io.Writer) (int64, error) {
 	nn := int64(0)
 	for {
 		if zr.readerPos >= len(zr.outBuf) {
-			if _, err := zr.fillOutBuf(nil); err != nil {
+			if _, err := zr.fillOutBuf(context.Background(), nil); err != nil {
 				if err == io.EOF {
 					return nn, nil
 				}
@@ -183,17 +543,30 @@ func (zr *Reader) WriteTo(w io.Writer) (int64, error) {
 
 // Read reads up to len(p) bytes from zr to p.
 func (zr *Reader) Read(p []byte) (int, error) {
+	return zr.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads up to len(p) bytes from zr to p.
+//
+// ctx.Err() is returned as soon as ctx is cancelled, even if the underlying
+// reader is blocked. The underlying Read runs in a separate goroutine
+// for cancellable ctx, so the blocked Read keeps running after ReadContext
+// returns. Its result is used by the next read from zr, so no data is lost.
+func (zr *Reader) ReadContext(ctx context.Context, p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
 	if zr.readerPos >= len(zr.outBuf) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
 		if len(p) >= minDirectWriteBufferSize {
 			// write directly into the target buffer
 			// but make sure to override its capacity
-			return zr.fillOutBuf(p[:len(p):len(p)])
+			return zr.fillOutBuf(ctx, p[:len(p):len(p)])
 		}
-		if _, err := zr.fillOutBuf(nil); err != nil {
+		if _, err := zr.fillOutBuf(ctx, nil); err != nil {
 			return 0, err
 		}
 		zr.readerPos = 0
@@ -204,7 +577,10 @@ func (zr *Reader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
-func (zr *Reader) fillOutBuf(target []byte) (int, error) {
+func (zr *Reader) fillOutBuf(ctx context.Context, target []byte) (int, error) {
+	// All the data for the previous frame has been returned at this point.
+	zr.notifyFrameEnd()
+
 	dst := target
 	if dst == nil {
 		dst = zr.outBuf
@@ -215,7 +591,7 @@ func (zr *Reader) fillOutBuf(target []byte) (int, error) {
 		// is smaller than the maximum possible dst.size.
 		// This means that the internal buffer in zr.ds doesn't contain
 		// more data to decompress, so read new data into inBuf.
-		if err := zr.fillInBuf(); err != nil {
+		if err := zr.fillInBuf(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -226,6 +602,14 @@ func (zr *Reader) fillOutBuf(target []byte) (int, error) {
 	inHdr := (*reflect.SliceHeader)(unsafe.Pointer(&zr.inBuf))
 	outHdr := (*reflect.SliceHeader)(unsafe.Pointer(&dst))
 tryDecompressAgain:
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if zr.checkDictID && zr.frameStart {
+		if err := zr.checkFrameDictID(ctx); err != nil {
+			return 0, err
+		}
+	}
 	zr.sizes.srcSize = C.size_t(len(zr.inBuf))
 	prevInBufPos := zr.sizes.srcPos
 
@@ -239,7 +623,20 @@ tryDecompressAgain:
 	}
 
 	if zstdIsError(result) {
-		return int(zr.sizes.dstPos), fmt.Errorf("cannot decompress data: %s", errStr(result))
+		e := newError(result)
+		// The mismatched frame header is located at prevInBufPos
+		// unless it is split among reads from the underlying reader.
+		e.setDictIDs(zr.inBuf[prevInBufPos:], zr.dictID)
+		return int(zr.sizes.dstPos), fmt.Errorf("cannot decompress data: %w", e)
+	}
+	if result == 0 {
+		// The decompressor stops at the end of each frame.
+		zr.frameEnded = true
+		zr.frameComplete = true
+		zr.frameStart = true
+	} else if zr.sizes.dstPos > 0 || zr.sizes.srcPos != prevInBufPos {
+		// The decompressor made progress in the next frame.
+		zr.frameComplete = false
 	}
 
 	if zr.sizes.dstPos > 0 {
@@ -247,7 +644,9 @@ tryDecompressAgain:
 		return int(zr.sizes.dstPos), nil
 	}
 
-	// Nothing has been decompressed from inBuf.
+	// Nothing has been decompressed from inBuf, so the frame has no
+	// pending data.
+	zr.notifyFrameEnd()
 	if zr.sizes.srcPos != prevInBufPos && int(zr.sizes.srcPos) < len(zr.inBuf) {
 		// Data has been consumed from inBuf, but decompressed
 		// into nothing. There is more data in inBuf, so try
@@ -258,15 +657,15 @@ tryDecompressAgain:
 	// Either nothing has been consumed from inBuf or it has been
 	// decompressed into nothing and inBuf became empty.
 	// Read more data into inBuf and try decompressing again.
-	if err := zr.fillInBuf(); err != nil {
+	if err := zr.fillInBuf(ctx); err != nil {
 		return 0, err
 	}
 
 	goto tryDecompressAgain
 }
 
-func (zr *Reader) fillInBuf() error {
-	if zr.sizes.srcPos > 0 {
+func (zr *Reader) fillInBuf(ctx context.Context) error {
+	if zr.sizes.srcPos > 0 && !zr.readPending {
 		if int(zr.sizes.srcPos) == len(zr.inBuf) {
 			// we've read all the data from inBuf, reset it
 			zr.inBuf = zr.inBuf[:0]
@@ -281,12 +680,20 @@ func (zr *Reader) fillInBuf() error {
 
 readAgain:
 	// Read more data into inBuf.
-	n, err := zr.r.Read(zr.inBuf[len(zr.inBuf):cap(zr.inBuf)])
+	n, err := zr.readInBuf(ctx)
+	if zr.readPending {
+		// ctx has been cancelled before the underlying Read returned.
+		return err
+	}
 	zr.inBuf = zr.inBuf[:len(zr.inBuf)+n]
 
 	if err == nil {
 		if n == 0 {
-			// Nothing has been read. Try reading data again.
+			// Nothing has been read. Try reading data again
+			// unless the caller gave up waiting.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			goto readAgain
 		}
 		return nil
@@ -296,8 +703,57 @@ readAgain:
 		return nil
 	}
 	if err == io.EOF {
+		if zr.followInterval > 0 {
+			// Wait until the underlying reader receives more data.
+			t := time.NewTimer(zr.followInterval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+			goto readAgain
+		}
 		// Do not wrap io.EOF, so the caller may notify the end of stream.
 		return err
 	}
 	return fmt.Errorf("cannot read data from the underlying reader: %w", err)
 }
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// readInBuf reads data from the underlying reader into the free space of inBuf.
+//
+// The Read is performed in background if ctx is cancellable, so ctx.Err()
+// is returned without waiting for the Read to finish if ctx is cancelled.
+func (zr *Reader) readInBuf(ctx context.Context) (int, error) {
+	if !zr.readPending {
+		buf := zr.inBuf[len(zr.inBuf):cap(zr.inBuf)]
+		if ctx.Done() == nil {
+			return zr.r.Read(buf)
+		}
+		if zr.readResults == nil {
+			zr.readResults = make(chan readResult, 1)
+		}
+		r := zr.r
+		ch := zr.readResults
+		go func() {
+			n, err := r.Read(buf)
+			ch <- readResult{
+				n:   n,
+				err: err,
+			}
+		}()
+		zr.readPending = true
+	}
+	select {
+	case rr := <-zr.readResults:
+		zr.readPending = false
+		return rr.n, rr.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
@@ -60,3 +63,50 @@ func ExampleReader_Reset() {
 	// line 1
 	// line 2
 }
+
+func ExampleNewReaderDict() {
+	// Build a dictionary from samples.
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		sample := fmt.Sprintf("this is a dict sample number %d", i)
+		samples = append(samples, []byte(sample))
+	}
+	dict := BuildDict(samples, 8*1024)
+
+	cd, err := NewCDict(dict)
+	if err != nil {
+		log.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+
+	dd, err := NewDDict(dict)
+	if err != nil {
+		log.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	// Compress the stream with the dictionary.
+	var bb bytes.Buffer
+	zw := NewWriterDict(&bb, cd)
+	defer zw.Release()
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(zw, "this is line %d for dict compression\n", i)
+	}
+	if err := zw.Close(); err != nil {
+		log.Fatalf("cannot close writer: %s", err)
+	}
+
+	// Decompress the stream with the same dictionary.
+	zr := NewReaderDict(&bb, dd)
+	defer zr.Release()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		log.Fatalf("cannot decompress stream: %s", err)
+	}
+	fmt.Printf("%s", data)
+
+	// Output:
+	// this is line 0 for dict compression
+	// this is line 1 for dict compression
+	// this is line 2 for dict compression
+}
@@ -1,13 +1,20 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -152,6 +159,167 @@ func testReaderDictSerial(cd *CDict, dd *DDict) error {
 	return nil
 }
 
+func TestReaderRawDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1e4; i++ {
+		sample := []byte(fmt.Sprintf("this is a sample number %d", i))
+		samples = append(samples, sample)
+	}
+	dict := BuildDict(samples, 8*1024)
+
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+
+	var bb bytes.Buffer
+	for i := 0; i < 8000; i++ {
+		fmt.Fprintf(&bb, "This is number %d ", i)
+	}
+	origData := bb.Bytes()
+	compressedData := CompressDict(nil, origData, cd)
+
+	zr, err := NewReaderRawDict(bytes.NewReader(compressedData), dict)
+	if err != nil {
+		t.Fatalf("cannot create reader with raw dict: %s", err)
+	}
+	defer zr.Release()
+	if id := zr.CurrentDictID(); id != cd.ID() {
+		t.Fatalf("unexpected dict id for raw dict; got %d; want %d", id, cd.ID())
+	}
+
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot stream decompress data with raw dict: %s", err)
+	}
+	if !bytes.Equal(plainData, origData) {
+		t.Fatalf("unexpected stream uncompressed data; got\n%q; want\n%q", plainData, origData)
+	}
+
+	// Reset must drop the raw dict.
+	zr.Reset(bytes.NewReader(compressedData), nil)
+	_, err = ioutil.ReadAll(zr)
+	if err == nil {
+		t.Fatalf("expecting non-nil error when stream decompressing after Reset")
+	}
+	if !strings.Contains(err.Error(), "Dictionary mismatch") {
+		t.Fatalf("unexpected error when stream decompressing after Reset; got %q; want %q", err, "Dictionary mismatch")
+	}
+
+	// ResetRawDict must load the dict again.
+	if err := zr.ResetRawDict(bytes.NewReader(compressedData), dict); err != nil {
+		t.Fatalf("cannot reset reader with raw dict: %s", err)
+	}
+	plainData, err = ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot stream decompress data with raw dict after ResetRawDict: %s", err)
+	}
+	if !bytes.Equal(plainData, origData) {
+		t.Fatalf("unexpected stream uncompressed data after ResetRawDict; got\n%q; want\n%q", plainData, origData)
+	}
+
+	if _, err := NewReaderRawDict(nil, nil); err == nil {
+		t.Fatalf("expecting non-nil error for empty raw dict")
+	}
+}
+
+func TestReaderClearDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1e4; i++ {
+		sample := []byte(fmt.Sprintf("this is a sample number %d", i))
+		samples = append(samples, sample)
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	var bb bytes.Buffer
+	for i := 0; i < 8000; i++ {
+		fmt.Fprintf(&bb, "This is number %d ", i)
+	}
+	origData := bb.Bytes()
+
+	// The first frame is compressed with dict, while the second one is compressed without dict.
+	compressedData := CompressDict(nil, origData, cd)
+	compressedData = Compress(compressedData, origData)
+
+	zr := NewReaderDict(bytes.NewReader(compressedData), dd)
+	defer zr.Release()
+	if id := zr.CurrentDictID(); id != dd.ID() {
+		t.Fatalf("unexpected dict id; got %d; want %d", id, dd.ID())
+	}
+	frames := 0
+	zr.SetFrameEndCallback(func() {
+		frames++
+		if frames == 1 {
+			if err := zr.ClearDict(); err != nil {
+				t.Fatalf("cannot clear dict: %s", err)
+			}
+		}
+	})
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if frames != 2 {
+		t.Fatalf("unexpected number of frames; got %d; want 2", frames)
+	}
+	if id := zr.CurrentDictID(); id != 0 {
+		t.Fatalf("unexpected dict id after ClearDict; got %d; want 0", id)
+	}
+	if want := append(append([]byte{}, origData...), origData...); !bytes.Equal(plainData, want) {
+		t.Fatalf("unexpected decompressed data; got %d bytes; want %d bytes", len(plainData), len(want))
+	}
+
+	// The second frame compressed with dict mustn't be decompressed after ClearDict.
+	compressedData = CompressDict(nil, origData, cd)
+	compressedData = CompressDict(compressedData, origData, cd)
+	frames = 0
+	zr.Reset(bytes.NewReader(compressedData), dd)
+	if _, err := ioutil.ReadAll(zr); err == nil {
+		t.Fatalf("expecting non-nil error when decompressing dict frame after ClearDict")
+	}
+
+	// ClearDict must fail in the middle of a frame.
+	zr.SetFrameEndCallback(nil)
+	zr.Reset(bytes.NewReader(compressedData), dd)
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := zr.ClearDict(); err == nil {
+		t.Fatalf("expecting non-nil error when clearing dict in the middle of a frame")
+	}
+	if id := zr.CurrentDictID(); id != dd.ID() {
+		t.Fatalf("unexpected dict id after failed ClearDict; got %d; want %d", id, dd.ID())
+	}
+
+	// ClearDict must panic after Release.
+	zrReleased := NewReader(bytes.NewReader(compressedData))
+	zrReleased.Release()
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatalf("expecting panic in ClearDict after Release")
+			}
+			if s := fmt.Sprint(r); !strings.Contains(s, "Reader is used after Release") {
+				t.Fatalf("unexpected panic message; got %q", s)
+			}
+		}()
+		_ = zrReleased.ClearDict()
+	}()
+}
+
 func TestReaderMultiFrames(t *testing.T) {
 	var bb bytes.Buffer
 	for bb.Len() < 3*128*1024 {
@@ -174,6 +342,179 @@ func TestReaderMultiFrames(t *testing.T) {
 	}
 }
 
+func TestReaderSize(t *testing.T) {
+	src := []byte(newTestString(4*1024*1024, 20))
+	cd := Compress(nil, src)
+	if len(cd) < 1024*1024 {
+		t.Fatalf("too small compressed data for the test: %d bytes", len(cd))
+	}
+
+	readCalls := func(bufSize int) int {
+		t.Helper()
+		cr := &countingReader{r: bytes.NewReader(cd)}
+		zr := NewReaderSize(cr, bufSize, nil)
+		defer zr.Release()
+		for i := 0; i < 2; i++ {
+			plainData, err := ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("cannot read data with bufSize=%d: %s", bufSize, err)
+			}
+			if !bytes.Equal(plainData, src) {
+				t.Fatalf("unexpected data read with bufSize=%d", bufSize)
+			}
+			// The buffer size must be preserved across Reset calls.
+			cr.r = bytes.NewReader(cd)
+			zr.Reset(cr, nil)
+		}
+		return cr.reads
+	}
+
+	defaultReads := readCalls(0)
+	bigReads := readCalls(1024 * 1024)
+	if bigReads >= defaultReads {
+		t.Fatalf("bigger buffer must result in less reads; got %d reads; want less than %d reads", bigReads, defaultReads)
+	}
+	smallReads := readCalls(1)
+	if smallReads <= defaultReads {
+		t.Fatalf("smaller buffer must result in more reads; got %d reads; want more than %d reads", smallReads, defaultReads)
+	}
+}
+
+type countingReader struct {
+	r     io.Reader
+	reads int
+	n     int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	cr.reads++
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestReaderFrameComplete(t *testing.T) {
+	src := []byte(newTestString(1024*1024, 3))
+	c := NewCCtx()
+	defer c.Release()
+	if err := c.SetParameter(CParamChecksumFlag, 1); err != nil {
+		t.Fatalf("cannot enable checksum: %s", err)
+	}
+	cd := c.Compress(nil, src)
+
+	zr := NewReader(bytes.NewReader(cd))
+	defer zr.Release()
+	if zr.FrameComplete() {
+		t.Fatalf("FrameComplete must return false before reading")
+	}
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		t.Fatalf("cannot read the start of frame: %s", err)
+	}
+	if zr.FrameComplete() {
+		t.Fatalf("FrameComplete must return false in the middle of frame")
+	}
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read the rest of frame: %s", err)
+	}
+	if !bytes.Equal(append(buf, plainData...), src) {
+		t.Fatalf("unexpected decompressed data")
+	}
+	if !zr.FrameComplete() {
+		t.Fatalf("FrameComplete must return true at the end of frame")
+	}
+
+	// Truncated frame is reported as incomplete.
+	for _, n := range []int{len(cd) / 2, len(cd) - 4, len(cd) - 1} {
+		zr.Reset(bytes.NewReader(cd[:n]), nil)
+		if _, err := ioutil.ReadAll(zr); err != nil {
+			t.Fatalf("unexpected error when reading frame truncated to %d bytes: %s", n, err)
+		}
+		if zr.FrameComplete() {
+			t.Fatalf("FrameComplete must return false for frame truncated to %d bytes", n)
+		}
+	}
+
+	// The next frame resets the state.
+	cdSmall := Compress(nil, []byte("foobar"))
+	zr.Reset(bytes.NewReader(append(append([]byte{}, cdSmall...), cd...)), nil)
+	buf = make([]byte, len("foobar")+1000)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		t.Fatalf("cannot read the start of the second frame: %s", err)
+	}
+	if zr.FrameComplete() {
+		t.Fatalf("FrameComplete must return false in the middle of the second frame")
+	}
+	if _, err := ioutil.ReadAll(zr); err != nil {
+		t.Fatalf("cannot read the rest of the second frame: %s", err)
+	}
+	if !zr.FrameComplete() {
+		t.Fatalf("FrameComplete must return true at the end of the second frame")
+	}
+}
+
+func TestReaderFrameEndCallback(t *testing.T) {
+	frame1 := []byte(newTestString(3*int(dstreamOutBufSize), 3))
+	frame2 := []byte("the second frame")
+	var compressedData []byte
+	compressedData = Compress(compressedData, frame1)
+	compressedData = Compress(compressedData, frame2)
+
+	// Read the stream in small chunks and verify the callback is called
+	// exactly at frame boundaries.
+	zr := NewReader(bytes.NewReader(compressedData))
+	defer zr.Release()
+	var boundaries []int
+	n := 0
+	zr.SetFrameEndCallback(func() {
+		boundaries = append(boundaries, n)
+	})
+	buf := make([]byte, 1000)
+	for {
+		m, err := zr.Read(buf)
+		n += m
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n != len(frame1)+len(frame2) {
+		t.Fatalf("unexpected number of bytes read; got %d; want %d", n, len(frame1)+len(frame2))
+	}
+	want := []int{len(frame1), len(frame1) + len(frame2)}
+	if !reflect.DeepEqual(boundaries, want) {
+		t.Fatalf("unexpected frame boundaries; got %v; want %v", boundaries, want)
+	}
+
+	// WriteTo must call the callback as well. Empty frame between
+	// the frames must be reported.
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("cannot write empty frame: %s", err)
+	}
+	zw.Release()
+	compressedData = Compress(nil, frame1)
+	compressedData = append(compressedData, bb.Bytes()...)
+	compressedData = Compress(compressedData, frame2)
+	bb.Reset()
+	boundaries = boundaries[:0]
+	zr.Reset(bytes.NewReader(compressedData), nil)
+	zr.SetFrameEndCallback(func() {
+		boundaries = append(boundaries, bb.Len())
+	})
+	if _, err := zr.WriteTo(&bb); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want = []int{len(frame1), len(frame1), len(frame1) + len(frame2)}
+	if !reflect.DeepEqual(boundaries, want) {
+		t.Fatalf("unexpected frame boundaries in WriteTo; got %v; want %v", boundaries, want)
+	}
+}
+
 func TestReaderBadUnderlyingReader(t *testing.T) {
 	r := &badReader{
 		b: Compress(nil, []byte(newTestString(64*1024, 30))),
@@ -208,6 +549,278 @@ func (br *badReader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+func TestReaderReadContext(t *testing.T) {
+	data := newTestString(64*1024, 20)
+	cd := Compress(nil, []byte(data))
+
+	// The source blocks after returning the whole compressed stream.
+	r := &stallingReader{
+		b:       cd,
+		unblock: make(chan struct{}),
+	}
+	defer close(r.unblock)
+	zr := NewReader(r)
+	defer zr.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := make([]byte, len(data))
+	n := 0
+	for n < len(buf) {
+		m, err := zr.ReadContext(ctx, buf[n:])
+		if err != nil {
+			t.Fatalf("unexpected error before the source stalls: %s", err)
+		}
+		n += m
+	}
+	if string(buf) != data {
+		t.Fatalf("unexpected data read;\ngot\n%X\nwant\n%X", buf, data)
+	}
+
+	// The source is stalled now. A cancelled context must be noticed
+	// without calling the blocking underlying reader.
+	cancel()
+	ch := make(chan error, 1)
+	go func() {
+		_, err := zr.ReadContext(ctx, buf)
+		ch <- err
+	}()
+	select {
+	case err := <-ch:
+		if err != context.Canceled {
+			t.Fatalf("unexpected error; got %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestReaderReadContextSlowReader(t *testing.T) {
+	data := newTestString(64*1024, 20)
+	r := &slowReader{
+		b:       Compress(nil, []byte(data)),
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	zr := NewReader(r)
+	defer zr.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan error, 1)
+	go func() {
+		_, err := zr.ReadContext(ctx, make([]byte, len(data)))
+		ch <- err
+	}()
+
+	// Cancel ctx while the underlying Read is in progress.
+	<-r.started
+	cancel()
+	select {
+	case err := <-ch:
+		if err != context.Canceled {
+			t.Fatalf("unexpected error; got %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ReadContext must return promptly while the underlying Read is blocked")
+	}
+
+	// The data read by the abandoned Read must be returned by the next read.
+	close(r.unblock)
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read data after the cancellation: %s", err)
+	}
+	if string(plainData) != data {
+		t.Fatalf("unexpected data read after the cancellation;\ngot\n%X\nwant\n%X", plainData, data)
+	}
+}
+
+func TestReaderReadContextSlowReaderReset(t *testing.T) {
+	data := newTestString(64*1024, 20)
+	cd := Compress(nil, []byte(data))
+	r := &slowReader{
+		b:       cd,
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	zr := NewReader(r)
+	defer zr.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan error, 1)
+	go func() {
+		_, err := zr.ReadContext(ctx, make([]byte, len(data)))
+		ch <- err
+	}()
+	<-r.started
+	cancel()
+	if err := <-ch; err != context.Canceled {
+		t.Fatalf("unexpected error; got %v; want %v", err, context.Canceled)
+	}
+
+	// The abandoned Read mustn't affect reading from the new source.
+	zr.Reset(bytes.NewReader(cd), nil)
+	close(r.unblock)
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read data after Reset: %s", err)
+	}
+	if string(plainData) != data {
+		t.Fatalf("unexpected data read after Reset")
+	}
+}
+
+func TestReaderReadContextSpinningReader(t *testing.T) {
+	// The underlying reader never makes progress, but doesn't block either.
+	zr := NewReader(spinningReader{})
+	defer zr.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() {
+		_, err := zr.ReadContext(ctx, make([]byte, 10))
+		ch <- err
+	}()
+	select {
+	case err := <-ch:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("unexpected error; got %v; want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+type stallingReader struct {
+	b       []byte
+	unblock chan struct{}
+}
+
+func (sr *stallingReader) Read(p []byte) (int, error) {
+	if len(sr.b) == 0 {
+		<-sr.unblock
+		return 0, io.EOF
+	}
+	n := copy(p, sr.b)
+	sr.b = sr.b[n:]
+	return n, nil
+}
+
+// slowReader blocks in the first Read until unblock is closed.
+type slowReader struct {
+	b       []byte
+	started chan struct{}
+	unblock chan struct{}
+	blocked bool
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	if !sr.blocked {
+		sr.blocked = true
+		close(sr.started)
+		<-sr.unblock
+	}
+	if len(sr.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, sr.b)
+	sr.b = sr.b[n:]
+	return n, nil
+}
+
+type spinningReader struct{}
+
+func (spinningReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+// growingReader returns io.EOF at the end of the data written so far.
+type growingReader struct {
+	mu  sync.Mutex
+	b   []byte
+	pos int
+}
+
+func (gr *growingReader) Write(p []byte) (int, error) {
+	gr.mu.Lock()
+	gr.b = append(gr.b, p...)
+	gr.mu.Unlock()
+	return len(p), nil
+}
+
+func (gr *growingReader) Read(p []byte) (int, error) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	if gr.pos == len(gr.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, gr.b[gr.pos:])
+	gr.pos += n
+	return n, nil
+}
+
+func TestReaderFollow(t *testing.T) {
+	src := []byte(newTestString(1024*1024, 3))
+	cd := Compress(nil, src)
+	half := len(cd) / 2
+
+	// Without follow mode the Reader returns io.EOF at the current end
+	// of the stream and resumes after more data is written.
+	gr := &growingReader{}
+	gr.Write(cd[:half])
+	zr := NewReader(gr)
+	defer zr.Release()
+	firstHalf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read the first half: %s", err)
+	}
+	if len(firstHalf) == 0 || len(firstHalf) >= len(src) {
+		t.Fatalf("unexpected length of data decompressed from the first half; got %d; want (0..%d)", len(firstHalf), len(src))
+	}
+	if !bytes.Equal(firstHalf, src[:len(firstHalf)]) {
+		t.Fatalf("unexpected data decompressed from the first half")
+	}
+	gr.Write(cd[half:])
+	secondHalf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot read the second half: %s", err)
+	}
+	if !bytes.Equal(append(firstHalf, secondHalf...), src) {
+		t.Fatalf("unexpected data decompressed after the second half")
+	}
+
+	// In follow mode the Reader waits for more data.
+	gr = &growingReader{}
+	gr.Write(cd[:half])
+	zr.Reset(gr, nil)
+	zr.SetFollowInterval(time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		gr.Write(cd[half:])
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	buf := make([]byte, len(src))
+	for n := 0; n < len(buf); {
+		m, err := zr.ReadContext(ctx, buf[n:])
+		if err != nil {
+			t.Fatalf("cannot read data in follow mode after reading %d bytes: %s", n, err)
+		}
+		n += m
+	}
+	if !bytes.Equal(buf, src) {
+		t.Fatalf("unexpected data decompressed in follow mode")
+	}
+
+	// io.EOF isn't returned in follow mode at the end of the stream.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := zr.ReadContext(ctx, buf); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error; got %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
 func TestReaderInvalidData(t *testing.T) {
 	// Try decompressing invalid data.
 	src := []byte("invalid compressed data")
@@ -317,3 +930,157 @@ func testReaderExt(zr *Reader, s string) error {
 	}
 	return nil
 }
+
+func TestReaderExpectDictID(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample #%d, rand num %d", i, i*i)))
+	}
+	var cds []*CDict
+	var dds []*DDict
+	for i := 0; i < 2; i++ {
+		dict := BuildDict(samples[i*500:(i+1)*500], 4*1024)
+		cd, err := NewCDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create CDict: %s", err)
+		}
+		defer cd.Release()
+		cds = append(cds, cd)
+		dd, err := NewDDict(dict)
+		if err != nil {
+			t.Fatalf("cannot create DDict: %s", err)
+		}
+		defer dd.Release()
+		dds = append(dds, dd)
+	}
+	if dds[0].ID() == dds[1].ID() {
+		t.Fatalf("dicts must have distinct IDs")
+	}
+
+	src := []byte(newTestString(100*1024, 3))
+	skippableFrame := []byte{0x50, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'f', 'o', 'o'}
+
+	f := func(cd []byte, dd *DDict, expectedDictID uint32, expectedData []byte) {
+		t.Helper()
+		zr := NewReaderDict(bytes.NewReader(cd), dd)
+		defer zr.Release()
+		zr.ExpectDictID(expectedDictID)
+		plainData, err := ioutil.ReadAll(zr)
+		if expectedData == nil {
+			if err == nil {
+				t.Fatalf("expecting non-nil error for dict ID other than %d", expectedDictID)
+			}
+			if !strings.Contains(err.Error(), "unexpected dictionary ID") {
+				t.Fatalf("unexpected error; got %q; want dictionary ID mismatch error", err)
+			}
+			if len(plainData) > len(src) {
+				t.Fatalf("the frame with unexpected dictionary ID mustn't be decompressed")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(plainData, expectedData) {
+			t.Fatalf("unexpected decompressed data")
+		}
+	}
+
+	cd0 := CompressDict(nil, src, cds[0])
+	cd1 := CompressDict(nil, src, cds[1])
+	cdNoDict := Compress(nil, src)
+	srcTwice := append(append([]byte{}, src...), src...)
+
+	// The expected dict ID.
+	f(cd0, dds[0], dds[0].ID(), src)
+	f(append(append(append([]byte{}, cd0...), skippableFrame...), cd0...), dds[0], dds[0].ID(), srcTwice)
+	f(cdNoDict, nil, 0, src)
+
+	// Unexpected dict ID.
+	f(cd1, dds[1], dds[0].ID(), nil)
+	f(cdNoDict, dds[0], dds[0].ID(), nil)
+	f(cd0, dds[0], 0, nil)
+
+	// The second frame has unexpected dict ID.
+	f(append(append([]byte{}, cd0...), cd1...), dds[0], dds[0].ID(), nil)
+	f(append(append(append([]byte{}, cd0...), skippableFrame...), cdNoDict...), dds[0], dds[0].ID(), nil)
+
+	// The frame header is read in small chunks.
+	zr := NewReaderDict(iotest.OneByteReader(bytes.NewReader(cd1)), dds[1])
+	defer zr.Release()
+	zr.ExpectDictID(dds[0].ID())
+	if _, err := ioutil.ReadAll(zr); err == nil || !strings.Contains(err.Error(), "unexpected dictionary ID") {
+		t.Fatalf("unexpected error for the frame read in small chunks; got %v; want dictionary ID mismatch error", err)
+	}
+	zr.Reset(iotest.OneByteReader(bytes.NewReader(cd1)), dds[1])
+	zr.ExpectDictID(dds[1].ID())
+	plainData, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error for the frame read in small chunks: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected data decompressed from small chunks")
+	}
+
+	// Reset disables the check.
+	zr.Reset(bytes.NewReader(cd1), dds[1])
+	zr.ExpectDictID(dds[0].ID())
+	defer zr.Release()
+	zr.Reset(bytes.NewReader(cd1), dds[1])
+	plainData, err = ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error after Reset: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed data after Reset")
+	}
+}
+
+func TestNewAutoReader(t *testing.T) {
+	f := func(data, plainDataExpected []byte) {
+		t.Helper()
+		for _, readerName := range []string{"bytes", "onebyte"} {
+			var r io.Reader = bytes.NewReader(data)
+			if readerName == "onebyte" {
+				r = iotest.OneByteReader(r)
+			}
+			plainData, err := ioutil.ReadAll(NewAutoReader(r))
+			if err != nil {
+				t.Fatalf("unexpected error for %s reader: %s", readerName, err)
+			}
+			if !bytes.Equal(plainData, plainDataExpected) {
+				t.Fatalf("unexpected data for %s reader; len(got)=%d; len(want)=%d", readerName, len(plainData), len(plainDataExpected))
+			}
+		}
+	}
+
+	// Plain data must be passed through as is.
+	for _, s := range []string{"", "f", "foo", "foob", "foobar", newTestString(100*1024, 3)} {
+		f([]byte(s), []byte(s))
+	}
+
+	// Compressed data must be decompressed.
+	src := []byte(newTestString(300*1024, 3))
+	f(Compress(nil, src), src)
+	f(mustCompressStream(t, src), src)
+	f(CompressPadded(nil, src, DefaultCompressionLevel, 4096), src)
+
+	// Invalid compressed data.
+	data := Compress(nil, src)
+	data = data[:len(data)/2]
+	ar := NewAutoReader(bytes.NewReader(data))
+	if _, err := ioutil.ReadAll(ar); err == nil {
+		t.Fatalf("expecting non-nil error for truncated compressed data")
+	}
+	// The error must be returned on subsequent reads.
+	if _, err := ar.Read(make([]byte, 10)); err == nil {
+		t.Fatalf("expecting non-nil error on the read after the error")
+	}
+
+	// Read error.
+	errRead := fmt.Errorf("read error")
+	ar = NewAutoReader(iotest.ErrReader(errRead))
+	if _, err := ar.Read(make([]byte, 10)); err != errRead {
+		t.Fatalf("unexpected error; got %v; want %v", err, errRead)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RecordWriter writes records to an append-only log, each record
+// in a separate zstd frame.
+//
+// Every record may be decompressed independently by its offset
+// via RecordReader, while the whole log remains a valid zstd stream,
+// which may be decompressed via Reader or DecompressStream.
+//
+// RecordWriter cannot be used from concurrently running goroutines.
+type RecordWriter struct {
+	w                io.Writer
+	compressionLevel int
+	offset           int64
+	buf              []byte
+}
+
+// NewRecordWriter returns new RecordWriter, which writes records
+// compressed with the given compressionLevel to w.
+//
+// Record offsets are counted from the current position of w.
+func NewRecordWriter(w io.Writer, compressionLevel int) *RecordWriter {
+	return &RecordWriter{
+		w:                w,
+		compressionLevel: compressionLevel,
+	}
+}
+
+// Append writes record to the log in a separate frame and returns
+// the offset of the frame.
+//
+// The record may be read later via RecordReader.ReadAt at the returned offset.
+func (rw *RecordWriter) Append(record []byte) (int64, error) {
+	if len(record) == 0 {
+		// Compress returns nothing for empty src, while every record
+		// must occupy a frame.
+		rw.buf = append(rw.buf[:0], emptyFrame...)
+	} else {
+		rw.buf = CompressLevel(rw.buf[:0], record, rw.compressionLevel)
+	}
+	offset := rw.offset
+	n, err := rw.w.Write(rw.buf)
+	rw.offset += int64(n)
+	if err != nil {
+		return 0, fmt.Errorf("cannot write record at offset %d: %w", offset, err)
+	}
+	return offset, nil
+}
+
+// Offset returns the offset for the next record written to rw.
+func (rw *RecordWriter) Offset() int64 {
+	return rw.offset
+}
+
+// RecordReader reads records written by RecordWriter.
+//
+// It is safe calling ReadAt from concurrently running goroutines
+// if the underlying io.ReaderAt allows this.
+type RecordReader struct {
+	r io.ReaderAt
+}
+
+// NewRecordReader returns new RecordReader, which reads records from r.
+func NewRecordReader(r io.ReaderAt) *RecordReader {
+	return &RecordReader{
+		r: r,
+	}
+}
+
+// minRecordReadSize is the initial size of the data read by RecordReader.ReadAt.
+const minRecordReadSize = 4 * 1024
+
+// ReadAt returns the record stored in the frame at the given offset.
+//
+// The offset must be obtained from RecordWriter.Append.
+func (rr *RecordReader) ReadAt(offset int64) ([]byte, error) {
+	buf := make([]byte, minRecordReadSize)
+	for {
+		n, err := rr.r.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("cannot read record at offset %d: %w", offset, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("cannot read record at offset %d: %w", offset, io.ErrUnexpectedEOF)
+		}
+		record, _, err := DecompressFirstFrame(nil, buf[:n], nil)
+		if err == nil {
+			return record, nil
+		}
+		if !errors.Is(err, ErrTruncated) || n < len(buf) {
+			return nil, fmt.Errorf("cannot decompress record at offset %d: %w", offset, err)
+		}
+
+		// The frame doesn't fit buf. Read more data.
+		buf = make([]byte, 2*len(buf))
+	}
+}
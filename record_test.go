@@ -0,0 +1,83 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRecordWriterReader(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bigRecord := make([]byte, 100*1024)
+	r.Read(bigRecord)
+	records := [][]byte{
+		[]byte("first record"),
+		[]byte("second record"),
+		[]byte("third record"),
+		nil,
+		bigRecord,
+		[]byte(newTestString(50*1024, 3)),
+	}
+
+	var bb bytes.Buffer
+	rw := NewRecordWriter(&bb, DefaultCompressionLevel)
+	var offsets []int64
+	for i, record := range records {
+		offset, err := rw.Append(record)
+		if err != nil {
+			t.Fatalf("cannot append record #%d: %s", i, err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if n := rw.Offset(); n != int64(bb.Len()) {
+		t.Fatalf("unexpected offset after appending records; got %d; want %d", n, bb.Len())
+	}
+
+	rr := NewRecordReader(bytes.NewReader(bb.Bytes()))
+
+	// Read the middle record.
+	record, err := rr.ReadAt(offsets[1])
+	if err != nil {
+		t.Fatalf("cannot read record at offset %d: %s", offsets[1], err)
+	}
+	if string(record) != "second record" {
+		t.Fatalf("unexpected record; got %q; want %q", record, "second record")
+	}
+
+	// Read all the records in reverse order.
+	for i := len(records) - 1; i >= 0; i-- {
+		record, err := rr.ReadAt(offsets[i])
+		if err != nil {
+			t.Fatalf("cannot read record #%d at offset %d: %s", i, offsets[i], err)
+		}
+		if !bytes.Equal(record, records[i]) {
+			t.Fatalf("unexpected record #%d; got %d bytes; want %d bytes", i, len(record), len(records[i]))
+		}
+	}
+
+	// The log must be decompressible as a whole.
+	plainData, err := DecompressStream(nil, bb.Bytes(), nil)
+	if err != nil {
+		t.Fatalf("cannot decompress the log: %s", err)
+	}
+	if !bytes.Equal(plainData, bytes.Join(records, nil)) {
+		t.Fatalf("unexpected data decompressed from the log")
+	}
+
+	// Invalid offsets.
+	if _, err := rr.ReadAt(offsets[1] + 1); err == nil {
+		t.Fatalf("expecting non-nil error for invalid offset")
+	}
+	if _, err := rr.ReadAt(int64(bb.Len())); err == nil {
+		t.Fatalf("expecting non-nil error for offset at the end of the log")
+	}
+
+	// Truncated log.
+	rr = NewRecordReader(bytes.NewReader(bb.Bytes()[:offsets[5]-10]))
+	if _, err := rr.ReadAt(offsets[4]); err == nil {
+		t.Fatalf("expecting non-nil error for truncated record")
+	}
+}
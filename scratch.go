@@ -0,0 +1,105 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// #include "zstd.h"
+//
+// static unsigned long long ZSTD_getFrameContentSize_scratch_wrapper(void *src, size_t srcSize) {
+//     return ZSTD_getFrameContentSize((const void*)src, srcSize);
+// }
+import "C"
+
+// Scratch owns growable buffers for alternating Compress and Decompress calls.
+//
+// The slice returned from Compress or Decompress is valid until the next call,
+// so it may be passed to the next call as src:
+//
+//	var s gozstd.Scratch
+//	for _, block := range blocks {
+//		compressedData := s.Compress(block)
+//		plainData, err := s.Decompress(compressedData)
+//		...
+//	}
+//
+// The buffers are grown before the compression or decompression starts,
+// so the returned slices never alias the data passed to the call.
+// Copy the returned data if it must outlive the next call.
+//
+// Scratch cannot be used from concurrently running goroutines.
+type Scratch struct {
+	// CompressionLevel is the compression level used by Compress.
+	//
+	// Special value 0 means DefaultCompressionLevel.
+	CompressionLevel int
+
+	bufs [2][]byte
+	next int
+}
+
+// Compress compresses src and returns the result.
+//
+// The result is valid until the next call to s.
+func (s *Scratch) Compress(src []byte) []byte {
+	compressionLevel := s.CompressionLevel
+	if compressionLevel == 0 {
+		compressionLevel = DefaultCompressionLevel
+	}
+	dst := s.buf(CompressBoundCached(len(src)))
+	cctx := cctxPool.Get().(*cctxWrapper)
+	// dst has enough capacity for the compressed data,
+	// so compress doesn't re-allocate it.
+	dst = compress(cctx, nil, dst, src, nil, compressionLevel, false)
+	cctxPool.Put(cctx)
+	return s.done(dst)
+}
+
+// Decompress decompresses src and returns the result.
+//
+// The result is valid until the next call to s.
+func (s *Scratch) Decompress(src []byte) ([]byte, error) {
+	sizeHint := 0
+	if len(src) > 0 {
+		srcHdr := (*reflect.SliceHeader)(noescape(unsafe.Pointer(&src)))
+		contentSize := C.ZSTD_getFrameContentSize_scratch_wrapper(unsafe.Pointer(srcHdr.Data), C.size_t(len(src)))
+		runtime.KeepAlive(src)
+		if contentSize < C.ZSTD_CONTENTSIZE_ERROR && uint64(contentSize) <= getMaxDirectDecompressSize() {
+			// Allocate the buffer upfront, so the decompression goes via the fast path
+			// without re-allocating the buffer.
+			sizeHint = int(contentSize) + 1
+		}
+	}
+	dst := s.buf(sizeHint)
+	dst, err := DecompressDict(dst, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.done(dst), nil
+}
+
+// buf returns an empty buffer with at least n bytes capacity,
+// which doesn't hold the result of the previous call.
+func (s *Scratch) buf(n int) []byte {
+	b := s.bufs[s.next][:0]
+	if cap(b) < n {
+		b = make([]byte, 0, n)
+		s.bufs[s.next] = b
+	}
+	return b
+}
+
+// done stores dst for re-use and switches to the other buffer,
+// so dst may be passed to the next call.
+func (s *Scratch) done(dst []byte) []byte {
+	if cap(dst) > cap(s.bufs[s.next]) {
+		s.bufs[s.next] = dst[:0]
+	}
+	s.next ^= 1
+	return dst
+}
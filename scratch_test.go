@@ -0,0 +1,108 @@
+//go:build cgo
+// +build cgo
+
+package gozstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestScratch(t *testing.T) {
+	var s Scratch
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		src := []byte(newTestString(r.Intn(200*1024), 3))
+		srcCopy := append([]byte{}, src...)
+
+		compressedData := s.Compress(src)
+		compressedCopy := append([]byte{}, compressedData...)
+		plainData, err := s.Decompress(compressedData)
+		if err != nil {
+			t.Fatalf("iteration %d: cannot decompress data: %s", i, err)
+		}
+		if !bytes.Equal(plainData, srcCopy) {
+			t.Fatalf("iteration %d: unexpected decompressed data", i)
+		}
+		if !bytes.Equal(src, srcCopy) {
+			t.Fatalf("iteration %d: src must remain unchanged", i)
+		}
+		if !bytes.Equal(compressedData, compressedCopy) {
+			t.Fatalf("iteration %d: compressed data must remain valid during the next call", i)
+		}
+
+		// Re-compress the decompressed data in the transcoding manner.
+		recompressedData := s.Compress(plainData)
+		if !bytes.Equal(plainData, srcCopy) {
+			t.Fatalf("iteration %d: decompressed data must remain valid during the next call", i)
+		}
+		plainData, err = s.Decompress(recompressedData)
+		if err != nil {
+			t.Fatalf("iteration %d: cannot decompress re-compressed data: %s", i, err)
+		}
+		if !bytes.Equal(plainData, srcCopy) {
+			t.Fatalf("iteration %d: unexpected data after re-compression", i)
+		}
+	}
+}
+
+func TestScratchNoAllocs(t *testing.T) {
+	src := []byte(newTestString(64*1024, 3))
+	var s Scratch
+	allocs := testing.AllocsPerRun(100, func() {
+		compressedData := s.Compress(src)
+		if _, err := s.Decompress(compressedData); err != nil {
+			panic(fmt.Errorf("cannot decompress data: %w", err))
+		}
+	})
+	if allocs > 0 {
+		t.Fatalf("unexpected memory allocations; got %v; want 0", allocs)
+	}
+}
+
+func TestScratchError(t *testing.T) {
+	var s Scratch
+	src := []byte("foobar")
+	compressedData := s.Compress(src)
+	if _, err := s.Decompress([]byte("invalid compressed data")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid data")
+	}
+
+	// The previous result must remain valid after the error.
+	plainData, err := s.Decompress(compressedData)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if string(plainData) != string(src) {
+		t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, src)
+	}
+}
+
+func TestScratchConcurrent(t *testing.T) {
+	// Every goroutine uses its own Scratch.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var s Scratch
+			s.CompressionLevel = n
+			for j := 0; j < 100; j++ {
+				src := []byte(fmt.Sprintf("goroutine %d, iteration %d, %s", n, j, newTestString(j*100, 3)))
+				plainData, err := s.Decompress(s.Compress(src))
+				if err != nil {
+					t.Errorf("cannot decompress data: %s", err)
+					return
+				}
+				if !bytes.Equal(plainData, src) {
+					t.Errorf("unexpected decompressed data")
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
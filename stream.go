@@ -1,7 +1,13 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"runtime"
 	"sync"
 )
 
@@ -32,6 +38,76 @@ func StreamCompressDict(dst io.Writer, src io.Reader, cd *CDict) error {
 	return streamCompressDictLevel(dst, src, cd, 0)
 }
 
+// CompressChunked reads data from r in chunks of up to chunkSize bytes,
+// compresses each chunk into a separate frame using the given compressionLevel
+// and writes the frames to w until r returns io.EOF.
+//
+// This bounds the memory needed for compressing and decompressing each frame,
+// and allows random access to the compressed data by frames later.
+// The output may be decompressed with Reader or StreamDecompress.
+// The number of compressed bytes written to w is returned.
+func CompressChunked(w io.Writer, r io.Reader, chunkSize, compressionLevel int) (int64, error) {
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("chunkSize must be positive; got %d", chunkSize)
+	}
+
+	chunk := make([]byte, chunkSize)
+	var frame []byte
+	var written int64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			frame = CompressPooled(frame[:0], chunk[:n], compressionLevel)
+			m, werr := w.Write(frame)
+			written += int64(m)
+			if werr != nil {
+				return written, fmt.Errorf("cannot write compressed frame: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("cannot read data: %w", err)
+		}
+	}
+}
+
+// CompressResponse compresses src with the given compressionLevel
+// and writes the compressed data to w in chunks as it is produced.
+//
+// It returns the number of compressed bytes successfully written to w.
+// Write errors from w are returned as soon as they occur, so a client
+// disconnect may be detected in the middle of a response.
+// The returned error wraps the error from w.
+func CompressResponse(w io.Writer, src []byte, compressionLevel int) (int, error) {
+	cw := &countingWriter{
+		w: w,
+	}
+	sc := getSCompressor(compressionLevel)
+	sc.zw.Reset(cw, nil, compressionLevel)
+	_, err := sc.zw.Write(src)
+	if err == nil {
+		err = sc.zw.Close()
+	}
+	putSCompressor(sc)
+	if err != nil {
+		return cw.n, fmt.Errorf("cannot write compressed response: %w", err)
+	}
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
 func streamCompressDictLevel(dst io.Writer, src io.Reader, cd *CDict, compressionLevel int) error {
 	sc := getSCompressor(compressionLevel)
 	sc.zw.Reset(dst, cd, compressionLevel)
@@ -43,6 +119,76 @@ func streamCompressDictLevel(dst io.Writer, src io.Reader, cd *CDict, compressio
 	return err
 }
 
+// CompressWriter is a streaming compressor for short-lived streams.
+//
+// Unlike Writer, it obtains the compression context from a pool
+// on creation and returns it to the pool on Close.
+// Close must be called after writing all the data.
+// Forgetting to call Close leaks the compression context until
+// the CompressWriter is garbage collected.
+//
+// CompressWriter cannot be used from concurrently running goroutines.
+type CompressWriter struct {
+	sc *sCompressor
+}
+
+// NewCompressWriter returns new CompressWriter writing compressed data
+// to w using the given compressionLevel.
+//
+// Call Close when all the data is written.
+func NewCompressWriter(w io.Writer, compressionLevel int) *CompressWriter {
+	sc := getSCompressor(compressionLevel)
+	sc.zw.Reset(w, nil, compressionLevel)
+	cw := &CompressWriter{
+		sc: sc,
+	}
+	runtime.SetFinalizer(cw, freeCompressWriter)
+	return cw
+}
+
+func freeCompressWriter(cw *CompressWriter) {
+	if cw.sc == nil {
+		return
+	}
+	// The context may be in the middle of a frame, so release it
+	// instead of returning to the pool.
+	cw.sc.zw.Release()
+	cw.sc = nil
+}
+
+// Write writes p to cw.
+func (cw *CompressWriter) Write(p []byte) (int, error) {
+	if cw.sc == nil {
+		return 0, errCompressWriterClosed
+	}
+	return cw.sc.zw.Write(p)
+}
+
+// Flush flushes the compressed data written so far to the underlying writer.
+func (cw *CompressWriter) Flush() error {
+	if cw.sc == nil {
+		return errCompressWriterClosed
+	}
+	return cw.sc.zw.Flush()
+}
+
+// Close finalizes the compressed stream and returns the compression context
+// to the pool.
+//
+// cw cannot be used after Close. Subsequent Close calls are no-op.
+func (cw *CompressWriter) Close() error {
+	if cw.sc == nil {
+		return nil
+	}
+	err := cw.sc.zw.Close()
+	putSCompressor(cw.sc)
+	cw.sc = nil
+	runtime.SetFinalizer(cw, nil)
+	return err
+}
+
+var errCompressWriterClosed = errors.New("CompressWriter is already closed")
+
 type sCompressor struct {
 	zw               *Writer
 	compressionLevel int
@@ -106,6 +252,51 @@ func StreamDecompressDict(dst io.Writer, src io.Reader, dd *DDict) error {
 	return err
 }
 
+// ErrStreamLimitExceeded is returned by DecompressStreamLimit when
+// the decompressed data exceeds the given limit.
+var ErrStreamLimitExceeded = errors.New("decompressed data exceeds the limit")
+
+// DecompressStreamLimit decompresses src into dst, writing at most maxBytes
+// to dst.
+//
+// ErrStreamLimitExceeded is returned as soon as the decompressed data
+// exceeds maxBytes regardless of the content size declared in frame headers.
+// This protects from decompression bombs in untrusted src.
+// The number of bytes written to dst is returned on both success and error.
+func DecompressStreamLimit(dst io.Writer, src io.Reader, maxBytes int64) (int64, error) {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	sd := getSDecompressor()
+	sd.zr.Reset(src, nil)
+	lw := &limitedWriter{
+		w: dst,
+		n: maxBytes,
+	}
+	n, err := sd.zr.WriteTo(lw)
+	putSDecompressor(sd)
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= lw.n {
+		n, err := lw.w.Write(p)
+		lw.n -= int64(n)
+		return n, err
+	}
+	n, err := lw.w.Write(p[:lw.n])
+	lw.n -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, ErrStreamLimitExceeded
+}
+
 type sDecompressor struct {
 	zr *Reader
 }
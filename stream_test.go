@@ -1,8 +1,16 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -62,6 +70,119 @@ func testStreamCompressDecompressSerial(data string) error {
 	return nil
 }
 
+func TestCompressWriter(t *testing.T) {
+	data := []byte(newTestString(10000, 3))
+
+	var bb bytes.Buffer
+	cw := NewCompressWriter(&bb, 5)
+	if _, err := cw.Write(data[:5000]); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("unexpected error in Flush: %s", err)
+	}
+	if _, err := cw.Write(data[5000:]); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+	}
+	if _, err := cw.Write(data); err == nil {
+		t.Fatalf("expecting non-nil error in Write after Close")
+	}
+	if err := cw.Flush(); err == nil {
+		t.Fatalf("expecting non-nil error in Flush after Close")
+	}
+	plainData, err := Decompress(nil, bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// Compression contexts must be re-used after Close.
+	liveStart := atomic.LoadInt64(&liveCStreams)
+	for i := 0; i < 1000; i++ {
+		bb.Reset()
+		cw := NewCompressWriter(&bb, 5)
+		if _, err := cw.Write(data[:100]); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+	}
+	n := atomic.LoadInt64(&liveCStreams) - liveStart
+	if raceEnabled {
+		// sync.Pool drops items at random under race detector, so the dropped
+		// compression contexts are released only by finalizers.
+		for i := 0; i < 100 && n > 10; i++ {
+			runtime.GC()
+			time.Sleep(10 * time.Millisecond)
+			n = atomic.LoadInt64(&liveCStreams) - liveStart
+		}
+	}
+	if n > 10 {
+		t.Fatalf("too many live compression contexts after Close; got %d; want no more than 10", n)
+	}
+}
+
+func TestCompressChunked(t *testing.T) {
+	const chunkSize = 10000
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5*chunkSize + 123} {
+		data := []byte(newTestString(size, 3))
+
+		var bb bytes.Buffer
+		n, err := CompressChunked(&bb, bytes.NewReader(data), chunkSize, 5)
+		if err != nil {
+			t.Fatalf("unexpected error for size=%d: %s", size, err)
+		}
+		if n != int64(bb.Len()) {
+			t.Fatalf("unexpected number of bytes written for size=%d; got %d; want %d", size, n, bb.Len())
+		}
+
+		// Verify frame boundaries.
+		zr := NewReader(&bb)
+		var plainData []byte
+		var frameEnds []int
+		zr.SetFrameEndCallback(func() {
+			frameEnds = append(frameEnds, len(plainData))
+		})
+		buf := make([]byte, 1000)
+		for {
+			n, err := zr.Read(buf)
+			plainData = append(plainData, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("cannot decompress data for size=%d: %s", size, err)
+			}
+		}
+		zr.Release()
+		if !bytes.Equal(plainData, data) {
+			t.Fatalf("unexpected decompressed data for size=%d", size)
+		}
+		var frameEndsExpected []int
+		for i := chunkSize; i < size+chunkSize; i += chunkSize {
+			if i > size {
+				i = size
+			}
+			frameEndsExpected = append(frameEndsExpected, i)
+		}
+		if fmt.Sprint(frameEnds) != fmt.Sprint(frameEndsExpected) {
+			t.Fatalf("unexpected frame ends for size=%d; got %v; want %v", size, frameEnds, frameEndsExpected)
+		}
+	}
+
+	if _, err := CompressChunked(ioutil.Discard, bytes.NewReader(nil), 0, 5); err == nil {
+		t.Fatalf("expecting non-nil error for zero chunkSize")
+	}
+}
+
 func TestStreamCompressDecompressLevel(t *testing.T) {
 	for level := 0; level < 20; level++ {
 		t.Run(fmt.Sprintf("level_%d", level), func(t *testing.T) {
@@ -121,6 +242,41 @@ func testStreamCompressDecompressLevelSerial(data string, level int) error {
 	return nil
 }
 
+func TestDecompressStreamLimit(t *testing.T) {
+	// Compression bomb - highly compressible data expanding past the limit.
+	bomb := Compress(nil, make([]byte, 10*1024*1024))
+
+	var bb bytes.Buffer
+	maxBytes := int64(1024*1024 + 123)
+	n, err := DecompressStreamLimit(&bb, bytes.NewReader(bomb), maxBytes)
+	if err != ErrStreamLimitExceeded {
+		t.Fatalf("unexpected error; got %v; want %v", err, ErrStreamLimitExceeded)
+	}
+	if n != maxBytes {
+		t.Fatalf("unexpected number of bytes written; got %d; want %d", n, maxBytes)
+	}
+	if int64(bb.Len()) != maxBytes {
+		t.Fatalf("unexpected number of bytes in dst; got %d; want %d", bb.Len(), maxBytes)
+	}
+
+	// Data fitting the limit.
+	data := newTestString(100*1024, 20)
+	cd := Compress(nil, []byte(data))
+	for _, maxBytes := range []int64{int64(len(data)), int64(len(data)) + 1} {
+		bb.Reset()
+		n, err = DecompressStreamLimit(&bb, bytes.NewReader(cd), maxBytes)
+		if err != nil {
+			t.Fatalf("unexpected error for maxBytes=%d: %s", maxBytes, err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("unexpected number of bytes written; got %d; want %d", n, len(data))
+		}
+		if bb.String() != data {
+			t.Fatalf("unexpected data decompressed")
+		}
+	}
+}
+
 func TestStreamCompressDecompressDict(t *testing.T) {
 	var samples [][]byte
 	for i := 0; i < 1000; i++ {
@@ -188,3 +344,70 @@ func testStreamCompressDecompressDictSerial(cd *CDict, dd *DDict, data []byte) e
 	}
 	return nil
 }
+
+func TestCompressResponse(t *testing.T) {
+	src := []byte(newTestString(4*1024*1024, 3))
+
+	var bb bytes.Buffer
+	n, err := CompressResponse(&bb, src, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != bb.Len() {
+		t.Fatalf("unexpected number of bytes written; got %d; want %d", n, bb.Len())
+	}
+	plainData, err := Decompress(nil, bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress response: %s", err)
+	}
+	if !bytes.Equal(plainData, src) {
+		t.Fatalf("unexpected decompressed response")
+	}
+
+	// The writer fails in the middle of the response.
+	for _, limit := range []int{0, 1, 1000, bb.Len() / 2, bb.Len() - 1} {
+		fw := &failingWriter{
+			limit: limit,
+		}
+		n, err := CompressResponse(fw, src, 5)
+		if !errors.Is(err, errFailingWriter) {
+			t.Fatalf("unexpected error for limit=%d; got %v; want %v", limit, err, errFailingWriter)
+		}
+		if n != limit {
+			t.Fatalf("unexpected number of bytes written for limit=%d; got %d", limit, n)
+		}
+		if !bytes.Equal(fw.bb.Bytes(), bb.Bytes()[:limit]) {
+			t.Fatalf("unexpected data written for limit=%d", limit)
+		}
+	}
+
+	// Make sure the pooled compressor works after the write error.
+	bb.Reset()
+	if _, err := CompressResponse(&bb, src[:1000], 5); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	plainData, err = Decompress(nil, bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress response: %s", err)
+	}
+	if !bytes.Equal(plainData, src[:1000]) {
+		t.Fatalf("unexpected decompressed response")
+	}
+}
+
+var errFailingWriter = errors.New("client disconnected")
+
+// failingWriter accepts up to limit bytes and then fails.
+type failingWriter struct {
+	bb    bytes.Buffer
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	n := fw.limit - fw.bb.Len()
+	if n >= len(p) {
+		return fw.bb.Write(p)
+	}
+	fw.bb.Write(p[:n])
+	return n, errFailingWriter
+}
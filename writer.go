@@ -21,6 +21,14 @@ static size_t ZSTD_CCtx_setParameter_wrapper(void *cs, ZSTD_cParameter param, in
     return ZSTD_CCtx_setParameter((ZSTD_CStream*)cs, param, value);
 }
 
+static size_t ZSTD_CCtx_setPledgedSrcSize_wrapper(void *cs, unsigned long long pledgedSrcSize) {
+    return ZSTD_CCtx_setPledgedSrcSize((ZSTD_CStream*)cs, pledgedSrcSize);
+}
+
+static size_t ZSTD_CCtx_resetSession_wrapper(void *cs) {
+    return ZSTD_CCtx_reset((ZSTD_CStream*)cs, ZSTD_reset_session_only);
+}
+
 static size_t ZSTD_initCStream_wrapper(void *cs, int compressionLevel) {
     return ZSTD_initCStream((ZSTD_CStream*)cs, compressionLevel);
 }
@@ -37,19 +45,6 @@ static size_t ZSTD_compressStream_wrapper(void *cs, void* dst, const void* src,
 	return ZSTD_compressStream2_simpleArgs((ZSTD_CStream*)cs, dst, sizes->dstSize, &sizes->dstPos, src, sizes->srcSize, &sizes->srcPos, endOp);
 }
 
-static size_t ZSTD_flushStream_wrapper(void *cs, void *dst, ZSTD_EXT_BufferSizes* sizes) {
-	size_t res;
-	ZSTD_outBuffer outBuf;
-
-	outBuf.dst = dst;
-	outBuf.size = sizes->dstSize;
-	outBuf.pos = sizes->dstPos;
-
-	res = ZSTD_flushStream((ZSTD_CStream*)cs, &outBuf);
-	sizes->dstPos = outBuf.pos;
-	return res;
-}
-
 static size_t ZSTD_endStream_wrapper(void *cs, void *dst, ZSTD_EXT_BufferSizes* sizes) {
 	size_t res;
 	ZSTD_outBuffer outBuf;
@@ -72,6 +67,7 @@ import (
 	"io"
 	"reflect"
 	"runtime"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -94,8 +90,60 @@ type Writer struct {
 	inBuf  []byte
 	outBuf []byte
 	sizes  C.ZSTD_EXT_BufferSizes
+
+	// closed is set by Close until new data is written to zw.
+	closed bool
+
+	// closeErr is the error returned by the last Close call.
+	// It is returned by subsequent Close calls while closed is set.
+	closeErr error
+
+	stableIn  bool
+	stableOut bool
+
+	// stableInBuf holds the data written to zw in the current frame
+	// when StableInBuffer is set. stableInPos is the position in stableInBuf
+	// reported by the compressor.
+	stableInBuf []byte
+	stableInPos int
+
+	// outBufFlushed is the number of bytes at the start of outBuf, which
+	// were already written to w. It may be non-zero only when StableOutBuffer
+	// is set, since outBuf cannot be moved until the end of the frame.
+	outBufFlushed int
+
+	// pledged is set by SetPledgedSrcSize until the end of the frame.
+	// pledgedSize is the size passed to SetPledgedSrcSize.
+	pledged     bool
+	pledgedSize uint64
+
+	closeUnderlying bool
+
+	// maxFrameSize is the limit on the compressed frame size set by
+	// SetMaxFrameCompressedSize. frameSize is the number of compressed bytes
+	// produced for the current frame, while frameInSize is the number of bytes
+	// passed to the compressor for the current frame.
+	maxFrameSize int
+	frameSize    int
+	frameInSize  int
+
+	// frameSplit is set when the frame is ended due to maxFrameSize
+	// until new data is passed to the compressor.
+	frameSplit bool
+
+	// buffered is set by SetBuffered. bufferedData holds the data written
+	// to zw in the current frame when buffered is set.
+	buffered     bool
+	bufferedData []byte
+
+	// inputSize and outputSize are the number of bytes written to zw
+	// and the number of compressed bytes written to w since the last Reset.
+	inputSize  int64
+	outputSize int64
 }
 
+var _ io.WriteCloser = (*Writer)(nil)
+
 // NewWriter returns new zstd writer writing compressed data to w.
 //
 // The returned writer must be closed with Close call in order
@@ -123,6 +171,11 @@ func NewWriterLevel(w io.Writer, compressionLevel int) *Writer {
 // NewWriterDict returns new zstd writer writing compressed data to w
 // using the given cd.
 //
+// cd is bound to the writer once, so all the data written until Close
+// is compressed against cd, including the data written after Flush calls,
+// since Flush doesn't end the frame. The frames started after Close use cd
+// too. cd mustn't be released while the writer is used.
+//
 // The returned writer must be closed with Close call in order
 // to finalize the compressed stream.
 //
@@ -165,6 +218,28 @@ type WriterParams struct {
 
 	// Dict is optional dictionary used for compression.
 	Dict *CDict
+
+	// StableInBuffer allows the compressor to reference the data passed
+	// to Write instead of copying it into internal buffer.
+	//
+	// The caller must guarantee that consecutive Write calls within a frame
+	// pass adjacent chunks of the same buffer, i.e. buf[:n], buf[n:m], etc.,
+	// and that the buffer isn't modified until Close returns.
+	// Write returns an error if the chunk doesn't follow the previously
+	// written one, while modifications of the already written data cannot
+	// be detected and result in corrupted output.
+	// ReadFrom and WriteByte aren't supported when StableInBuffer is set.
+	StableInBuffer bool
+
+	// StableOutBuffer allows the compressor to write compressed data directly
+	// into the output buffer instead of copying it from internal buffer.
+	//
+	// The output buffer cannot be moved until the end of the frame,
+	// so the whole compressed frame is held in memory until Close.
+	// SetPledgedSrcSize must be called before writing data to the Writer,
+	// since it determines the output buffer size. Writes exceeding
+	// the pledged size are rejected with an error.
+	StableOutBuffer bool
 }
 
 // NewWriterParams returns new zstd writer writing compressed data to w
@@ -180,6 +255,7 @@ func NewWriterParams(w io.Writer, params *WriterParams) *Writer {
 	}
 
 	cs := C.ZSTD_createCStream()
+	atomic.AddInt64(&liveCStreams, 1)
 	initCStream(cs, *params)
 
 	inBufWrapper := compInBufPool.Get().(*bytes.Buffer)
@@ -191,6 +267,8 @@ func NewWriterParams(w io.Writer, params *WriterParams) *Writer {
 		wlog:             params.WindowLog,
 		cs:               cs,
 		cd:               params.Dict,
+		stableIn:         params.StableInBuffer,
+		stableOut:        params.StableOutBuffer,
 		inBufWrapper:     inBufWrapper,
 		outBufWrapper:    outBufWrapper,
 		inBuf:            inBufWrapper.Bytes(),
@@ -209,42 +287,226 @@ func (zw *Writer) Reset(w io.Writer, cd *CDict, compressionLevel int) {
 		CompressionLevel: compressionLevel,
 		WindowLog:        zw.wlog,
 		Dict:             cd,
+		StableInBuffer:   zw.stableIn,
+		StableOutBuffer:  zw.stableOut,
 	}
 	zw.ResetWriterParams(w, &params)
 }
 
 // ResetWriterParams resets zw to write to w using the given set of parameters.
 func (zw *Writer) ResetWriterParams(w io.Writer, params *WriterParams) {
+	if len(zw.stableInBuf) > 0 {
+		// zstd doesn't forget the input buffer of the aborted frame
+		// with StableInBuffer on session reset, so the next frame would fail
+		// the buffer stability check. Re-create the stream in this case.
+		result := C.ZSTD_freeCStream_wrapper(unsafe.Pointer(zw.cs))
+		ensureNoError("ZSTD_freeCStream", result)
+		zw.cs = C.ZSTD_createCStream()
+	}
 	zw.inBuf = zw.inBuf[:0]
 	zw.outBuf = zw.outBuf[:0]
 	zw.sizes = C.ZSTD_EXT_BufferSizes{}
+	zw.closed = false
+	zw.closeErr = nil
+	zw.resetFrame()
+	zw.inputSize = 0
+	zw.outputSize = 0
+
+	zw.bufferedData = zw.bufferedData[:0]
 
 	zw.cd = params.Dict
+	zw.stableIn = params.StableInBuffer
+	zw.stableOut = params.StableOutBuffer
+	if zw.stableIn || zw.stableOut {
+		// The frame size limit and the buffered mode aren't supported
+		// for stable buffers.
+		zw.maxFrameSize = 0
+		zw.buffered = false
+	}
 	initCStream(zw.cs, *params)
 
 	zw.w = w
 }
 
+// SetPledgedSrcSize informs zw that exactly n bytes are going to be written
+// into the current frame.
+//
+// The size is stored in the frame header, so decompressors may allocate
+// the needed buffer upfront. It must be called before writing data to zw
+// after its creation or Reset. Close returns an error if the size of
+// the written data differs from n. The size cannot be pledged when
+// SetMaxFrameCompressedSize is set.
+func (zw *Writer) SetPledgedSrcSize(n uint64) error {
+	if len(zw.inBuf) > 0 || len(zw.stableInBuf) > 0 {
+		return fmt.Errorf("cannot set pledged src size after writing data")
+	}
+	if zw.maxFrameSize != 0 {
+		return fmt.Errorf("pledged src size cannot be set when max frame compressed size is set")
+	}
+	result := C.ZSTD_CCtx_setPledgedSrcSize_wrapper(unsafe.Pointer(zw.cs), C.ulonglong(n))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot set pledged src size: %w", newError(result))
+	}
+	if zw.stableOut {
+		// The whole frame must fit outBuf, since it cannot be moved
+		// until the end of the frame.
+		bound := C.ZSTD_compressBound(C.size_t(n))
+		if zstdIsError(bound) || uint64(bound) > uint64(maxInt) {
+			return fmt.Errorf("too big pledged src size for StableOutBuffer: %d bytes", n)
+		}
+		if cap(zw.outBuf)-len(zw.outBuf) < int(bound) {
+			outBuf := make([]byte, len(zw.outBuf), int(bound)+len(zw.outBuf))
+			copy(outBuf, zw.outBuf)
+			zw.outBuf = outBuf
+		}
+	}
+	zw.pledged = true
+	zw.pledgedSize = n
+	return nil
+}
+
+// resetFrame resets the per-frame state of zw.
+func (zw *Writer) resetFrame() {
+	zw.stableInBuf = nil
+	zw.stableInPos = 0
+	if zw.outBufFlushed > 0 {
+		zw.outBuf = zw.outBuf[:0]
+		zw.outBufFlushed = 0
+	}
+	zw.pledged = false
+	zw.frameSize = 0
+	zw.frameInSize = 0
+	zw.frameSplit = false
+}
+
+const (
+	// maxFrameHeaderSize is ZSTD_FRAMEHEADERSIZE_MAX from zstd.h.
+	maxFrameHeaderSize = 18
+
+	// maxFrameEpilogueSize is the size of the empty last block
+	// and the checksum written at the end of the frame.
+	maxFrameEpilogueSize = 3 + 4
+
+	// blockSizeMax is ZSTD_BLOCKSIZE_MAX from zstd.h.
+	blockSizeMax = 128 * 1024
+
+	// minMaxFrameCompressedSize is the minimum limit accepted
+	// by SetMaxFrameCompressedSize.
+	minMaxFrameCompressedSize = 1024
+
+	// minFrameChunkSize is the minimum chunk of data passed to the compressor
+	// before the frame is ended due to maxFrameSize.
+	minFrameChunkSize = 256
+)
+
+// SetMaxFrameCompressedSize limits the size of every compressed frame
+// written by zw to n bytes.
+//
+// zw ends the current frame and starts a new one when the compressed data
+// for the current frame could exceed n bytes. The resulting stream remains
+// a valid zstd stream, which may be decompressed as a whole, while every
+// frame may be sent as a separate message to transports with limited
+// message size.
+//
+// The data written to zw is compressed in chunks fitting the remaining
+// frame space, so the compression ratio may decrease for small n.
+// It must be called before writing data to the current frame.
+// n must be at least 1024 bytes. Special value 0 disables the limit.
+// The limit cannot be set when StableInBuffer or StableOutBuffer is set
+// or when SetPledgedSrcSize is called for the current frame.
+// The setting is preserved across Reset calls.
+func (zw *Writer) SetMaxFrameCompressedSize(n int) error {
+	if n != 0 && n < minMaxFrameCompressedSize {
+		return fmt.Errorf("too small max frame compressed size: %d bytes; it must be at least %d bytes", n, minMaxFrameCompressedSize)
+	}
+	if n != 0 && (zw.stableIn || zw.stableOut) {
+		return fmt.Errorf("max frame compressed size cannot be set when StableInBuffer or StableOutBuffer is set")
+	}
+	if n != 0 && zw.buffered {
+		return fmt.Errorf("max frame compressed size cannot be set in buffered mode")
+	}
+	if n != 0 && zw.pledged {
+		return fmt.Errorf("max frame compressed size cannot be set when pledged src size is set")
+	}
+	if len(zw.inBuf) > 0 || zw.frameInSize > 0 {
+		return fmt.Errorf("cannot set max frame compressed size after writing data to the current frame")
+	}
+	zw.maxFrameSize = n
+	return nil
+}
+
+// SetBuffered enables or disables the buffered mode for zw.
+//
+// In buffered mode zw collects all the data written to the frame until Close
+// and then compresses it into a single frame with the content size stored
+// in the frame header, like Compress does. Flush doesn't write anything
+// to the underlying writer in this mode.
+//
+// The whole frame data is held in memory until Close, and the memory isn't
+// freed until Release or SetBuffered(false) call.
+//
+// It must be called before writing data to the current frame.
+// The buffered mode cannot be enabled when StableInBuffer or StableOutBuffer
+// is set or when the max frame compressed size is set.
+// The setting is preserved across Reset calls.
+func (zw *Writer) SetBuffered(enable bool) error {
+	if enable && (zw.stableIn || zw.stableOut) {
+		return fmt.Errorf("buffered mode cannot be enabled when StableInBuffer or StableOutBuffer is set")
+	}
+	if enable && zw.maxFrameSize > 0 {
+		return fmt.Errorf("buffered mode cannot be enabled when max frame compressed size is set")
+	}
+	if len(zw.inBuf) > 0 || zw.frameInSize > 0 || len(zw.bufferedData) > 0 {
+		return fmt.Errorf("cannot change buffered mode after writing data to the current frame")
+	}
+	zw.buffered = enable
+	if !enable {
+		zw.bufferedData = nil
+	}
+	return nil
+}
+
 func initCStream(cs *C.ZSTD_CStream, params WriterParams) {
+	// Reset the session, so the size pledged for the previous frame
+	// doesn't leak into the next one.
+	result := C.ZSTD_CCtx_resetSession_wrapper(unsafe.Pointer(cs))
+	ensureNoError("ZSTD_CCtx_reset", result)
+
 	if params.Dict != nil {
-		result := C.ZSTD_CCtx_refCDict_wrapper(
+		result = C.ZSTD_CCtx_refCDict_wrapper(
 			unsafe.Pointer(cs),
 			unsafe.Pointer(params.Dict.p))
 		ensureNoError("ZSTD_CCtx_refCDict", result)
 	} else {
-		result := C.ZSTD_initCStream_wrapper(
+		result = C.ZSTD_initCStream_wrapper(
 			unsafe.Pointer(cs),
 			C.int(params.CompressionLevel))
 		ensureNoError("ZSTD_initCStream", result)
 	}
 
-	result := C.ZSTD_CCtx_setParameter_wrapper(
+	result = C.ZSTD_CCtx_setParameter_wrapper(
 		unsafe.Pointer(cs),
 		C.ZSTD_cParameter(C.ZSTD_c_windowLog),
 		C.int(params.WindowLog))
 	ensureNoError("ZSTD_CCtx_setParameter", result)
+
+	setCStreamFlag(cs, C.ZSTD_c_stableInBuffer, params.StableInBuffer)
+	setCStreamFlag(cs, C.ZSTD_c_stableOutBuffer, params.StableOutBuffer)
 }
 
+func setCStreamFlag(cs *C.ZSTD_CStream, param C.ZSTD_cParameter, flag bool) {
+	value := 0
+	if flag {
+		value = 1
+	}
+	result := C.ZSTD_CCtx_setParameter_wrapper(unsafe.Pointer(cs), param, C.int(value))
+	ensureNoError("ZSTD_CCtx_setParameter", result)
+}
+
+// liveCStreams is the number of compression streams created by NewWriter*
+// and not released yet.
+var liveCStreams int64
+
 func freeCStream(v interface{}) {
 	v.(*Writer).Release()
 }
@@ -260,9 +522,11 @@ func (zw *Writer) Release() {
 	result := C.ZSTD_freeCStream_wrapper(unsafe.Pointer(zw.cs))
 	ensureNoError("ZSTD_freeCStream", result)
 	zw.cs = nil
+	atomic.AddInt64(&liveCStreams, -1)
 
 	zw.w = nil
 	zw.cd = nil
+	zw.bufferedData = nil
 
 	if zw.inBufWrapper != nil {
 		zw.inBuf = nil
@@ -286,6 +550,15 @@ func (zw *Writer) Release() {
 // Call Flush or Close when the compressed data must propagate
 // to the underlying writer.
 func (zw *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if zw.stableIn {
+		return 0, fmt.Errorf("ReadFrom isn't supported when StableInBuffer is set")
+	}
+	if err := zw.checkPledged(0); err != nil {
+		return 0, err
+	}
+	if zw.buffered {
+		return zw.readFromBuffered(r)
+	}
 	nn := int64(0)
 	for {
 		inBuf := zw.inBuf[len(zw.inBuf):cap(zw.inBuf)]
@@ -298,6 +571,13 @@ func (zw *Writer) ReadFrom(r io.Reader) (int64, error) {
 			inBuf = inBuf[n:]
 			zw.inBuf = zw.inBuf[:len(zw.inBuf)+n]
 			nn += int64(n)
+			zw.inputSize += int64(n)
+			if n > 0 {
+				zw.closed = false
+				if err := zw.checkPledged(0); err != nil {
+					return nn, err
+				}
+			}
 
 			if err != nil {
 				if err == io.EOF {
@@ -314,6 +594,28 @@ func (zw *Writer) ReadFrom(r io.Reader) (int64, error) {
 	}
 }
 
+func (zw *Writer) readFromBuffered(r io.Reader) (int64, error) {
+	nn := int64(0)
+	for {
+		if len(zw.bufferedData) == cap(zw.bufferedData) {
+			zw.bufferedData = append(zw.bufferedData, 0)[:len(zw.bufferedData)]
+		}
+		n, err := r.Read(zw.bufferedData[len(zw.bufferedData):cap(zw.bufferedData)])
+		zw.bufferedData = zw.bufferedData[:len(zw.bufferedData)+n]
+		nn += int64(n)
+		zw.inputSize += int64(n)
+		if n > 0 {
+			zw.closed = false
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nn, nil
+			}
+			return nn, err
+		}
+	}
+}
+
 // Write writes p to zw.
 //
 // Write doesn't flush the compressed data to the underlying writer
@@ -325,10 +627,29 @@ func (zw *Writer) Write(p []byte) (int, error) {
 	if pLen == 0 {
 		return 0, nil
 	}
+	if err := zw.checkPledged(pLen); err != nil {
+		return 0, err
+	}
+	zw.closed = false
+
+	if zw.buffered {
+		zw.bufferedData = append(zw.bufferedData, p...)
+		zw.inputSize += int64(pLen)
+		return pLen, nil
+	}
+
+	if zw.stableIn {
+		if err := zw.writeStable(p); err != nil {
+			return 0, err
+		}
+		zw.inputSize += int64(pLen)
+		return pLen, nil
+	}
 
 	for {
 		n := copy(zw.inBuf[len(zw.inBuf):cap(zw.inBuf)], p)
 		zw.inBuf = zw.inBuf[:len(zw.inBuf)+n]
+		zw.inputSize += int64(n)
 		p = p[n:]
 		if len(p) == 0 {
 			// Fast path - just copy the data to input buffer.
@@ -340,21 +661,135 @@ func (zw *Writer) Write(p []byte) (int, error) {
 	}
 }
 
-func (zw *Writer) flushInBuf() error {
+// WriteByte writes a single byte c to zw.
+//
+// It implements io.ByteWriter. Small writes are batched in the input buffer,
+// so the compressor is invoked only when the buffer fills or on Flush and Close.
+//
+// WriteByte cannot be used when StableInBuffer is set.
+func (zw *Writer) WriteByte(c byte) error {
+	if len(zw.inBuf) < cap(zw.inBuf) && !zw.stableIn && !zw.stableOut && !zw.buffered {
+		// Fast path - just append c to input buffer.
+		zw.closed = false
+		zw.inBuf = append(zw.inBuf, c)
+		zw.inputSize++
+		return nil
+	}
+	return zw.writeByteSlow(c)
+}
+
+func (zw *Writer) writeByteSlow(c byte) error {
+	if zw.stableIn {
+		return fmt.Errorf("WriteByte cannot be used when StableInBuffer is set")
+	}
+	if err := zw.checkPledged(1); err != nil {
+		return err
+	}
+	zw.closed = false
+
+	if zw.buffered {
+		zw.bufferedData = append(zw.bufferedData, c)
+		zw.inputSize++
+		return nil
+	}
+
+	for len(zw.inBuf) == cap(zw.inBuf) {
+		if err := zw.flushInBuf(); err != nil {
+			return err
+		}
+	}
+	zw.inBuf = append(zw.inBuf, c)
+	zw.inputSize++
+	return nil
+}
+
+// checkPledged verifies whether n more bytes may be written to zw.
+//
+// The output buffer for StableOutBuffer is sized for the pledged src size,
+// so writing more data is rejected before it reaches the compressor.
+func (zw *Writer) checkPledged(n int) error {
+	if !zw.stableOut {
+		return nil
+	}
+	if !zw.pledged {
+		return fmt.Errorf("SetPledgedSrcSize must be called before writing data when StableOutBuffer is set")
+	}
+	frameSize := uint64(zw.frameInSize + len(zw.inBuf) + len(zw.stableInBuf))
+	if frameSize+uint64(n) > zw.pledgedSize {
+		return fmt.Errorf("cannot write %d bytes to the frame with %d bytes already written, since it exceeds the pledged src size %d bytes",
+			n, frameSize, zw.pledgedSize)
+	}
+	return nil
+}
+
+// writeStable passes p to the compressor without copying it to inBuf.
+//
+// p must directly follow the data passed to the previous writeStable call
+// in the current frame.
+func (zw *Writer) writeStable(p []byte) error {
+	n := len(zw.stableInBuf)
+	if n == 0 {
+		zw.stableInBuf = p
+	} else {
+		if cap(zw.stableInBuf)-n < len(p) || &zw.stableInBuf[:n+1][n] != &p[0] {
+			return fmt.Errorf("the written data must directly follow the previously written data in the same buffer when StableInBuffer is set")
+		}
+		zw.stableInBuf = zw.stableInBuf[:n+len(p)]
+	}
+
+	for {
+		result := zw.compressStream(zw.stableInBuf, zw.stableInPos, C.ZSTD_e_continue)
+		if zstdIsError(result) {
+			return fmt.Errorf("cannot compress data: %w", newError(result))
+		}
+		zw.stableInPos = int(zw.sizes.srcPos)
+		if zw.stableInPos == len(zw.stableInBuf) {
+			break
+		}
+
+		// outBuf is full. Flush it and continue compressing.
+		if err := zw.flushOutBuf(); err != nil {
+			return err
+		}
+	}
+
+	if cap(zw.outBuf)-len(zw.outBuf) > len(zw.outBuf)-zw.outBufFlushed {
+		// There is enough space in outBuf, so don't flush it yet.
+		return nil
+	}
+	return zw.flushOutBuf()
+}
+
+// compressStream passes src[srcPos:] to the compressor, which appends
+// the compressed data to outBuf.
+func (zw *Writer) compressStream(src []byte, srcPos int, endOp C.ZSTD_EndDirective) C.size_t {
 	zw.sizes.dstSize = C.size_t(cap(zw.outBuf))
 	zw.sizes.dstPos = C.size_t(len(zw.outBuf))
-	zw.sizes.srcSize = C.size_t(len(zw.inBuf))
-	zw.sizes.srcPos = 0
+	zw.sizes.srcSize = C.size_t(len(src))
+	zw.sizes.srcPos = C.size_t(srcPos)
 
 	outHdr := (*reflect.SliceHeader)(unsafe.Pointer(&zw.outBuf))
-	inHdr := (*reflect.SliceHeader)(unsafe.Pointer(&zw.inBuf))
+	inHdr := (*reflect.SliceHeader)(unsafe.Pointer(&src))
 
 	result := C.ZSTD_compressStream_wrapper(
 		unsafe.Pointer(zw.cs), unsafe.Pointer(outHdr.Data), unsafe.Pointer(inHdr.Data),
-		&zw.sizes, C.ZSTD_e_continue)
-	ensureNoError("ZSTD_compressStream_wrapper", result)
+		&zw.sizes, endOp)
+	if !zstdIsError(result) {
+		zw.frameSize += int(zw.sizes.dstPos) - len(zw.outBuf)
+		zw.outBuf = zw.outBuf[:zw.sizes.dstPos]
+	}
+	return result
+}
 
-	zw.outBuf = zw.outBuf[:zw.sizes.dstPos]
+func (zw *Writer) flushInBuf() error {
+	if zw.maxFrameSize > 0 {
+		return zw.flushInBufLimited()
+	}
+	result := zw.compressStream(zw.inBuf, 0, C.ZSTD_e_continue)
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot compress data: %w", newError(result))
+	}
+	zw.frameInSize += int(zw.sizes.srcPos)
 
 	// Move the remaining data to the start of inBuf.
 	if int(zw.sizes.srcPos) < len(zw.inBuf) {
@@ -375,17 +810,76 @@ func (zw *Writer) flushInBuf() error {
 	return zw.flushOutBuf()
 }
 
+// flushInBufLimited passes the whole inBuf to the compressor, ending
+// the current frame before its compressed size could exceed maxFrameSize.
+//
+// The data is passed to the compressor in chunks, which are flushed
+// immediately, so the compressed frame size is known after every chunk.
+func (zw *Writer) flushInBufLimited() error {
+	for len(zw.inBuf) > 0 {
+		budget := zw.maxFrameSize - zw.frameSize - maxFrameEpilogueSize
+		if zw.frameSize == 0 {
+			budget -= maxFrameHeaderSize
+		}
+		// Incompressible data is stored in raw blocks, which take
+		// 3 bytes for the block header in addition to the data.
+		n := budget - 3*(budget/blockSizeMax+1)
+		if n < minFrameChunkSize && zw.frameInSize > 0 {
+			if err := zw.endFrame(); err != nil {
+				return err
+			}
+			zw.frameSplit = true
+			continue
+		}
+		if n > len(zw.inBuf) {
+			n = len(zw.inBuf)
+		}
+
+		chunk := zw.inBuf[:n]
+		srcPos := 0
+		for {
+			result := zw.compressStream(chunk, srcPos, C.ZSTD_e_flush)
+			if zstdIsError(result) {
+				return fmt.Errorf("cannot compress data: %w", newError(result))
+			}
+			srcPos = int(zw.sizes.srcPos)
+			if result == 0 && srcPos == n {
+				break
+			}
+
+			// outBuf is full. Flush it and continue compressing.
+			if err := zw.flushOutBuf(); err != nil {
+				return err
+			}
+		}
+		zw.frameInSize += n
+		zw.frameSplit = false
+
+		// Move the remaining data to the start of inBuf.
+		copy(zw.inBuf[:cap(zw.inBuf)], zw.inBuf[n:])
+		zw.inBuf = zw.inBuf[:len(zw.inBuf)-n]
+	}
+	return zw.flushOutBuf()
+}
+
 func (zw *Writer) flushOutBuf() error {
-	if len(zw.outBuf) == 0 {
+	buf := zw.outBuf[zw.outBufFlushed:]
+	if len(buf) == 0 {
 		// Nothing to flush.
 		return nil
 	}
 
-	bufLen := len(zw.outBuf)
-	n, err := zw.w.Write(zw.outBuf)
-	zw.outBuf = zw.outBuf[:0]
+	bufLen := len(buf)
+	n, err := zw.w.Write(buf)
+	zw.outputSize += int64(n)
+	if zw.stableOut {
+		// outBuf cannot be moved until the end of the frame.
+		zw.outBufFlushed = len(zw.outBuf)
+	} else {
+		zw.outBuf = zw.outBuf[:0]
+	}
 	if err != nil {
-		return fmt.Errorf("cannot flush internal buffer to the underlying writer: %s", err)
+		return fmt.Errorf("cannot flush internal buffer to the underlying writer: %w", err)
 	}
 	if n != bufLen {
 		panic(fmt.Errorf("BUG: the underlying writer violated io.Writer contract and didn't return error after writing incomplete data; written %d bytes; want %d bytes",
@@ -394,8 +888,30 @@ func (zw *Writer) flushOutBuf() error {
 	return nil
 }
 
+// InputSize returns the number of bytes written to zw since its creation
+// or the last Reset.
+func (zw *Writer) InputSize() int64 {
+	return zw.inputSize
+}
+
+// OutputSize returns the number of compressed bytes written to the underlying
+// writer since zw creation or the last Reset.
+//
+// It doesn't include the compressed data buffered in zw,
+// so call Flush or Close before obtaining the final size.
+func (zw *Writer) OutputSize() int64 {
+	return zw.outputSize
+}
+
 // Flush flushes the remaining data from zw to the underlying writer.
+//
+// It does nothing in buffered mode. See SetBuffered.
 func (zw *Writer) Flush() error {
+	if zw.buffered {
+		// The data cannot be passed to the compressor until the frame end,
+		// since its size must be stored in the frame header.
+		return nil
+	}
 	// Flush inBuf.
 	for len(zw.inBuf) > 0 {
 		if err := zw.flushInBuf(); err != nil {
@@ -403,16 +919,21 @@ func (zw *Writer) Flush() error {
 		}
 	}
 
-	// Flush the internal buffer to outBuf.
+	// Flush the internal buffer to outBuf until it is drained.
 	for {
-		outHdr := (*reflect.SliceHeader)(unsafe.Pointer(&zw.outBuf))
-		zw.sizes.dstSize = C.size_t(cap(zw.outBuf))
-		zw.sizes.dstPos = C.size_t(len(zw.outBuf))
-
-		result := C.ZSTD_flushStream_wrapper(
-			unsafe.Pointer(zw.cs), unsafe.Pointer(outHdr.Data), &zw.sizes)
-		ensureNoError("ZSTD_flushStream", result)
-		zw.outBuf = zw.outBuf[:zw.sizes.dstPos]
+		// inBuf is empty at this point, while stableInBuf must be passed
+		// to the compressor as is.
+		src, srcPos := zw.inBuf, 0
+		if zw.stableIn {
+			src, srcPos = zw.stableInBuf, zw.stableInPos
+		}
+		result := zw.compressStream(src, srcPos, C.ZSTD_e_flush)
+		if zstdIsError(result) {
+			return fmt.Errorf("cannot flush compressed data: %w", newError(result))
+		}
+		if zw.stableIn {
+			zw.stableInPos = int(zw.sizes.srcPos)
+		}
 		if err := zw.flushOutBuf(); err != nil {
 			return err
 		}
@@ -423,15 +944,103 @@ func (zw *Writer) Flush() error {
 	}
 }
 
+// Buffered returns the number of bytes held by zw, which weren't passed
+// to the underlying writer yet.
+//
+// This includes the data written to zw, which wasn't passed to the compressor
+// yet, and the compressed data, which wasn't written to the underlying writer.
+// It excludes the data held inside the compressor, so Buffered may return 0
+// while Flush still writes data to the underlying writer.
+// Buffered returns 0 after successful Flush or Close.
+func (zw *Writer) Buffered() int {
+	return len(zw.inBuf) + len(zw.bufferedData) + len(zw.outBuf) - zw.outBufFlushed
+}
+
+// CloseUnderlying enables or disables closing the underlying writer by Close.
+//
+// When enabled, Close closes the underlying writer after finalizing
+// the compressed stream if the writer implements io.Closer.
+// It is disabled by default. The setting is preserved across Reset calls.
+func (zw *Writer) CloseUnderlying(enable bool) {
+	zw.closeUnderlying = enable
+}
+
 // Close finalizes the compressed stream and flushes all the compressed data
 // to the underlying writer.
 //
-// It doesn't close the underlying writer passed to New* functions.
+// It doesn't close the underlying writer passed to New* functions
+// unless CloseUnderlying(true) is called. In this case the underlying
+// writer is closed even if the stream cannot be finalized.
+//
+// If the final frame cannot be written to the underlying writer, then its
+// error is returned. Subsequent Close calls return the same result until
+// new data is written to zw, which starts a new compressed frame.
 func (zw *Writer) Close() error {
+	if zw.closed {
+		return zw.closeErr
+	}
+	zw.closed = true
+
+	err := zw.finishFrame()
+	if zw.closeUnderlying {
+		if c, ok := zw.w.(io.Closer); ok {
+			if closeErr := c.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("cannot close the underlying writer: %w", closeErr)
+			}
+		}
+	}
+	zw.closeErr = err
+	return err
+}
+
+func (zw *Writer) finishFrame() error {
+	if zw.buffered {
+		return zw.writeBufferedFrame()
+	}
 	if err := zw.Flush(); err != nil {
 		return err
 	}
+	if zw.frameSplit {
+		// The frame has been already ended due to maxFrameSize
+		// and no data has been written since then.
+		zw.frameSplit = false
+		return nil
+	}
+	return zw.endFrame()
+}
+
+// writeBufferedFrame compresses bufferedData into a single frame
+// with the content size and writes it to the underlying writer.
+func (zw *Writer) writeBufferedFrame() error {
+	data := zw.bufferedData
+	if zw.pledged && zw.pledgedSize != uint64(len(data)) {
+		return fmt.Errorf("the written data size %d bytes differs from the pledged src size %d bytes", len(data), zw.pledgedSize)
+	}
+	result := C.ZSTD_CCtx_setPledgedSrcSize_wrapper(unsafe.Pointer(zw.cs), C.ulonglong(len(data)))
+	if zstdIsError(result) {
+		return fmt.Errorf("cannot set pledged src size: %w", newError(result))
+	}
+	srcPos := 0
+	for {
+		result := zw.compressStream(data, srcPos, C.ZSTD_e_end)
+		if zstdIsError(result) {
+			return fmt.Errorf("cannot compress data: %w", newError(result))
+		}
+		srcPos = int(zw.sizes.srcPos)
+		if err := zw.flushOutBuf(); err != nil {
+			return err
+		}
+		if result == 0 {
+			break
+		}
+	}
+	zw.bufferedData = zw.bufferedData[:0]
+	zw.resetFrame()
+	return nil
+}
 
+// endFrame finalizes the current frame and flushes it to the underlying writer.
+func (zw *Writer) endFrame() error {
 	for {
 		outHdr := (*reflect.SliceHeader)(unsafe.Pointer(&zw.outBuf))
 		zw.sizes.dstSize = C.size_t(cap(zw.outBuf))
@@ -440,12 +1049,15 @@ func (zw *Writer) Close() error {
 		result := C.ZSTD_endStream_wrapper(
 			unsafe.Pointer(zw.cs),
 			unsafe.Pointer(outHdr.Data), &zw.sizes)
-		ensureNoError("ZSTD_endStream", result)
+		if zstdIsError(result) {
+			return fmt.Errorf("cannot finalize compressed stream: %w", newError(result))
+		}
 		zw.outBuf = zw.outBuf[:zw.sizes.dstPos]
 		if err := zw.flushOutBuf(); err != nil {
 			return err
 		}
 		if result == 0 {
+			zw.resetFrame()
 			return nil
 		}
 	}
@@ -1,8 +1,12 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +14,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/valyala/gozstd/internal/compress/zstd"
 )
 
 type EOFReader struct {
@@ -132,6 +138,105 @@ func TestWriterDict(t *testing.T) {
 	}
 }
 
+func TestWriterDictFlush(t *testing.T) {
+	// Every message has distinct format, so only the dict may help
+	// compressing it.
+	formats := []string{
+		"message id=%d, user=user_%d, status=ok",
+		"event type=login, session=%d, region=eu-west-%d",
+		"metric name=cpu_usage_percent, host=%d, value=%d",
+	}
+	var samples [][]byte
+	for i := 0; i < 1e4; i++ {
+		sample := []byte(fmt.Sprintf(formats[i%len(formats)], i, i%100))
+		samples = append(samples, sample)
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	// writeMessages writes messages to zw, flushing every message, and returns
+	// the number of compressed bytes written to bb for every message.
+	writeMessages := func(zw *Writer, bb *bytes.Buffer) []int {
+		t.Helper()
+		var sizes []int
+		for i, format := range formats {
+			n := bb.Len()
+			if _, err := fmt.Fprintf(zw, format, 12345+i, i); err != nil {
+				t.Fatalf("cannot write message #%d: %s", i, err)
+			}
+			if err := zw.Flush(); err != nil {
+				t.Fatalf("cannot flush message #%d: %s", i, err)
+			}
+			sizes = append(sizes, bb.Len()-n)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("cannot close zw: %s", err)
+		}
+		return sizes
+	}
+
+	var bbPlain bytes.Buffer
+	zwPlain := NewWriter(&bbPlain)
+	defer zwPlain.Release()
+	sizesPlain := writeMessages(zwPlain, &bbPlain)
+
+	var bb bytes.Buffer
+	zw := NewWriterDict(&bb, cd)
+	defer zw.Release()
+	sizes := writeMessages(zw, &bb)
+
+	// Every flushed message must be compressed with the dict.
+	for i := range sizes {
+		if sizes[i] >= sizesPlain[i] {
+			t.Fatalf("message #%d must be compressed better with dict; got %d bytes; want less than %d bytes", i, sizes[i], sizesPlain[i])
+		}
+	}
+
+	// All the messages must be written in a single frame.
+	plainData, n, err := DecompressFirstFrame(nil, bb.Bytes(), dd)
+	if err != nil {
+		t.Fatalf("cannot decompress data with dict: %s", err)
+	}
+	if n != bb.Len() {
+		t.Fatalf("unexpected frame size; got %d bytes; want %d bytes", n, bb.Len())
+	}
+	plainDataExpected, err := Decompress(nil, bbPlain.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress data without dict: %s", err)
+	}
+	if !bytes.Equal(plainData, plainDataExpected) {
+		t.Fatalf("unexpected decompressed data; got %q; want %q", plainData, plainDataExpected)
+	}
+	if _, err := Decompress(nil, bb.Bytes()); !errors.Is(err, ErrDictionaryMismatch) {
+		t.Fatalf("unexpected error when decompressing without dict; got %v; want %v", err, ErrDictionaryMismatch)
+	}
+
+	// The dict must be used for the next frame after Close.
+	bb.Reset()
+	bbPlain.Reset()
+	sizes = writeMessages(zw, &bb)
+	sizesPlain = writeMessages(zwPlain, &bbPlain)
+	if sizes[0] >= sizesPlain[0] {
+		t.Fatalf("the first message in the next frame must be compressed better with dict; got %d bytes; want less than %d bytes", sizes[0], sizesPlain[0])
+	}
+	plainData, err = DecompressDict(nil, bb.Bytes(), dd)
+	if err != nil {
+		t.Fatalf("cannot decompress the next frame with dict: %s", err)
+	}
+	if !bytes.Equal(plainData, plainDataExpected) {
+		t.Fatalf("unexpected decompressed data for the next frame; got %q; want %q", plainData, plainDataExpected)
+	}
+}
+
 func testWriterDictSerial(cd *CDict, dd *DDict) error {
 	var bb bytes.Buffer
 	var bbOrig bytes.Buffer
@@ -299,6 +404,424 @@ func TestWriterMultiFrames(t *testing.T) {
 	}
 }
 
+func TestWriterPledgedSrcSize(t *testing.T) {
+	data := []byte(newTestString(200*1024, 20))
+
+	compressStream := func(pledge bool) []byte {
+		t.Helper()
+		var bb bytes.Buffer
+		zw := NewWriter(&bb)
+		defer zw.Release()
+		if pledge {
+			if err := zw.SetPledgedSrcSize(uint64(len(data))); err != nil {
+				t.Fatalf("cannot set pledged src size: %s", err)
+			}
+		}
+		if _, err := zw.Write(data); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+		return bb.Bytes()
+	}
+
+	var h zstd.Header
+	if err := h.Decode(compressStream(false)); err != nil {
+		t.Fatalf("cannot decode frame header: %s", err)
+	}
+	if h.HasFCS {
+		t.Fatalf("unexpected content size in the frame header without pledge: %d", h.FrameContentSize)
+	}
+
+	cd := compressStream(true)
+	if err := h.Decode(cd); err != nil {
+		t.Fatalf("cannot decode frame header: %s", err)
+	}
+	if !h.HasFCS {
+		t.Fatalf("missing content size in the frame header with pledge")
+	}
+	if h.FrameContentSize != uint64(len(data)) {
+		t.Fatalf("unexpected content size in the frame header; got %d; want %d", h.FrameContentSize, len(data))
+	}
+	plainData, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// Close must fail if the written data size differs from the pledged size.
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+	if err := zw.SetPledgedSrcSize(uint64(len(data)) + 1); err != nil {
+		t.Fatalf("cannot set pledged src size: %s", err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err == nil {
+		t.Fatalf("expecting non-nil error when closing writer with wrong pledged size")
+	}
+
+	// Pledge after writing data must fail.
+	zw.Reset(&bb, nil, DefaultCompressionLevel)
+	if _, err := zw.Write(data[:10]); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.SetPledgedSrcSize(10); err == nil {
+		t.Fatalf("expecting non-nil error when setting pledged size after writing data")
+	}
+}
+
+func TestWriterResetAfterPledgeDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1e4; i++ {
+		sample := []byte(fmt.Sprintf("this is a sample number %d", i))
+		samples = append(samples, sample)
+	}
+	dict := BuildDict(samples, 8*1024)
+	cd, err := NewCDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create CDict: %s", err)
+	}
+	defer cd.Release()
+	dd, err := NewDDict(dict)
+	if err != nil {
+		t.Fatalf("cannot create DDict: %s", err)
+	}
+	defer dd.Release()
+
+	// The size pledged before Reset mustn't apply to the next frame.
+	var bb bytes.Buffer
+	zw := NewWriterDict(&bb, cd)
+	defer zw.Release()
+	if err := zw.SetPledgedSrcSize(12345); err != nil {
+		t.Fatalf("cannot set pledged src size: %s", err)
+	}
+	zw.Reset(&bb, cd, 0)
+
+	data := []byte(newTestString(1000, 3))
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	plainData, err := DecompressDict(nil, bb.Bytes(), dd)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+}
+
+func TestWriterBuffered(t *testing.T) {
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+
+	if n := zw.Buffered(); n != 0 {
+		t.Fatalf("unexpected Buffered for fresh writer; got %d; want 0", n)
+	}
+
+	data := []byte(newTestString(1000, 20))
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if n := zw.Buffered(); n != len(data) {
+		t.Fatalf("unexpected Buffered after Write; got %d; want %d", n, len(data))
+	}
+
+	if err := zw.Flush(); err != nil {
+		t.Fatalf("unexpected error in Flush: %s", err)
+	}
+	if n := zw.Buffered(); n != 0 {
+		t.Fatalf("unexpected Buffered after Flush; got %d; want 0", n)
+	}
+
+	// All the written data must be decompressible after Flush.
+	zr := NewReader(bytes.NewReader(bb.Bytes()))
+	defer zr.Release()
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		t.Fatalf("cannot read flushed data: %s", err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatalf("unexpected flushed data; got\n%X; want\n%X", buf, data)
+	}
+
+	// Write big chunk, so the compressed data spans multiple flush iterations.
+	data = []byte(newTestString(3*int(cstreamOutBufSize), 256))
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	if n := zw.Buffered(); n != 0 {
+		t.Fatalf("unexpected Buffered after Close; got %d; want 0", n)
+	}
+}
+
+func TestWriterStableBuffers(t *testing.T) {
+	data := []byte(newTestString(3*int(cstreamOutBufSize), 256))
+
+	for _, params := range []WriterParams{
+		{StableInBuffer: true},
+		{StableOutBuffer: true},
+		{StableInBuffer: true, StableOutBuffer: true, CompressionLevel: 10},
+	} {
+		var bb bytes.Buffer
+		zw := NewWriterParams(&bb, &params)
+
+		// Write a few frames in order to verify the per-frame state is reset.
+		for i := 0; i < 3; i++ {
+			if params.StableOutBuffer {
+				if err := zw.SetPledgedSrcSize(uint64(len(data))); err != nil {
+					t.Fatalf("cannot set pledged src size: %s", err)
+				}
+			}
+			// Write adjacent chunks of data with varying sizes.
+			n := 0
+			for _, chunkLen := range []int{10, 1000, 100 * 1000, len(data)} {
+				if n+chunkLen > len(data) {
+					chunkLen = len(data) - n
+				}
+				if _, err := zw.Write(data[n : n+chunkLen]); err != nil {
+					t.Fatalf("unexpected error in Write for %+v: %s", params, err)
+				}
+				n += chunkLen
+				if chunkLen == 1000 {
+					if err := zw.Flush(); err != nil {
+						t.Fatalf("unexpected error in Flush for %+v: %s", params, err)
+					}
+				}
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatalf("unexpected error in Close for %+v: %s", params, err)
+			}
+		}
+
+		zr := NewReader(&bb)
+		plainData, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("cannot decompress data for %+v: %s", params, err)
+		}
+		zr.Release()
+		want := bytes.Repeat(data, 3)
+		if !bytes.Equal(plainData, want) {
+			t.Fatalf("unexpected decompressed data for %+v; got %d bytes; want %d bytes", params, len(plainData), len(want))
+		}
+		zw.Release()
+	}
+
+	// Non-adjacent chunks must be rejected with StableInBuffer.
+	zw := NewWriterParams(ioutil.Discard, &WriterParams{StableInBuffer: true})
+	defer zw.Release()
+	if _, err := zw.Write(data[:100]); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if _, err := zw.Write(data[200:300]); err == nil {
+		t.Fatalf("expecting non-nil error when writing non-adjacent chunk")
+	}
+	if _, err := zw.ReadFrom(bytes.NewReader(data)); err == nil {
+		t.Fatalf("expecting non-nil error in ReadFrom with StableInBuffer")
+	}
+
+	// StableOutBuffer requires pledged src size.
+	zw.ResetWriterParams(ioutil.Discard, &WriterParams{StableOutBuffer: true})
+	if _, err := zw.Write(data[:100]); err == nil {
+		t.Fatalf("expecting non-nil error when writing without pledged src size")
+	}
+
+	// Writes exceeding the pledged src size must be rejected.
+	// This also verifies the frames aborted above don't break the subsequent
+	// frames with StableInBuffer after the reset.
+	for _, params := range []WriterParams{
+		{StableOutBuffer: true},
+		{StableInBuffer: true, StableOutBuffer: true},
+	} {
+		zw.ResetWriterParams(ioutil.Discard, &params)
+		if err := zw.SetPledgedSrcSize(150); err != nil {
+			t.Fatalf("cannot set pledged src size: %s", err)
+		}
+		if _, err := zw.Write(data[:100]); err != nil {
+			t.Fatalf("unexpected error in Write for %+v: %s", params, err)
+		}
+		if _, err := zw.Write(data[100:200]); err == nil {
+			t.Fatalf("expecting non-nil error when exceeding pledged src size for %+v", params)
+		}
+		if _, err := zw.Write(data[100:150]); err != nil {
+			t.Fatalf("unexpected error in Write for %+v: %s", params, err)
+		}
+		if !params.StableInBuffer {
+			if err := zw.WriteByte('x'); err == nil {
+				t.Fatalf("expecting non-nil error in WriteByte when exceeding pledged src size")
+			}
+			if _, err := zw.ReadFrom(bytes.NewReader(data[:10])); err == nil {
+				t.Fatalf("expecting non-nil error in ReadFrom when exceeding pledged src size")
+			}
+		}
+	}
+}
+
+func TestWriterDoubleClose(t *testing.T) {
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+
+	data := []byte(newTestString(1000, 20))
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close #%d: %s", i, err)
+		}
+	}
+	cd := append([]byte{}, bb.Bytes()...)
+	if cd2 := Compress(nil, data); len(cd) > len(cd2)+16 {
+		t.Fatalf("too big compressed stream after multiple Close calls; got %d bytes", len(cd))
+	}
+	plainData, err := Decompress(nil, cd)
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// Writing after Close starts new frame.
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write after Close: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	plainData, err = Decompress(nil, bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, append(append([]byte{}, data...), data...)) {
+		t.Fatalf("unexpected decompressed data for two frames")
+	}
+
+	// Close must return the error from the underlying writer.
+	ew := &errWriter{
+		err: fmt.Errorf("underlying writer failed"),
+	}
+	zw.Reset(ew, nil, DefaultCompressionLevel)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	err1 := zw.Close()
+	if !errors.Is(err1, ew.err) {
+		t.Fatalf("unexpected error in Close; got %v; want %v", err1, ew.err)
+	}
+
+	// Subsequent Close must return the same error.
+	if err := zw.Close(); err != err1 {
+		t.Fatalf("unexpected error in the second Close; got %v; want %v", err, err1)
+	}
+}
+
+type errWriter struct {
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	return 0, ew.err
+}
+
+func TestWriterCloseUnderlying(t *testing.T) {
+	data := []byte(newTestString(1000, 20))
+
+	// The underlying writer mustn't be closed by default.
+	cw := &closeTrackingWriter{}
+	zw := NewWriter(cw)
+	defer zw.Release()
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	if cw.closeCalls != 0 {
+		t.Fatalf("unexpected number of Close calls for the underlying writer; got %d; want 0", cw.closeCalls)
+	}
+
+	// The underlying writer must be closed exactly once after finalizing the stream.
+	cw = &closeTrackingWriter{}
+	zw.Reset(cw, nil, DefaultCompressionLevel)
+	zw.CloseUnderlying(true)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+	}
+	if cw.closeCalls != 1 {
+		t.Fatalf("unexpected number of Close calls for the underlying writer; got %d; want 1", cw.closeCalls)
+	}
+	if cw.writesAfterClose > 0 {
+		t.Fatalf("unexpected writes after closing the underlying writer: %d", cw.writesAfterClose)
+	}
+	plainData, err := Decompress(nil, cw.bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// The error from the underlying Close must be returned.
+	cw = &closeTrackingWriter{
+		closeErr: fmt.Errorf("cannot close"),
+	}
+	zw.Reset(cw, nil, DefaultCompressionLevel)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); !errors.Is(err, cw.closeErr) {
+		t.Fatalf("unexpected error in Close; got %v; want %v", err, cw.closeErr)
+	}
+
+	// Writers without Close method must be supported.
+	var bb bytes.Buffer
+	zw.Reset(&bb, nil, DefaultCompressionLevel)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+}
+
+type closeTrackingWriter struct {
+	bb               bytes.Buffer
+	closeErr         error
+	closeCalls       int
+	writesAfterClose int
+}
+
+func (cw *closeTrackingWriter) Write(p []byte) (int, error) {
+	if cw.closeCalls > 0 {
+		cw.writesAfterClose++
+	}
+	return cw.bb.Write(p)
+}
+
+func (cw *closeTrackingWriter) Close() error {
+	cw.closeCalls++
+	return cw.closeErr
+}
+
 func TestWriterBadUnderlyingWriter(t *testing.T) {
 	zw := NewWriter(&badWriter{})
 	defer zw.Release()
@@ -496,3 +1019,298 @@ func TestWriterBig(t *testing.T) {
 		t.Fatalf("unequal writtenBB and readBB\nwrittenBB=\n%X\nreadBB=\n%X", writtenBB.Bytes(), readBB.Bytes())
 	}
 }
+
+func TestWriterWriteByte(t *testing.T) {
+	var _ io.ByteWriter = &Writer{}
+
+	// Write more than the input buffer size in order to exercise flushes.
+	data := []byte(newTestString(300*1024, 3))
+
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+	for i, c := range data {
+		if i%1000 == 0 {
+			// Mix WriteByte with Write and Flush calls.
+			if _, err := zw.Write(data[i : i+1]); err != nil {
+				t.Fatalf("unexpected error in Write: %s", err)
+			}
+			if i%100000 == 0 {
+				if err := zw.Flush(); err != nil {
+					t.Fatalf("unexpected error in Flush: %s", err)
+				}
+			}
+			continue
+		}
+		if err := zw.WriteByte(c); err != nil {
+			t.Fatalf("unexpected error in WriteByte: %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+	plainData, err := Decompress(nil, bb.Bytes())
+	if err != nil {
+		t.Fatalf("cannot decompress data: %s", err)
+	}
+	if !bytes.Equal(plainData, data) {
+		t.Fatalf("unexpected decompressed data")
+	}
+
+	// WriteByte cannot be used with stable input buffer.
+	zw.ResetWriterParams(&bb, &WriterParams{
+		StableInBuffer: true,
+	})
+	if err := zw.WriteByte('a'); err == nil {
+		t.Fatalf("expecting non-nil error in WriteByte when StableInBuffer is set")
+	}
+}
+
+func TestWriterInputOutputSize(t *testing.T) {
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+
+	if n := zw.InputSize(); n != 0 {
+		t.Fatalf("unexpected input size for new writer; got %d; want 0", n)
+	}
+	if n := zw.OutputSize(); n != 0 {
+		t.Fatalf("unexpected output size for new writer; got %d; want 0", n)
+	}
+
+	var inputSize int64
+	for i := 0; i < 1000; i++ {
+		line := fmt.Sprintf("line #%d, some compressible data\n", i%100)
+		if _, err := zw.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		inputSize += int64(len(line))
+	}
+	if err := zw.WriteByte('\n'); err != nil {
+		t.Fatalf("unexpected error in WriteByte: %s", err)
+	}
+	inputSize++
+	n, err := zw.ReadFrom(strings.NewReader("data from reader"))
+	if err != nil {
+		t.Fatalf("unexpected error in ReadFrom: %s", err)
+	}
+	inputSize += n
+	if err := zw.Close(); err != nil {
+		t.Fatalf("unexpected error in Close: %s", err)
+	}
+
+	if n := zw.InputSize(); n != inputSize {
+		t.Fatalf("unexpected input size; got %d; want %d", n, inputSize)
+	}
+	if n := zw.OutputSize(); n != int64(bb.Len()) {
+		t.Fatalf("unexpected output size; got %d; want %d", n, bb.Len())
+	}
+	ratio := float64(zw.InputSize()) / float64(zw.OutputSize())
+	if ratio < 5 {
+		t.Fatalf("too low compression ratio for compressible data; got %.2f; want at least 5", ratio)
+	}
+
+	// Reset must reset the counters.
+	zw.Reset(&bb, nil, DefaultCompressionLevel)
+	if n := zw.InputSize(); n != 0 {
+		t.Fatalf("unexpected input size after Reset; got %d; want 0", n)
+	}
+	if n := zw.OutputSize(); n != 0 {
+		t.Fatalf("unexpected output size after Reset; got %d; want 0", n)
+	}
+}
+
+func TestWriterMaxFrameCompressedSize(t *testing.T) {
+	const maxFrameSize = 64 * 1024
+	r := rand.New(rand.NewSource(1))
+	incompressible := make([]byte, 300*1024)
+	r.Read(incompressible)
+
+	f := func(name string, write func(zw *Writer) []byte) {
+		t.Helper()
+		var bb bytes.Buffer
+		zw := NewWriter(&bb)
+		defer zw.Release()
+		if err := zw.SetMaxFrameCompressedSize(maxFrameSize); err != nil {
+			t.Fatalf("%s: unexpected error in SetMaxFrameCompressedSize: %s", name, err)
+		}
+		origData := write(zw)
+		if err := zw.Close(); err != nil {
+			t.Fatalf("%s: unexpected error in Close: %s", name, err)
+		}
+
+		src := bb.Bytes()
+		var plainData []byte
+		frames := 0
+		for len(src) > 0 {
+			var n int
+			var err error
+			plainData, n, err = DecompressFirstFrame(plainData, src, nil)
+			if err != nil {
+				t.Fatalf("%s: cannot decompress frame #%d: %s", name, frames, err)
+			}
+			if n > maxFrameSize {
+				t.Fatalf("%s: too big frame #%d; got %d bytes; want up to %d bytes", name, frames, n, maxFrameSize)
+			}
+			src = src[n:]
+			frames++
+		}
+		if frames < 2 {
+			t.Fatalf("%s: unexpected number of frames; got %d; want at least 2", name, frames)
+		}
+		if !bytes.Equal(plainData, origData) {
+			t.Fatalf("%s: unexpected decompressed data; len(got)=%d; len(want)=%d", name, len(plainData), len(origData))
+		}
+
+		// The stream must be decompressible as a whole.
+		plainData, err := Decompress(nil, bb.Bytes())
+		if err != nil {
+			t.Fatalf("%s: cannot decompress the stream: %s", name, err)
+		}
+		if !bytes.Equal(plainData, origData) {
+			t.Fatalf("%s: unexpected data decompressed from the stream", name)
+		}
+	}
+
+	f("compressible", func(zw *Writer) []byte {
+		var bbOrig bytes.Buffer
+		w := io.MultiWriter(zw, &bbOrig)
+		for bbOrig.Len() < 4*1024*1024 {
+			if _, err := fmt.Fprintf(w, "line %d, %s\n", bbOrig.Len(), newTestString(100, 3)); err != nil {
+				t.Fatalf("unexpected error when writing to zw: %s", err)
+			}
+		}
+		return bbOrig.Bytes()
+	})
+	f("incompressible", func(zw *Writer) []byte {
+		if _, err := zw.Write(incompressible); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		return incompressible
+	})
+	f("flush", func(zw *Writer) []byte {
+		for i := 0; i < len(incompressible); i += 10000 {
+			if _, err := zw.Write(incompressible[i : i+5000]); err != nil {
+				t.Fatalf("unexpected error in Write: %s", err)
+			}
+			if err := zw.Flush(); err != nil {
+				t.Fatalf("unexpected error in Flush: %s", err)
+			}
+		}
+		var origData []byte
+		for i := 0; i < len(incompressible); i += 10000 {
+			origData = append(origData, incompressible[i:i+5000]...)
+		}
+		return origData
+	})
+	f("readfrom", func(zw *Writer) []byte {
+		if _, err := zw.ReadFrom(bytes.NewReader(incompressible)); err != nil {
+			t.Fatalf("unexpected error in ReadFrom: %s", err)
+		}
+		return incompressible
+	})
+
+	// Invalid limits.
+	zw := NewWriter(ioutil.Discard)
+	defer zw.Release()
+	if err := zw.SetMaxFrameCompressedSize(100); err == nil {
+		t.Fatalf("expecting non-nil error for too small limit")
+	}
+	if _, err := zw.Write([]byte("foobar")); err != nil {
+		t.Fatalf("unexpected error in Write: %s", err)
+	}
+	if err := zw.SetMaxFrameCompressedSize(maxFrameSize); err == nil {
+		t.Fatalf("expecting non-nil error when setting the limit after writing data")
+	}
+	zwStable := NewWriterParams(ioutil.Discard, &WriterParams{
+		StableOutBuffer: true,
+	})
+	defer zwStable.Release()
+	if err := zwStable.SetMaxFrameCompressedSize(maxFrameSize); err == nil {
+		t.Fatalf("expecting non-nil error for StableOutBuffer")
+	}
+
+	// The limit cannot be combined with pledged src size.
+	zwPledged := NewWriter(ioutil.Discard)
+	defer zwPledged.Release()
+	if err := zwPledged.SetPledgedSrcSize(1e6); err != nil {
+		t.Fatalf("cannot set pledged src size: %s", err)
+	}
+	if err := zwPledged.SetMaxFrameCompressedSize(maxFrameSize); err == nil {
+		t.Fatalf("expecting non-nil error when setting the limit after SetPledgedSrcSize")
+	}
+	zwLimited := NewWriter(ioutil.Discard)
+	defer zwLimited.Release()
+	if err := zwLimited.SetMaxFrameCompressedSize(maxFrameSize); err != nil {
+		t.Fatalf("cannot set max frame compressed size: %s", err)
+	}
+	if err := zwLimited.SetPledgedSrcSize(1e6); err == nil {
+		t.Fatalf("expecting non-nil error when setting pledged src size after SetMaxFrameCompressedSize")
+	}
+}
+
+func TestWriterBufferedMode(t *testing.T) {
+	var bb bytes.Buffer
+	zw := NewWriter(&bb)
+	defer zw.Release()
+	if err := zw.SetBuffered(true); err != nil {
+		t.Fatalf("unexpected error in SetBuffered: %s", err)
+	}
+
+	for _, n := range []int{0, 1, 1000, 300 * 1024} {
+		bb.Reset()
+		zw.Reset(&bb, nil, DefaultCompressionLevel)
+		origData := []byte(newTestString(n, 3))
+		half := len(origData) / 2
+		if _, err := zw.Write(origData[:half]); err != nil {
+			t.Fatalf("unexpected error in Write: %s", err)
+		}
+		if err := zw.Flush(); err != nil {
+			t.Fatalf("unexpected error in Flush: %s", err)
+		}
+		if bb.Len() != 0 {
+			t.Fatalf("Flush mustn't write data in buffered mode; got %d bytes", bb.Len())
+		}
+		if _, err := zw.ReadFrom(bytes.NewReader(origData[half:])); err != nil {
+			t.Fatalf("unexpected error in ReadFrom: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("unexpected error in Close: %s", err)
+		}
+
+		// The frame header must carry the content size.
+		contentSize, err := TotalContentSize(bb.Bytes())
+		if err != nil {
+			t.Fatalf("cannot obtain content size for n=%d: %s", n, err)
+		}
+		if contentSize != uint64(n) {
+			t.Fatalf("unexpected content size; got %d; want %d", contentSize, n)
+		}
+		plainData, err := Decompress(nil, bb.Bytes())
+		if err != nil {
+			t.Fatalf("cannot decompress data for n=%d: %s", n, err)
+		}
+		if !bytes.Equal(plainData, origData) {
+			t.Fatalf("unexpected decompressed data for n=%d; len(got)=%d; len(want)=%d", n, len(plainData), len(origData))
+		}
+	}
+
+	// The buffered mode cannot be changed after writing data to the frame.
+	if err := zw.WriteByte('x'); err != nil {
+		t.Fatalf("unexpected error in WriteByte: %s", err)
+	}
+	if err := zw.SetBuffered(false); err == nil {
+		t.Fatalf("expecting non-nil error when disabling buffered mode after writing data")
+	}
+	if err := zw.SetMaxFrameCompressedSize(64 * 1024); err == nil {
+		t.Fatalf("expecting non-nil error when setting max frame compressed size in buffered mode")
+	}
+
+	zwStable := NewWriterParams(ioutil.Discard, &WriterParams{
+		StableOutBuffer: true,
+	})
+	defer zwStable.Release()
+	if err := zwStable.SetBuffered(true); err == nil {
+		t.Fatalf("expecting non-nil error for StableOutBuffer")
+	}
+}
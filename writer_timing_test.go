@@ -1,6 +1,10 @@
+//go:build cgo
+// +build cgo
+
 package gozstd
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -77,6 +81,85 @@ func benchmarkWriter(b *testing.B, blockSize, level int) {
 	})
 }
 
+func BenchmarkWriterStableInBuffer(b *testing.B) {
+	for _, blockSize := range benchBlockSizes {
+		b.Run(fmt.Sprintf("blockSize_%d", blockSize), func(b *testing.B) {
+			for _, level := range benchCompressionLevels {
+				b.Run(fmt.Sprintf("level_%d", level), func(b *testing.B) {
+					benchmarkWriterStableInBuffer(b, blockSize, level)
+				})
+			}
+		})
+	}
+}
+
+func benchmarkWriterStableInBuffer(b *testing.B, blockSize, level int) {
+	block := newBenchString(blockSize * benchBlocksPerStream)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	b.RunParallel(func(pb *testing.PB) {
+		zw := NewWriterParams(ioutil.Discard, &WriterParams{
+			CompressionLevel: level,
+			StableInBuffer:   true,
+		})
+		defer zw.Release()
+		for pb.Next() {
+			for i := 0; i < benchBlocksPerStream; i++ {
+				_, err := zw.Write(block[i*blockSize : (i+1)*blockSize])
+				if err != nil {
+					panic(fmt.Errorf("unexpected error: %s", err))
+				}
+			}
+			if err := zw.Close(); err != nil {
+				panic(fmt.Errorf("unexpected error: %s", err))
+			}
+			zw.Reset(ioutil.Discard, nil, level)
+		}
+	})
+}
+
+func BenchmarkWriterStableBuffersLargeStream(b *testing.B) {
+	const streamSize = 16 * 1024 * 1024
+	const chunkSize = 64 * 1024
+	stream := newBenchString(streamSize)
+	f := func(b *testing.B, params *WriterParams) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(stream)))
+		zw := NewWriterParams(ioutil.Discard, params)
+		defer zw.Release()
+		for i := 0; i < b.N; i++ {
+			if params.StableOutBuffer {
+				if err := zw.SetPledgedSrcSize(uint64(len(stream))); err != nil {
+					panic(fmt.Errorf("unexpected error: %s", err))
+				}
+			}
+			for n := 0; n < len(stream); n += chunkSize {
+				if _, err := zw.Write(stream[n : n+chunkSize]); err != nil {
+					panic(fmt.Errorf("unexpected error: %s", err))
+				}
+			}
+			if err := zw.Close(); err != nil {
+				panic(fmt.Errorf("unexpected error: %s", err))
+			}
+			zw.ResetWriterParams(ioutil.Discard, params)
+		}
+	}
+	b.Run("default", func(b *testing.B) {
+		f(b, &WriterParams{})
+	})
+	b.Run("StableInBuffer", func(b *testing.B) {
+		f(b, &WriterParams{
+			StableInBuffer: true,
+		})
+	})
+	b.Run("StableInOutBuffer", func(b *testing.B) {
+		f(b, &WriterParams{
+			StableInBuffer:  true,
+			StableOutBuffer: true,
+		})
+	})
+}
+
 func BenchmarkWriterResetAlloc(b *testing.B) {
 	b.ReportAllocs()
 
@@ -90,3 +173,47 @@ func BenchmarkWriterResetAlloc(b *testing.B) {
 		zw.ResetWriterParams(ioutil.Discard, params)
 	}
 }
+
+func BenchmarkWriterWriteByte(b *testing.B) {
+	data := newBenchString(64 * 1024)
+	b.Run("WriteByte", func(b *testing.B) {
+		benchmarkWriterBytes(b, data, func(zw *Writer, bw *bufio.Writer, c byte) error {
+			return zw.WriteByte(c)
+		})
+	})
+	b.Run("Write", func(b *testing.B) {
+		var buf [1]byte
+		benchmarkWriterBytes(b, data, func(zw *Writer, bw *bufio.Writer, c byte) error {
+			buf[0] = c
+			_, err := zw.Write(buf[:])
+			return err
+		})
+	})
+	b.Run("bufio", func(b *testing.B) {
+		benchmarkWriterBytes(b, data, func(zw *Writer, bw *bufio.Writer, c byte) error {
+			return bw.WriteByte(c)
+		})
+	})
+}
+
+func benchmarkWriterBytes(b *testing.B, data []byte, writeByte func(zw *Writer, bw *bufio.Writer, c byte) error) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	zw := NewWriter(ioutil.Discard)
+	defer zw.Release()
+	bw := bufio.NewWriter(zw)
+	for n := 0; n < b.N; n++ {
+		for _, c := range data {
+			if err := writeByte(zw, bw, c); err != nil {
+				panic(fmt.Errorf("unexpected error: %s", err))
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			panic(fmt.Errorf("unexpected error: %s", err))
+		}
+		if err := zw.Close(); err != nil {
+			panic(fmt.Errorf("unexpected error: %s", err))
+		}
+		zw.Reset(ioutil.Discard, nil, DefaultCompressionLevel)
+	}
+}